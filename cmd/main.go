@@ -1,17 +1,33 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+	_ "time/tzdata" // time zones of routing rules, the runtime image has no zoneinfo
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/mstgnz/self-hosted-url-shortener/database"
 	"github.com/mstgnz/self-hosted-url-shortener/handler"
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/blocklist"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/geoip"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/jobs"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/logger"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/metrics"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/ratelimit"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/shortcode"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/tracing"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/urlnorm"
 	"github.com/mstgnz/self-hosted-url-shortener/service"
 )
 
@@ -22,21 +38,185 @@ var (
 	baseURL      = flag.String("base-url", "http://localhost:8080", "Base URL for shortened URLs")
 	cliMode      = flag.Bool("cli", false, "Run in CLI mode")
 	templatesDir = flag.String("templates", "templates", "Templates directory")
+	cacheTTL     = flag.Duration("cache-ttl", 0, "Cache URL lookups for this long (0 disables caching)")
+	clickQueue   = flag.Int("click-queue-size", 1024, "Maximum number of clicks waiting to be recorded")
+	enableMetric = flag.Bool("metrics", true, "Expose Prometheus metrics at /metrics")
+	metricsAddr  = flag.String("metrics-addr", "", "Serve /metrics on a separate admin address (e.g. :9090) instead of the main port")
+	otlpEndpoint = flag.String("otlp-endpoint", "", "OTLP/HTTP collector host:port to export traces to (e.g. localhost:4318)")
+	otlpInsecure = flag.Bool("otlp-insecure", false, "Export traces to the OTLP collector over plain HTTP")
+	traceOutput  = flag.String("trace-output", "", "Write traces as JSON to 'stdout' or a file path for local debugging")
+	traceSample  = flag.Float64("trace-sample-ratio", 1, "Fraction of new traces to sample, between 0 and 1")
+	logFormat    = flag.String("log-format", "text", "Log format: json or text")
+	logLevel     = flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	codeMinLen   = flag.Int("code-min-length", shortcode.DefaultMinLength, "Minimum length of custom short codes")
+	codeMaxLen   = flag.Int("code-max-length", shortcode.DefaultMaxLength, "Maximum length of custom short codes")
+	codeBlocked  = flag.String("code-blocklist", "", "File of words (one per line) that short codes must not contain")
+	codeGen      = flag.String("code-generator", "random", "Short code generator: random, sequential, hashids, pronounceable or unambiguous")
+	codeLength   = flag.Int("code-length", 6, "Initial length of generated short codes")
+	codeAlphabet = flag.String("code-alphabet", shortcode.UnambiguousAlphabet, "Alphabet of the unambiguous code generator")
+	codeSalt     = flag.String("code-salt", "", "Salt obfuscating the codes of the hashids generator")
+	dedupe       = flag.Bool("dedupe", false, "Return the existing short URL when a destination is shortened again")
+	urlSchemes   = flag.String("url-schemes", "http,https", "Comma separated list of allowed destination URL schemes")
+	allowPrivate = flag.Bool("allow-private-urls", false, "Allow destinations on loopback, private and link-local addresses such as localhost")
+	resolveHosts = flag.Bool("resolve-hosts", false, "Resolve destination hosts to reject names pointing at private addresses")
+	stripTrack   = flag.Bool("strip-tracking", false, "Always remove tracking parameters such as utm_source from destinations")
+	blockFiles   = flag.String("blocklist", "", "Comma separated list of files of blocked destination domains, reloaded on SIGHUP")
+	adminToken   = flag.String("admin-token", "", "Bearer token required by the admin API (empty disables the admin API)")
+	rateCreate   = flag.String("rate-limit-create", "20/m", "Rate limit per client for creating short URLs, as count/period (0 disables)")
+	rateRedirect = flag.String("rate-limit-redirect", "0", "Rate limit per client for redirects, as count/period (0 disables)")
+	rateRead     = flag.String("rate-limit-read", "120/m", "Rate limit per client for listing and reading URLs, as count/period (0 disables)")
+	rateStore    = flag.String("rate-limit-store", "memory", "Rate limit store: memory, or sqlite to share limits between instances using the same database")
+	apiKeys      = flag.String("api-keys", "", "Comma separated list of API keys, requests with a known X-API-Key header are rate limited per key")
+	csrfKey      = flag.String("csrf-key", "", "Secret signing CSRF tokens, keeps forms valid across restarts and instances (random when empty)")
+	geoipDB      = flag.String("geoip-db", "", "MaxMind DB file (e.g. GeoLite2-Country.mmdb) locating visitors for country rules and click analytics (empty disables)")
+	redirectType = flag.String("redirect-type", "302", "Default redirect type of links: 301, 302, 307, 308, meta or js")
+	clickIPs     = flag.String("click-ips", "anonymize", "How IP addresses of visitors are stored with clicks: off, anonymize or full")
+	honorDNT     = flag.Bool("honor-dnt", true, "Store no visitor data of visits sending the DNT or Sec-GPC header")
+	visitorDays  = flag.Int("visitor-data-retention", 30, "Days to keep IP addresses and User-Agents of clicks (0 keeps them)")
+	clickDays    = flag.Int("click-retention", 0, "Days to keep click events before rolling them up into daily aggregates (0 keeps them)")
+	hookAttempts = flag.Int("webhook-attempts", 8, "Attempts to deliver a webhook event before giving up")
+	hookBackoff  = flag.Duration("webhook-backoff", 30*time.Second, "Delay before retrying a failed webhook delivery, doubling with every retry up to 6h")
+	hookLogDays  = flag.Int("webhook-log-retention", 30, "Days to keep delivered and failed webhook deliveries in the delivery log (0 keeps them)")
+)
+
+// Background job intervals
+const (
+	// retentionInterval is how often the retention periods are applied
+	retentionInterval = time.Hour
+
+	// webhookInterval is how often webhook deliveries are checked for retries that are due
+	webhookInterval = 5 * time.Second
 )
 
 func main() {
 	// Parse command line flags
 	flag.Parse()
 
+	// Set up logging
+	l, err := logger.New(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(l)
+
+	// Set up tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName:  "url-shortener",
+		OTLPEndpoint: *otlpEndpoint,
+		OTLPInsecure: *otlpInsecure,
+		Output:       *traceOutput,
+		SampleRatio:  *traceSample,
+	})
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to shut down tracing", "error", err)
+		}
+	}()
+
 	// Create database connection
 	db, err := database.New(*dbPath)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", err)
 	}
 	defer db.Close()
 
+	// Create the short code policy, reserving the paths served by the router
+	policy := shortcode.NewPolicy(*codeMinLen, *codeMaxLen)
+	policy.Reserve(handler.ReservedCodes()...)
+	if *enableMetric && *metricsAddr == "" {
+		policy.Reserve("metrics")
+	}
+	if *codeBlocked != "" {
+		words, err := shortcode.LoadWords(*codeBlocked)
+		if err != nil {
+			fatal("Failed to load code blocklist", err)
+		}
+		policy.Block(words...)
+	}
+
+	// Create the short code generator
+	if *codeLength < 1 || *codeLength > service.MaxCodeLength {
+		fatal("Invalid code length", fmt.Errorf("code length must be between 1 and %d", service.MaxCodeLength))
+	}
+	generator, err := shortcode.NewGenerator(*codeGen, shortcode.GeneratorConfig{
+		Alphabet: *codeAlphabet,
+		Salt:     *codeSalt,
+		Next: func(ctx context.Context) (int64, error) {
+			return db.NextSequence(ctx, "short_codes")
+		},
+	})
+	if err != nil {
+		fatal("Failed to create code generator", err)
+	}
+
+	// Create the destination URL normalizer
+	normalizerConfig := urlnorm.Config{
+		Schemes:       strings.Split(*urlSchemes, ","),
+		AllowPrivate:  *allowPrivate,
+		StripTracking: *stripTrack,
+	}
+	if *resolveHosts {
+		normalizerConfig.Resolver = net.DefaultResolver
+	}
+
+	// Load the destination blocklist
+	var blockPaths []string
+	if *blockFiles != "" {
+		blockPaths = strings.Split(*blockFiles, ",")
+	}
+	blocked := blocklist.New(blockPaths...)
+	if err := blocked.Reload(); err != nil {
+		fatal("Failed to load blocklist", err)
+	}
+
+	// Check the privacy settings
+	ipMode := service.IPMode(*clickIPs)
+	if !ipMode.Valid() {
+		fatal("Invalid IP mode", fmt.Errorf("unknown IP mode '%s', expected off, anonymize or full", *clickIPs))
+	}
+	if *visitorDays < 0 || *clickDays < 0 || *hookLogDays < 0 {
+		fatal("Invalid retention period", fmt.Errorf("retention periods must not be negative"))
+	}
+
+	// Check the webhook retries
+	if *hookAttempts < 1 {
+		fatal("Invalid webhook attempts", fmt.Errorf("webhook attempts must be at least 1"))
+	}
+	if *hookBackoff <= 0 {
+		fatal("Invalid webhook backoff", fmt.Errorf("webhook backoff must be positive"))
+	}
+
 	// Create URL service
-	urlService := service.New(db)
+	urlService := service.New(db,
+		service.WithCacheTTL(*cacheTTL),
+		service.WithCodePolicy(policy),
+		service.WithCodeGenerator(generator, *codeLength),
+		service.WithDedupe(*dedupe),
+		service.WithURLNormalizer(urlnorm.New(normalizerConfig)),
+		service.WithBlocklist(blocked),
+		service.WithIPMode(ipMode),
+		service.WithDoNotTrack(*honorDNT),
+		service.WithRetention(service.Retention{
+			VisitorData:       time.Duration(*visitorDays) * 24 * time.Hour,
+			Clicks:            time.Duration(*clickDays) * 24 * time.Hour,
+			WebhookDeliveries: time.Duration(*hookLogDays) * 24 * time.Hour,
+		}),
+		service.WithWebhookRetry(service.WebhookRetry{Attempts: *hookAttempts, Backoff: *hookBackoff}),
+	)
+	if err := urlService.LoadBlockedDomains(context.Background()); err != nil {
+		fatal("Failed to load blocked domains", err)
+	}
+	if n, err := urlService.HashDestinations(context.Background()); err != nil {
+		fatal("Failed to hash destinations", err)
+	} else if n > 0 {
+		slog.Info("Hashed destinations of existing URLs", "urls", n)
+	}
 
 	// Check if running in CLI mode
 	if *cliMode {
@@ -52,24 +232,92 @@ func main() {
 		return
 	}
 
+	// Create the rate limiter
+	limiter, err := newRateLimiter(db)
+	if err != nil {
+		fatal("Failed to create rate limiter", err)
+	}
+
+	// Check the default redirect type
+	defaultRedirect := model.RedirectType(*redirectType)
+	if defaultRedirect == model.RedirectDefault || !defaultRedirect.Valid() {
+		fatal("Invalid default redirect type", &model.ErrInvalidRedirectType{Type: *redirectType})
+	}
+
+	// Open the GeoIP database, visitors are not located without one
+	var geoDB *geoip.DB
+	if *geoipDB != "" {
+		geoDB, err = geoip.Open(*geoipDB)
+		if err != nil {
+			fatal("Failed to open GeoIP database", err)
+		}
+		defer geoDB.Close()
+	}
+
+	// Create click queue
+	clicks := service.NewClickQueue(urlService, *clickQueue, 1)
+	defer clicks.Close()
+
+	// Run background jobs until shutdown
+	runner := jobs.NewRunner()
+	if *visitorDays > 0 || *clickDays > 0 || *hookLogDays > 0 {
+		runner.Add(urlService.RetentionJob(retentionInterval))
+	}
+	runner.Start(context.Background())
+	defer runner.Stop()
+
+	// Deliver webhook events until shutdown, undelivered events are sent after a restart
+	webhooks := service.NewWebhookDispatcher(urlService, webhookInterval)
+	webhooks.Start(context.Background())
+	defer webhooks.Stop()
+
 	// Create HTTP handler
-	httpHandler, err := handler.NewHTTPHandler(urlService, *baseURL, *templatesDir)
+	httpHandler, err := handler.NewHTTPHandler(urlService, *baseURL, *templatesDir,
+		handler.WithClickQueue(clicks),
+		handler.WithAdminToken(*adminToken),
+		handler.WithRateLimiter(limiter),
+		handler.WithAPIKeys(strings.Split(*apiKeys, ",")...),
+		handler.WithCSRFKey([]byte(*csrfKey)),
+		handler.WithDefaultRedirect(defaultRedirect),
+		handler.WithGeoIP(geoDB),
+	)
 	if err != nil {
-		log.Fatalf("Failed to create HTTP handler: %v", err)
+		fatal("Failed to create HTTP handler", err)
 	}
 
 	// Create Chi router
 	router := chi.NewRouter()
 
-	// Add middleware
-	router.Use(middleware.Logger)
-	router.Use(middleware.Recoverer)
+	// Add middleware, request logging and panic recovery are set up by the HTTP handler
 	router.Use(middleware.RequestID)
 	router.Use(middleware.RealIP)
 
 	// Setup routes
 	httpHandler.SetupRoutes(router)
 
+	// Expose metrics on the main router or on a separate admin server
+	var metricsServer *http.Server
+	if *enableMetric {
+		if *metricsAddr == "" {
+			router.Handle("/metrics", metrics.Handler())
+		} else {
+			metricsRouter := chi.NewRouter()
+			metricsRouter.Handle("/metrics", metrics.Handler())
+			metricsServer = &http.Server{
+				Addr:         *metricsAddr,
+				Handler:      metricsRouter,
+				ReadTimeout:  10 * time.Second,
+				WriteTimeout: 10 * time.Second,
+			}
+			go func() {
+				slog.Info("Serving metrics", "addr", *metricsAddr)
+				if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					fatal("Failed to start metrics server", err)
+				}
+			}()
+		}
+	}
+
 	// Start HTTP server
 	addr := fmt.Sprintf(":%d", *port)
 	server := &http.Server{
@@ -79,9 +327,74 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
-	log.Printf("Starting server on %s", addr)
-	log.Printf("URL shortener available at %s", *baseURL)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Failed to start server: %v", err)
+	go func() {
+		slog.Info("Starting server", "addr", addr, "base_url", *baseURL)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("Failed to start server", err)
+		}
+	}()
+
+	// Reload the blocklist files on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := blocked.Reload(); err != nil {
+				slog.Error("Failed to reload blocklist, keeping the previous entries", "error", err)
+				continue
+			}
+			slog.Info("Reloaded blocklist", "domains", blocked.Len()[blocklist.SourceFile])
+		}
+	}()
+
+	// Wait for a termination signal and shut down gracefully so queued clicks are recorded
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	slog.Info("Shutting down server")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Failed to shut down server", "error", err)
 	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Error("Failed to shut down metrics server", "error", err)
+		}
+	}
+}
+
+// newRateLimiter creates the rate limiter configured by the command line flags
+func newRateLimiter(db *database.Database) (*ratelimit.Limiter, error) {
+	limits := make(map[string]ratelimit.Limit)
+	for class, value := range map[string]string{
+		handler.RateLimitCreate:   *rateCreate,
+		handler.RateLimitRedirect: *rateRedirect,
+		handler.RateLimitRead:     *rateRead,
+	} {
+		limit, err := ratelimit.ParseLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s limit: %w", class, err)
+		}
+		limits[class] = limit
+	}
+
+	var store ratelimit.Store
+	switch *rateStore {
+	case "memory":
+		store = ratelimit.NewMemoryStore()
+	case "sqlite":
+		store = db.RateLimitStore()
+	default:
+		return nil, fmt.Errorf("unknown rate limit store '%s', expected memory or sqlite", *rateStore)
+	}
+
+	return ratelimit.NewLimiter(store, limits), nil
+}
+
+// fatal logs an error and exits the process
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
@@ -1,23 +1,103 @@
 package database
 
-import "github.com/mstgnz/self-hosted-url-shortener/model"
+import (
+	"context"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
 
 // DatabaseInterface defines the interface for database operations
 type DatabaseInterface interface {
 	// SaveURL saves a URL to the database
-	SaveURL(url *model.URL) error
+	SaveURL(ctx context.Context, url *model.URL) error
 
 	// GetURLByShortCode retrieves a URL by its short code
-	GetURLByShortCode(shortCode string) (*model.URL, error)
+	GetURLByShortCode(ctx context.Context, shortCode string) (*model.URL, error)
+
+	// GetURLByDestHash retrieves the oldest URL whose destination has the given hash
+	GetURLByDestHash(ctx context.Context, destHash string) (*model.URL, error)
+
+	// URLsWithoutDestHash returns the URLs without variants saved before destinations were hashed
+	URLsWithoutDestHash(ctx context.Context) ([]*model.URL, error)
+
+	// SetDestHashes sets the destination hashes of URLs by their ID
+	SetDestHashes(ctx context.Context, hashes map[int64]string) error
+
+	// RecordClick increments the click counts of a URL and its variant and stores the click event
+	RecordClick(ctx context.Context, click model.Click) error
+
+	// ClickStats aggregates the click events of a URL within a time range
+	ClickStats(ctx context.Context, shortCode string, from, until time.Time, interval model.StatsInterval, limit int) (*model.ClickStats, error)
+
+	// VisitorSalt returns the random salt hashing the visitors of a day
+	VisitorSalt(ctx context.Context, day time.Time) ([]byte, error)
+
+	// EraseVisitorData clears the IP addresses and User-Agents of the click events before a time
+	EraseVisitorData(ctx context.Context, before time.Time) (int64, error)
+
+	// OldestClickDay returns the start of the UTC day of the oldest click event before a time
+	OldestClickDay(ctx context.Context, before time.Time) (time.Time, error)
+
+	// RollUpClicks adds the click events of a UTC day to the daily aggregates and deletes them
+	RollUpClicks(ctx context.Context, day time.Time) (int64, error)
+
+	// RebuildRollups recomputes the hourly and daily click rollups from the click events and daily aggregates
+	RebuildRollups(ctx context.Context) error
+
+	// CompactRollups deletes the hourly click rollups before a time
+	CompactRollups(ctx context.Context, before time.Time) (int64, error)
+
+	// UpdateRules replaces the routing rules of a URL
+	UpdateRules(ctx context.Context, shortCode string, rules []model.Rule) error
 
-	// IncrementClicks increments the click count for a URL
-	IncrementClicks(shortCode string) error
+	// UpdateSchedule replaces the activation window and scheduled changes of a URL
+	UpdateSchedule(ctx context.Context, shortCode string, schedule model.Schedule) error
 
-	// ListURLs returns all URLs in the database
-	ListURLs() ([]*model.URL, error)
+	// ListURLs returns the URLs in the database matching a filter
+	ListURLs(ctx context.Context, filter model.URLFilter) ([]*model.URL, error)
 
 	// DeleteURL deletes a URL from the database
-	DeleteURL(shortCode string) error
+	DeleteURL(ctx context.Context, shortCode string) error
+
+	// NextSequence increments the named counter and returns its new value, starting at 1
+	NextSequence(ctx context.Context, name string) (int64, error)
+
+	// ListBlockedDomains returns the domains blocked by an administrator
+	ListBlockedDomains(ctx context.Context) ([]*model.BlockedDomain, error)
+
+	// AddBlockedDomain blocks a domain, adding an existing domain is a no-op
+	AddBlockedDomain(ctx context.Context, domain string) error
+
+	// RemoveBlockedDomain unblocks a domain
+	RemoveBlockedDomain(ctx context.Context, domain string) error
+
+	// CreateWebhook saves a webhook and sets its ID
+	CreateWebhook(ctx context.Context, webhook *model.Webhook) error
+
+	// ListWebhooks returns all webhooks including their secrets
+	ListWebhooks(ctx context.Context) ([]*model.Webhook, error)
+
+	// DeleteWebhook deletes a webhook together with its deliveries
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// EnqueueWebhookEvent queues the delivery of an event to every webhook subscribing to it
+	EnqueueWebhookEvent(ctx context.Context, event model.WebhookEvent, payload []byte, at time.Time) (int64, error)
+
+	// ClaimWebhookDeliveries returns pending deliveries that are due, postponing them by a lease
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.WebhookDelivery, error)
+
+	// RecordWebhookAttempt records an attempt to deliver a webhook delivery and sets its status
+	RecordWebhookAttempt(ctx context.Context, deliveryID int64, attempt model.WebhookAttempt, status model.WebhookDeliveryStatus, next time.Time) error
+
+	// ListWebhookDeliveries returns the latest deliveries of a webhook with their attempts
+	ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*model.WebhookDelivery, error)
+
+	// DeleteWebhookDeliveries deletes the finished webhook deliveries created before a time
+	DeleteWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+
+	// Ready checks that the database is reachable and its schema is up to date
+	Ready(ctx context.Context) error
 
 	// Close closes the database connection
 	Close() error
@@ -0,0 +1,325 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// migration is a versioned schema change
+type migration struct {
+	version     int
+	description string
+	query       string
+}
+
+// migrations lists the schema changes in the order they are applied.
+// Applied migrations must never be edited, add a new one instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create urls table",
+		query: `
+		CREATE TABLE IF NOT EXISTS urls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			short_code TEXT UNIQUE NOT NULL,
+			long_url TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			clicks INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_short_code ON urls(short_code);
+		`,
+	},
+	{
+		version:     2,
+		description: "create sequences table",
+		query: `
+		CREATE TABLE sequences (
+			name TEXT PRIMARY KEY,
+			value INTEGER NOT NULL
+		);
+		`,
+	},
+	{
+		version:     3,
+		description: "add destination hash to urls",
+		query: `
+		ALTER TABLE urls ADD COLUMN dest_hash TEXT;
+		CREATE INDEX idx_urls_dest_hash ON urls(dest_hash);
+		`,
+	},
+	{
+		version:     4,
+		description: "create blocked domains table",
+		query: `
+		CREATE TABLE blocked_domains (
+			domain TEXT PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL
+		);
+		`,
+	},
+	{
+		version:     5,
+		description: "create rate limits table",
+		query: `
+		CREATE TABLE rate_limits (
+			key TEXT PRIMARY KEY,
+			tokens REAL NOT NULL,
+			allowed INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			full_at INTEGER NOT NULL
+		);
+		CREATE INDEX idx_rate_limits_full_at ON rate_limits(full_at);
+		`,
+	},
+	{
+		version:     6,
+		description: "add redirect type to urls",
+		query: `
+		ALTER TABLE urls ADD COLUMN redirect_type TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     7,
+		description: "add passthrough options to urls",
+		query: `
+		ALTER TABLE urls ADD COLUMN path_passthrough INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE urls ADD COLUMN query_passthrough TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     8,
+		description: "add campaign parameters to urls",
+		query: `
+		ALTER TABLE urls ADD COLUMN utm_source TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN utm_medium TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN utm_campaign TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN utm_term TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN utm_content TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_urls_utm_campaign ON urls(utm_campaign);
+		`,
+	},
+	{
+		version:     9,
+		description: "create url_variants and click_events tables",
+		query: `
+		ALTER TABLE urls ADD COLUMN sticky_variants INTEGER NOT NULL DEFAULT 0;
+		CREATE TABLE IF NOT EXISTS url_variants (
+			url_id INTEGER NOT NULL,
+			position INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			long_url TEXT NOT NULL,
+			weight INTEGER NOT NULL,
+			clicks INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (url_id, name)
+		);
+		CREATE TABLE IF NOT EXISTS click_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			short_code TEXT NOT NULL,
+			variant TEXT NOT NULL DEFAULT '',
+			clicked_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_click_events_short_code ON click_events(short_code, clicked_at);
+		`,
+	},
+	{
+		version:     10,
+		description: "add routing rules to urls",
+		query: `
+		ALTER TABLE urls ADD COLUMN rules TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     11,
+		description: "add visitor location to click_events",
+		query: `
+		ALTER TABLE click_events ADD COLUMN country TEXT NOT NULL DEFAULT '';
+		ALTER TABLE click_events ADD COLUMN region TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     12,
+		description: "add activation windows and scheduled changes to urls",
+		query: `
+		ALTER TABLE urls ADD COLUMN active_from TIMESTAMP;
+		ALTER TABLE urls ADD COLUMN active_until TIMESTAMP;
+		ALTER TABLE urls ADD COLUMN schedule_changes TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     13,
+		description: "add referrer, browser, os and language to click_events",
+		query: `
+		ALTER TABLE click_events ADD COLUMN referrer TEXT NOT NULL DEFAULT '';
+		ALTER TABLE click_events ADD COLUMN browser TEXT NOT NULL DEFAULT '';
+		ALTER TABLE click_events ADD COLUMN os TEXT NOT NULL DEFAULT '';
+		ALTER TABLE click_events ADD COLUMN language TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		version:     14,
+		description: "count bot visits separately",
+		query: `
+		ALTER TABLE urls ADD COLUMN bot_clicks INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE click_events ADD COLUMN bot INTEGER NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		version:     15,
+		description: "count unique visitors",
+		query: `
+		ALTER TABLE urls ADD COLUMN uniques INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE click_events ADD COLUMN visitor TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_click_events_visitor ON click_events(short_code, visitor);
+		CREATE TABLE IF NOT EXISTS visitor_salts (
+			day TEXT PRIMARY KEY,
+			salt BLOB NOT NULL
+		);
+		`,
+	},
+	{
+		version:     16,
+		description: "store visitor data of click_events and create click_aggregates table",
+		query: `
+		ALTER TABLE click_events ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+		ALTER TABLE click_events ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+		CREATE INDEX IF NOT EXISTS idx_click_events_clicked_at ON click_events(clicked_at);
+		CREATE TABLE IF NOT EXISTS click_aggregates (
+			short_code TEXT NOT NULL,
+			day TIMESTAMP NOT NULL,
+			dimension TEXT NOT NULL,
+			value TEXT NOT NULL,
+			clicks INTEGER NOT NULL DEFAULT 0,
+			uniques INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (short_code, day, dimension, value)
+		);
+		`,
+	},
+	{
+		version:     17,
+		description: "create click_rollups_hourly and click_rollups_daily tables",
+		query: `
+		CREATE TABLE IF NOT EXISTS click_rollups_hourly (
+			short_code TEXT NOT NULL,
+			bucket TEXT NOT NULL,
+			clicks INTEGER NOT NULL DEFAULT 0,
+			uniques INTEGER NOT NULL DEFAULT 0,
+			bots INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (short_code, bucket)
+		);
+		CREATE TABLE IF NOT EXISTS click_rollups_daily (
+			short_code TEXT NOT NULL,
+			bucket TEXT NOT NULL,
+			clicks INTEGER NOT NULL DEFAULT 0,
+			uniques INTEGER NOT NULL DEFAULT 0,
+			bots INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (short_code, bucket)
+		);
+		` + backfillRollupsQuery,
+	},
+	{
+		version:     18,
+		description: "create webhooks, webhook_deliveries and webhook_attempts tables",
+		query: `
+		CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			events TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			next_attempt_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id, id);
+		CREATE TABLE IF NOT EXISTS webhook_attempts (
+			delivery_id INTEGER NOT NULL,
+			attempt INTEGER NOT NULL,
+			attempted_at TIMESTAMP NOT NULL,
+			duration_ms INTEGER NOT NULL,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (delivery_id, attempt)
+		);
+		`,
+	},
+	{
+		version:     19,
+		description: "add strip tracking option to urls",
+		query: `
+		ALTER TABLE urls ADD COLUMN strip_tracking INTEGER NOT NULL DEFAULT 0;
+		`,
+	},
+}
+
+// latestSchemaVersion returns the version of the last migration
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate applies all pending migrations, each in its own transaction
+func (d *Database) migrate(ctx context.Context) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	);
+	`
+	if _, err := d.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	current, err := d.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := d.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", m.version, err)
+		}
+
+		if _, err := tx.ExecContext(ctx, m.query); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.version, m.description, err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`,
+			m.version, m.description, time.Now(),
+		)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", m.version, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// schemaVersion returns the version of the last applied migration
+func (d *Database) schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := d.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/ratelimit"
+)
+
+// rateLimitPruneInterval is how often buckets that refilled completely are deleted
+const rateLimitPruneInterval = time.Minute
+
+// takeTokenQuery takes a token from a bucket in a single statement, so
+// instances sharing the database never hand out the same token twice.
+// Parameters: ?1 key, ?2 burst, ?3 now in nanoseconds, ?4 rate per second.
+var takeTokenQuery = func() string {
+	available := "MIN(?2, tokens + MAX(?3 - updated_at, 0) * ?4 / 1e9)"
+	remaining := strings.ReplaceAll("CASE WHEN {a} >= 1 THEN {a} - 1 ELSE {a} END", "{a}", available)
+
+	return `
+	INSERT INTO rate_limits (key, tokens, allowed, updated_at, full_at)
+	VALUES (?1, ?2 - 1, 1, ?3, ?3 + CAST(1e9 / ?4 AS INTEGER))
+	ON CONFLICT(key) DO UPDATE SET
+		tokens = ` + remaining + `,
+		allowed = CASE WHEN ` + available + ` >= 1 THEN 1 ELSE 0 END,
+		updated_at = MAX(?3, updated_at),
+		full_at = MAX(?3, updated_at) + CAST((?2 - (` + remaining + `)) * 1e9 / ?4 AS INTEGER)
+	RETURNING tokens, allowed
+	`
+}()
+
+// RateLimitStore keeps token buckets in the database, letting several
+// instances sharing the database enforce common limits
+type RateLimitStore struct {
+	db *Database
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// RateLimitStore returns a rate limit store backed by the database
+func (d *Database) RateLimitStore() *RateLimitStore {
+	return &RateLimitStore{db: d}
+}
+
+// Take takes a token from the bucket of key
+func (s *RateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (_ ratelimit.Result, err error) {
+	s.prune(ctx, now)
+
+	ctx, end := startQuery(ctx, "take_rate_limit_token")
+	defer end(&err)
+
+	var tokens float64
+	var allowed bool
+	err = s.db.db.QueryRowContext(ctx, takeTokenQuery, key, limit.Burst, now.UnixNano(), limit.Rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+
+	return ratelimit.NewResult(limit, allowed, tokens), nil
+}
+
+// prune deletes buckets that refilled completely, they are equivalent to new buckets
+func (s *RateLimitStore) prune(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPrune) < rateLimitPruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	s.mu.Unlock()
+
+	var err error
+	ctx, end := startQuery(ctx, "prune_rate_limits")
+	defer end(&err)
+
+	_, err = s.db.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE full_at < ?`, now.UnixNano())
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to prune rate limits", "error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EraseVisitorData clears the IP addresses and User-Agents of the click events
+// before before, returning the number of click events changed
+func (d *Database) EraseVisitorData(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, end := startQuery(ctx, "erase_visitor_data")
+	defer end(&err)
+
+	query := `
+	UPDATE click_events
+	SET ip = '', user_agent = ''
+	WHERE clicked_at < ? AND (ip != '' OR user_agent != '')
+	`
+
+	result, err := d.db.ExecContext(ctx, query, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to erase visitor data: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return n, nil
+}
+
+// OldestClickDay returns the start of the UTC day of the oldest click event
+// before before, or the zero time when there is none
+func (d *Database) OldestClickDay(ctx context.Context, before time.Time) (_ time.Time, err error) {
+	ctx, end := startQuery(ctx, "oldest_click_day")
+	defer end(&err)
+
+	query := `
+	SELECT clicked_at
+	FROM click_events
+	WHERE clicked_at < ?
+	ORDER BY clicked_at
+	LIMIT 1
+	`
+
+	var oldest time.Time
+	err = d.db.QueryRowContext(ctx, query, before.UTC()).Scan(&oldest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to find oldest click: %w", err)
+	}
+	return oldest.UTC().Truncate(24 * time.Hour), nil
+}
+
+// RollUpClicks adds the click events of the UTC day starting at day to the
+// daily aggregates of their URL and deletes them, returning the number of
+// click events rolled up
+func (d *Database) RollUpClicks(ctx context.Context, day time.Time) (_ int64, err error) {
+	ctx, end := startQuery(ctx, "roll_up_clicks")
+	defer end(&err)
+
+	day = day.UTC().Truncate(24 * time.Hour)
+	next := day.Add(24 * time.Hour)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Every dimension is grouped by a column, the totals and bot visits by constants
+	type dimension struct {
+		name, value, uniques, filter string
+	}
+	dimensions := []dimension{
+		{dimensionTotal, "''", "COUNT(DISTINCT NULLIF(visitor, ''))", "bot = 0"},
+		{dimensionBot, "''", "0", "bot = 1"},
+	}
+	for _, column := range statsColumns {
+		dimensions = append(dimensions, dimension{column, column, "COUNT(DISTINCT NULLIF(visitor, ''))", "bot = 0"})
+	}
+
+	for _, dim := range dimensions {
+		query := `
+		INSERT INTO click_aggregates (short_code, day, dimension, value, clicks, uniques)
+		SELECT short_code, ?1, ?2, ` + dim.value + `, COUNT(*), ` + dim.uniques + `
+		FROM click_events
+		WHERE clicked_at >= ?1 AND clicked_at < ?3 AND ` + dim.filter + `
+		GROUP BY short_code, ` + dim.value + `
+		ON CONFLICT (short_code, day, dimension, value) DO UPDATE SET
+			clicks = clicks + excluded.clicks,
+			uniques = uniques + excluded.uniques
+		`
+		if _, err := tx.ExecContext(ctx, query, day, dim.name, next); err != nil {
+			return 0, fmt.Errorf("failed to aggregate clicks by '%s': %w", dim.name, err)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM click_events WHERE clicked_at >= ? AND clicked_at < ?`, day, next)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete rolled up clicks: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit roll up: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// rollupTables are the tables counting the clicks of every URL per bucket of
+// an interval. Buckets are keyed by their start in UTC formatted as
+// time.DateTime, so they compare in time order.
+var rollupTables = map[model.StatsInterval]string{
+	model.StatsHourly: "click_rollups_hourly",
+	model.StatsDaily:  "click_rollups_daily",
+}
+
+// rollupBucket returns the key of the bucket of interval containing t
+func rollupBucket(t time.Time, interval model.StatsInterval) string {
+	return t.UTC().Truncate(interval.Duration()).Format(time.DateTime)
+}
+
+// fillRollupsQuery replaces the rollups of interval with counts of the click
+// events and the daily aggregates of rolled up click events, which count at
+// the start of their day
+func fillRollupsQuery(interval model.StatsInterval) string {
+	return strings.NewReplacer("{table}", rollupTables[interval], "{format}", bucketFormats[interval]).Replace(`
+	DELETE FROM {table};
+	INSERT INTO {table} (short_code, bucket, clicks, uniques, bots)
+	SELECT short_code, bucket, SUM(clicks), SUM(uniques), SUM(bots)
+	FROM (
+		SELECT short_code, strftime('{format}', clicked_at) AS bucket,
+			SUM(bot = 0) AS clicks,
+			COUNT(DISTINCT CASE WHEN bot = 0 THEN NULLIF(visitor, '') END) AS uniques,
+			SUM(bot = 1) AS bots
+		FROM click_events
+		GROUP BY short_code, bucket
+		UNION ALL
+		SELECT short_code, strftime('%Y-%m-%d 00:00:00', day),
+			SUM(CASE WHEN dimension = '' THEN clicks ELSE 0 END),
+			SUM(CASE WHEN dimension = '' THEN uniques ELSE 0 END),
+			SUM(CASE WHEN dimension = 'bot' THEN clicks ELSE 0 END)
+		FROM click_aggregates
+		WHERE dimension IN ('', 'bot')
+		GROUP BY short_code, day
+	)
+	GROUP BY short_code, bucket;
+	`)
+}
+
+// backfillRollupsQuery fills the rollups of a database created before they existed
+var backfillRollupsQuery = fillRollupsQuery(model.StatsHourly) + fillRollupsQuery(model.StatsDaily)
+
+// rebuildRollupsQuery recomputes the rollups, keeping the hourly rollups of
+// days rolled up into daily aggregates, whose hours the aggregates do not tell
+var rebuildRollupsQuery = strings.NewReplacer("{table}", rollupTables[model.StatsHourly], "{format}", bucketFormats[model.StatsHourly]).Replace(`
+	DELETE FROM {table}
+	WHERE NOT EXISTS (
+		SELECT 1 FROM click_aggregates
+		WHERE short_code = {table}.short_code AND strftime('%Y-%m-%d', day) = substr({table}.bucket, 1, 10)
+	);
+	INSERT INTO {table} (short_code, bucket, clicks, uniques, bots)
+	SELECT short_code, strftime('{format}', clicked_at) AS bucket,
+		SUM(bot = 0),
+		COUNT(DISTINCT CASE WHEN bot = 0 THEN NULLIF(visitor, '') END),
+		SUM(bot = 1)
+	FROM click_events
+	WHERE NOT EXISTS (
+		SELECT 1 FROM click_aggregates
+		WHERE short_code = click_events.short_code AND strftime('%Y-%m-%d', day) = strftime('%Y-%m-%d', click_events.clicked_at)
+	)
+	GROUP BY short_code, bucket;
+	`) + fillRollupsQuery(model.StatsDaily)
+
+// addToRollups counts a click in the rollups within tx. firstOfHour and
+// firstOfDay tell whether the click is the first of its visitor in its hour
+// and day.
+func addToRollups(ctx context.Context, tx *sql.Tx, click model.Click, firstOfHour, firstOfDay bool) error {
+	var clicks, bots int
+	if click.Bot {
+		bots = 1
+	} else {
+		clicks = 1
+	}
+
+	for interval, first := range map[model.StatsInterval]bool{model.StatsHourly: firstOfHour, model.StatsDaily: firstOfDay} {
+		var uniques int
+		if first {
+			uniques = 1
+		}
+
+		query := `
+		INSERT INTO ` + rollupTables[interval] + ` (short_code, bucket, clicks, uniques, bots)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (short_code, bucket) DO UPDATE SET
+			clicks = clicks + excluded.clicks,
+			uniques = uniques + excluded.uniques,
+			bots = bots + excluded.bots
+		`
+		_, err := tx.ExecContext(ctx, query, click.ShortCode, rollupBucket(click.ClickedAt, interval), clicks, uniques, bots)
+		if err != nil {
+			return fmt.Errorf("failed to update %s rollup: %w", interval, err)
+		}
+	}
+	return nil
+}
+
+// rollupSeries returns the clicks and unique visitors per bucket of interval
+// of a URL from from until until, leaving out buckets without clicks, and the
+// number of bot visits within the range
+func (d *Database) rollupSeries(ctx context.Context, shortCode string, from, until time.Time, interval model.StatsInterval) ([]model.StatsPoint, int64, error) {
+	table, ok := rollupTables[interval]
+	if !ok {
+		return nil, 0, &model.ErrInvalidStatsRange{Reason: fmt.Sprintf("unknown interval '%s'", interval)}
+	}
+
+	query := `
+	SELECT bucket, clicks, uniques, bots
+	FROM ` + table + `
+	WHERE short_code = ? AND bucket >= ? AND bucket < ?
+	ORDER BY bucket
+	`
+
+	rows, err := d.db.QueryContext(ctx, query, shortCode, from.UTC().Format(time.DateTime), until.UTC().Format(time.DateTime))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s rollups: %w", interval, err)
+	}
+	defer rows.Close()
+
+	var series []model.StatsPoint
+	var bots int64
+	for rows.Next() {
+		var bucket string
+		var point model.StatsPoint
+		var bucketBots int64
+		if err := rows.Scan(&bucket, &point.Clicks, &point.Uniques, &bucketBots); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan %s rollup: %w", interval, err)
+		}
+		if point.Time, err = time.Parse(time.DateTime, bucket); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse bucket '%s': %w", bucket, err)
+		}
+		bots += bucketBots
+		if point.Clicks > 0 {
+			series = append(series, point)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating %s rollups: %w", interval, err)
+	}
+	return series, bots, nil
+}
+
+// RebuildRollups recomputes the hourly and daily rollups of all URLs from the
+// click events and daily aggregates. Days whose click events were rolled up
+// into daily aggregates keep their hourly rollups, as their hours can no
+// longer be recomputed.
+func (d *Database) RebuildRollups(ctx context.Context) (err error) {
+	ctx, end := startQuery(ctx, "rebuild_rollups")
+	defer end(&err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, rebuildRollupsQuery); err != nil {
+		return fmt.Errorf("failed to rebuild rollups: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rollups: %w", err)
+	}
+	return nil
+}
+
+// CompactRollups deletes the hourly rollups of buckets before before, keeping
+// the daily rollups, and returns the number of hourly rollups deleted
+func (d *Database) CompactRollups(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, end := startQuery(ctx, "compact_rollups")
+	defer end(&err)
+
+	query := `DELETE FROM ` + rollupTables[model.StatsHourly] + ` WHERE bucket < ?`
+	result, err := d.db.ExecContext(ctx, query, before.UTC().Format(time.DateTime))
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact rollups: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return n, nil
+}
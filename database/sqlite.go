@@ -1,23 +1,49 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/metrics"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// tracer creates spans for database queries
+var tracer = otel.Tracer("github.com/mstgnz/self-hosted-url-shortener/database")
+
 // Database represents the SQLite database connection
 type Database struct {
 	db *sql.DB
 }
 
+// urlColumns lists the columns of the urls table in the order scanned by scanURL
+const urlColumns = `id, short_code, long_url, created_at, clicks, bot_clicks, uniques, COALESCE(dest_hash, ''), redirect_type,
+	path_passthrough, query_passthrough, utm_source, utm_medium, utm_campaign, utm_term, utm_content, strip_tracking,
+	sticky_variants, rules, active_from, active_until, schedule_changes`
+
+// busyTimeout is how long a connection waits for a lock held by another connection, in milliseconds
+const busyTimeout = 5000
+
 // New creates a new database connection
 func New(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// Wait for locks instead of failing when several connections write at once
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+	dsn := fmt.Sprintf("%s%s_busy_timeout=%d", dbPath, separator, busyTimeout)
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -31,7 +57,7 @@ func New(dbPath string) (*Database, error) {
 	database := &Database{db: db}
 
 	// Initialize the database schema
-	if err := database.initSchema(); err != nil {
+	if err := database.migrate(context.Background()); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to initialize schema: %w", err)
 	}
@@ -44,31 +70,86 @@ func (d *Database) Close() error {
 	return d.db.Close()
 }
 
-// initSchema initializes the database schema
-func (d *Database) initSchema() error {
+// NextSequence increments the named counter and returns its new value, starting at 1
+func (d *Database) NextSequence(ctx context.Context, name string) (_ int64, err error) {
+	ctx, end := startQuery(ctx, "next_sequence")
+	defer end(&err)
+
 	query := `
-	CREATE TABLE IF NOT EXISTS urls (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		short_code TEXT UNIQUE NOT NULL,
-		long_url TEXT NOT NULL,
-		created_at TIMESTAMP NOT NULL,
-		clicks INTEGER NOT NULL DEFAULT 0
-	);
-	CREATE INDEX IF NOT EXISTS idx_short_code ON urls(short_code);
+	INSERT INTO sequences (name, value)
+	VALUES (?, 1)
+	ON CONFLICT(name) DO UPDATE SET value = value + 1
+	RETURNING value
 	`
 
-	_, err := d.db.Exec(query)
-	return err
+	var value int64
+	if err := d.db.QueryRowContext(ctx, query, name).Scan(&value); err != nil {
+		return 0, fmt.Errorf("failed to advance sequence: %w", err)
+	}
+
+	return value, nil
+}
+
+// Ready checks that the database is reachable and all migrations are applied
+func (d *Database) Ready(ctx context.Context) (err error) {
+	ctx, end := startQuery(ctx, "ready")
+	defer end(&err)
+
+	if err := d.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+
+	version, err := d.schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if version < latestSchemaVersion() {
+		return fmt.Errorf("schema version %d is behind %d", version, latestSchemaVersion())
+	}
+
+	return nil
 }
 
-// SaveURL saves a URL to the database
-func (d *Database) SaveURL(url *model.URL) error {
+// SaveURL saves a URL and its variants to the database. Uniqueness of the short
+// code is enforced by the database, a taken code returns ErrCustomCodeAlreadyExists.
+func (d *Database) SaveURL(ctx context.Context, url *model.URL) (err error) {
+	ctx, end := startQuery(ctx, "save_url")
+	defer end(&err)
+
+	rules, err := encodeList("rules", url.Rules)
+	if err != nil {
+		return err
+	}
+	changes, err := encodeList("scheduled changes", url.Schedule.Changes)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `
-	INSERT INTO urls (short_code, long_url, created_at, clicks)
-	VALUES (?, ?, ?, ?)
+	INSERT INTO urls (
+		short_code, long_url, created_at, clicks, bot_clicks, uniques, dest_hash, redirect_type, path_passthrough, query_passthrough,
+		utm_source, utm_medium, utm_campaign, utm_term, utm_content, strip_tracking, sticky_variants, rules,
+		active_from, active_until, schedule_changes
+	)
+	VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	result, err := d.db.Exec(query, url.ShortCode, url.LongURL, url.CreatedAt, url.Clicks)
+	result, err := tx.ExecContext(ctx, query,
+		url.ShortCode, url.LongURL, url.CreatedAt, url.Clicks, url.BotClicks, url.Uniques, url.DestHash,
+		url.RedirectType, url.PathPassthrough, url.QueryPassthrough,
+		url.UTM.Source, url.UTM.Medium, url.UTM.Campaign, url.UTM.Term, url.UTM.Content,
+		url.StripTracking, url.StickyVariants, rules,
+		url.Schedule.ActiveFrom, url.Schedule.ActiveUntil, changes,
+	)
+	if isUniqueViolation(err) {
+		return &model.ErrCustomCodeAlreadyExists{Code: url.ShortCode}
+	}
 	if err != nil {
 		return fmt.Errorf("failed to save URL: %w", err)
 	}
@@ -78,27 +159,36 @@ func (d *Database) SaveURL(url *model.URL) error {
 		return fmt.Errorf("failed to get last insert ID: %w", err)
 	}
 
+	for i, variant := range url.Variants {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO url_variants (url_id, position, name, long_url, weight, clicks) VALUES (?, ?, ?, ?, ?, ?)`,
+			id, i, variant.Name, variant.LongURL, variant.Weight, variant.Clicks,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save variant '%s': %w", variant.Name, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit URL: %w", err)
+	}
+
 	url.ID = id
 	return nil
 }
 
 // GetURLByShortCode retrieves a URL by its short code
-func (d *Database) GetURLByShortCode(shortCode string) (*model.URL, error) {
+func (d *Database) GetURLByShortCode(ctx context.Context, shortCode string) (_ *model.URL, err error) {
+	ctx, end := startQuery(ctx, "get_url")
+	defer end(&err)
+
 	query := `
-	SELECT id, short_code, long_url, created_at, clicks
+	SELECT ` + urlColumns + `
 	FROM urls
 	WHERE short_code = ?
 	`
 
-	var url model.URL
-	err := d.db.QueryRow(query, shortCode).Scan(
-		&url.ID,
-		&url.ShortCode,
-		&url.LongURL,
-		&url.CreatedAt,
-		&url.Clicks,
-	)
-
+	url, err := scanURL(d.db.QueryRowContext(ctx, query, shortCode))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -107,34 +197,275 @@ func (d *Database) GetURLByShortCode(shortCode string) (*model.URL, error) {
 		return nil, fmt.Errorf("failed to get URL: %w", err)
 	}
 
-	return &url, nil
+	if err := d.loadVariants(ctx, url); err != nil {
+		return nil, err
+	}
+
+	return url, nil
 }
 
-// IncrementClicks increments the click count for a URL
-func (d *Database) IncrementClicks(shortCode string) error {
+// GetURLByDestHash retrieves the oldest URL whose destination has the given hash
+func (d *Database) GetURLByDestHash(ctx context.Context, destHash string) (_ *model.URL, err error) {
+	ctx, end := startQuery(ctx, "get_url_by_dest_hash")
+	defer end(&err)
+
+	query := `
+	SELECT ` + urlColumns + `
+	FROM urls
+	WHERE dest_hash = ?
+	ORDER BY id
+	LIMIT 1
+	`
+
+	url, err := scanURL(d.db.QueryRowContext(ctx, query, destHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get URL by destination: %w", err)
+	}
+
+	if err := d.loadVariants(ctx, url); err != nil {
+		return nil, err
+	}
+
+	return url, nil
+}
+
+// UpdateRules replaces the routing rules of a URL, returning ErrURLNotFound for unknown short codes
+func (d *Database) UpdateRules(ctx context.Context, shortCode string, rules []model.Rule) (err error) {
+	ctx, end := startQuery(ctx, "update_rules")
+	defer end(&err)
+
+	encoded, err := encodeList("rules", rules)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE urls
+	SET rules = ?
+	WHERE short_code = ?
+	`
+
+	result, err := d.db.ExecContext(ctx, query, encoded, shortCode)
+	if err != nil {
+		return fmt.Errorf("failed to update rules: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 0 {
+		return &model.ErrURLNotFound{Code: shortCode}
+	}
+	return nil
+}
+
+// UpdateSchedule replaces the activation window and scheduled changes of a URL,
+// returning ErrURLNotFound for unknown short codes
+func (d *Database) UpdateSchedule(ctx context.Context, shortCode string, schedule model.Schedule) (err error) {
+	ctx, end := startQuery(ctx, "update_schedule")
+	defer end(&err)
+
+	changes, err := encodeList("scheduled changes", schedule.Changes)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE urls
+	SET active_from = ?, active_until = ?, schedule_changes = ?
+	WHERE short_code = ?
+	`
+
+	result, err := d.db.ExecContext(ctx, query, schedule.ActiveFrom, schedule.ActiveUntil, changes, shortCode)
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 0 {
+		return &model.ErrURLNotFound{Code: shortCode}
+	}
+	return nil
+}
+
+// RecordClick increments the click counts of a URL and the variant served,
+// stores the click event with its time in UTC and counts it in the hourly and
+// daily rollups. The first click of a visitor increments the unique visitors
+// of the URL. Bot visits only increment the bot counts of the URL. Clicks on
+// unknown short codes are ignored.
+func (d *Database) RecordClick(ctx context.Context, click model.Click) (err error) {
+	ctx, end := startQuery(ctx, "record_click")
+	defer end(&err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
 	query := `
 	UPDATE urls
 	SET clicks = clicks + 1
 	WHERE short_code = ?
 	`
+	if click.Bot {
+		query = `
+		UPDATE urls
+		SET bot_clicks = bot_clicks + 1
+		WHERE short_code = ?
+		`
+	}
 
-	_, err := d.db.Exec(query, shortCode)
+	result, err := tx.ExecContext(ctx, query, click.ShortCode)
 	if err != nil {
 		return fmt.Errorf("failed to increment clicks: %w", err)
 	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	var firstOfHour, firstOfDay bool
+	if click.Visitor != "" && !click.Bot {
+		// Visitor hashes change daily, so earlier clicks of the visitor are from the same day
+		query := `
+		SELECT
+			NOT EXISTS (SELECT 1 FROM click_events WHERE short_code = ?1 AND visitor = ?2 AND clicked_at >= ?3),
+			NOT EXISTS (SELECT 1 FROM click_events WHERE short_code = ?1 AND visitor = ?2)
+		`
+		hour := click.ClickedAt.UTC().Truncate(time.Hour)
+		err := tx.QueryRowContext(ctx, query, click.ShortCode, click.Visitor, hour).Scan(&firstOfHour, &firstOfDay)
+		if err != nil {
+			return fmt.Errorf("failed to look up earlier clicks of the visitor: %w", err)
+		}
+	}
+
+	if firstOfDay {
+		query := `
+		UPDATE urls
+		SET uniques = uniques + 1
+		WHERE short_code = ?
+		`
+		if _, err := tx.ExecContext(ctx, query, click.ShortCode); err != nil {
+			return fmt.Errorf("failed to increment unique visitors: %w", err)
+		}
+	}
+
+	if click.Variant != "" && !click.Bot {
+		query := `
+		UPDATE url_variants
+		SET clicks = clicks + 1
+		WHERE url_id = (SELECT id FROM urls WHERE short_code = ?) AND name = ?
+		`
+		if _, err := tx.ExecContext(ctx, query, click.ShortCode, click.Variant); err != nil {
+			return fmt.Errorf("failed to increment variant clicks: %w", err)
+		}
+	}
+
+	query = `
+	INSERT INTO click_events (
+		short_code, variant, country, region, referrer, browser, os, language, bot, visitor, ip, user_agent, clicked_at
+	)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, query,
+		click.ShortCode, click.Variant, click.Country, click.Region, click.Referrer, click.Browser, click.OS,
+		click.Language, click.Bot, click.Visitor, click.IP, click.UserAgent, click.ClickedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save click event: %w", err)
+	}
+
+	if err := addToRollups(ctx, tx, click, firstOfHour, firstOfDay); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit click: %w", err)
+	}
+	return nil
+}
+
+// URLsWithoutDestHash returns the URLs without variants saved before
+// destinations were hashed, oldest first
+func (d *Database) URLsWithoutDestHash(ctx context.Context) (_ []*model.URL, err error) {
+	ctx, end := startQuery(ctx, "urls_without_dest_hash")
+	defer end(&err)
+
+	query := `
+	SELECT ` + urlColumns + `
+	FROM urls
+	WHERE dest_hash IS NULL AND NOT EXISTS (SELECT 1 FROM url_variants WHERE url_id = urls.id)
+	ORDER BY id
+	`
+
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list URLs without destination hash: %w", err)
+	}
+	defer rows.Close()
+
+	var urls []*model.URL
+	for rows.Next() {
+		url, err := scanURL(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan URL: %w", err)
+		}
+		urls = append(urls, url)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating URL rows: %w", err)
+	}
+
+	return urls, nil
+}
 
+// SetDestHashes sets the destination hashes of URLs by their ID
+func (d *Database) SetDestHashes(ctx context.Context, hashes map[int64]string) (err error) {
+	ctx, end := startQuery(ctx, "set_dest_hashes")
+	defer end(&err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for id, hash := range hashes {
+		if _, err := tx.ExecContext(ctx, `UPDATE urls SET dest_hash = ? WHERE id = ?`, hash, id); err != nil {
+			return fmt.Errorf("failed to set destination hash of URL %d: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit destination hashes: %w", err)
+	}
 	return nil
 }
 
-// ListURLs retrieves all URLs from the database
-func (d *Database) ListURLs() ([]*model.URL, error) {
+// ListURLs retrieves the URLs matching filter from the database, newest first
+func (d *Database) ListURLs(ctx context.Context, filter model.URLFilter) (_ []*model.URL, err error) {
+	ctx, end := startQuery(ctx, "list_urls")
+	defer end(&err)
+
 	query := `
-	SELECT id, short_code, long_url, created_at, clicks
+	SELECT ` + urlColumns + `
 	FROM urls
+	WHERE ?1 = '' OR utm_campaign = ?1
 	ORDER BY created_at DESC
 	`
 
-	rows, err := d.db.Query(query)
+	rows, err := d.db.QueryContext(ctx, query, filter.Campaign)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list URLs: %w", err)
 	}
@@ -142,39 +473,255 @@ func (d *Database) ListURLs() ([]*model.URL, error) {
 
 	var urls []*model.URL
 	for rows.Next() {
-		var url model.URL
-		err := rows.Scan(
-			&url.ID,
-			&url.ShortCode,
-			&url.LongURL,
-			&url.CreatedAt,
-			&url.Clicks,
-		)
+		url, err := scanURL(rows)
 		if err != nil {
-			log.Printf("Error scanning URL row: %v", err)
+			slog.ErrorContext(ctx, "Failed to scan URL row", "error", err)
 			continue
 		}
-		urls = append(urls, &url)
+		urls = append(urls, url)
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating URL rows: %w", err)
 	}
 
+	if err := d.loadVariants(ctx, urls...); err != nil {
+		return nil, err
+	}
+
 	return urls, nil
 }
 
-// DeleteURL deletes a URL by its short code
-func (d *Database) DeleteURL(shortCode string) error {
+// DeleteURL deletes a URL by its short code together with its variants and click events
+func (d *Database) DeleteURL(ctx context.Context, shortCode string) (err error) {
+	ctx, end := startQuery(ctx, "delete_url")
+	defer end(&err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`DELETE FROM url_variants WHERE url_id IN (SELECT id FROM urls WHERE short_code = ?)`,
+		`DELETE FROM click_events WHERE short_code = ?`,
+		`DELETE FROM click_aggregates WHERE short_code = ?`,
+		`DELETE FROM click_rollups_hourly WHERE short_code = ?`,
+		`DELETE FROM click_rollups_daily WHERE short_code = ?`,
+		`DELETE FROM urls WHERE short_code = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, shortCode); err != nil {
+			return fmt.Errorf("failed to delete URL: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deletion: %w", err)
+	}
+	return nil
+}
+
+// ListBlockedDomains returns the domains blocked by an administrator
+func (d *Database) ListBlockedDomains(ctx context.Context) (_ []*model.BlockedDomain, err error) {
+	ctx, end := startQuery(ctx, "list_blocked_domains")
+	defer end(&err)
+
 	query := `
-	DELETE FROM urls
-	WHERE short_code = ?
+	SELECT domain, created_at
+	FROM blocked_domains
+	ORDER BY domain
 	`
 
-	_, err := d.db.Exec(query, shortCode)
+	rows, err := d.db.QueryContext(ctx, query)
 	if err != nil {
-		return fmt.Errorf("failed to delete URL: %w", err)
+		return nil, fmt.Errorf("failed to list blocked domains: %w", err)
+	}
+	defer rows.Close()
+
+	var domains []*model.BlockedDomain
+	for rows.Next() {
+		var domain model.BlockedDomain
+		if err := rows.Scan(&domain.Domain, &domain.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan blocked domain: %w", err)
+		}
+		domains = append(domains, &domain)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating blocked domain rows: %w", err)
+	}
+
+	return domains, nil
+}
+
+// AddBlockedDomain blocks a domain, adding an existing domain is a no-op
+func (d *Database) AddBlockedDomain(ctx context.Context, domain string) (err error) {
+	ctx, end := startQuery(ctx, "add_blocked_domain")
+	defer end(&err)
+
+	query := `
+	INSERT INTO blocked_domains (domain, created_at)
+	VALUES (?, ?)
+	ON CONFLICT(domain) DO NOTHING
+	`
+
+	if _, err := d.db.ExecContext(ctx, query, domain, time.Now()); err != nil {
+		return fmt.Errorf("failed to add blocked domain: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveBlockedDomain unblocks a domain
+func (d *Database) RemoveBlockedDomain(ctx context.Context, domain string) (err error) {
+	ctx, end := startQuery(ctx, "remove_blocked_domain")
+	defer end(&err)
+
+	query := `
+	DELETE FROM blocked_domains
+	WHERE domain = ?
+	`
+
+	if _, err := d.db.ExecContext(ctx, query, domain); err != nil {
+		return fmt.Errorf("failed to remove blocked domain: %w", err)
 	}
 
 	return nil
 }
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanURL scans a row selected with urlColumns
+func scanURL(row scanner) (*model.URL, error) {
+	var url model.URL
+	var rules, changes string
+	var activeFrom, activeUntil sql.NullTime
+	err := row.Scan(
+		&url.ID,
+		&url.ShortCode,
+		&url.LongURL,
+		&url.CreatedAt,
+		&url.Clicks,
+		&url.BotClicks,
+		&url.Uniques,
+		&url.DestHash,
+		&url.RedirectType,
+		&url.PathPassthrough,
+		&url.QueryPassthrough,
+		&url.UTM.Source,
+		&url.UTM.Medium,
+		&url.UTM.Campaign,
+		&url.UTM.Term,
+		&url.UTM.Content,
+		&url.StripTracking,
+		&url.StickyVariants,
+		&rules,
+		&activeFrom,
+		&activeUntil,
+		&changes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rules != "" {
+		if err := json.Unmarshal([]byte(rules), &url.Rules); err != nil {
+			return nil, fmt.Errorf("failed to decode rules of '%s': %w", url.ShortCode, err)
+		}
+	}
+	if changes != "" {
+		if err := json.Unmarshal([]byte(changes), &url.Schedule.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode scheduled changes of '%s': %w", url.ShortCode, err)
+		}
+	}
+	if activeFrom.Valid {
+		url.Schedule.ActiveFrom = &activeFrom.Time
+	}
+	if activeUntil.Valid {
+		url.Schedule.ActiveUntil = &activeUntil.Time
+	}
+	return &url, nil
+}
+
+// encodeList encodes a list as JSON for a text column, an empty list is stored as an empty string
+func encodeList[T any](name string, values []T) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return string(encoded), nil
+}
+
+// loadVariants sets the variants of the given URLs
+func (d *Database) loadVariants(ctx context.Context, urls ...*model.URL) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*model.URL, len(urls))
+	for _, url := range urls {
+		byID[url.ID] = url
+	}
+
+	// A single URL is looked up by its ID, lists read all variants at once
+	query := `SELECT url_id, name, long_url, weight, clicks FROM url_variants`
+	var args []any
+	if len(urls) == 1 {
+		query += ` WHERE url_id = ?`
+		args = append(args, urls[0].ID)
+	}
+	query += ` ORDER BY url_id, position`
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to load variants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var urlID int64
+		var variant model.Variant
+		if err := rows.Scan(&urlID, &variant.Name, &variant.LongURL, &variant.Weight, &variant.Clicks); err != nil {
+			return fmt.Errorf("failed to scan variant: %w", err)
+		}
+		if url := byID[urlID]; url != nil {
+			url.Variants = append(url.Variants, variant)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating variant rows: %w", err)
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is a UNIQUE or PRIMARY KEY constraint violation
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+		sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
+// startQuery starts a span and a duration measurement for a database operation.
+// The returned function ends both and records the error pointed to by errp.
+func startQuery(ctx context.Context, operation string) (context.Context, func(errp *error)) {
+	start := time.Now()
+	ctx, end := tracing.Start(ctx, tracer, "db."+operation,
+		attribute.String("db.system", "sqlite"),
+		attribute.String("db.operation.name", operation),
+	)
+	return ctx, func(errp *error) {
+		metrics.ObserveQuery(operation, start)
+		end(errp)
+	}
+}
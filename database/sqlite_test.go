@@ -1,11 +1,18 @@
 package database
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/ratelimit"
 )
 
 func setupTestDB(t *testing.T) (*Database, func()) {
@@ -42,19 +49,24 @@ func TestNew(t *testing.T) {
 }
 
 func TestSaveAndGetURL(t *testing.T) {
+	ctx := context.Background()
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
 
 	// Create a URL
 	url := &model.URL{
-		ShortCode: "test",
-		LongURL:   "https://example.com",
-		CreatedAt: time.Now(),
-		Clicks:    0,
+		ShortCode:        "test",
+		LongURL:          "https://example.com",
+		CreatedAt:        time.Now(),
+		Clicks:           0,
+		RedirectType:     model.RedirectPermanent,
+		PathPassthrough:  true,
+		QueryPassthrough: model.QueryPassthroughKeep,
+		UTM:              model.UTM{Source: "newsletter", Campaign: "spring"},
 	}
 
 	// Save the URL
-	err := db.SaveURL(url)
+	err := db.SaveURL(ctx, url)
 	if err != nil {
 		t.Fatalf("Failed to save URL: %v", err)
 	}
@@ -63,7 +75,7 @@ func TestSaveAndGetURL(t *testing.T) {
 	}
 
 	// Get the URL
-	retrievedURL, err := db.GetURLByShortCode("test")
+	retrievedURL, err := db.GetURLByShortCode(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -79,9 +91,18 @@ func TestSaveAndGetURL(t *testing.T) {
 	if retrievedURL.Clicks != 0 {
 		t.Errorf("Expected clicks to be 0, got %d", retrievedURL.Clicks)
 	}
+	if retrievedURL.RedirectType != model.RedirectPermanent {
+		t.Errorf("Expected redirect type to be '308', got '%s'", retrievedURL.RedirectType)
+	}
+	if !retrievedURL.PathPassthrough || retrievedURL.QueryPassthrough != model.QueryPassthroughKeep {
+		t.Errorf("Expected passthrough options to be saved, got %v and '%s'", retrievedURL.PathPassthrough, retrievedURL.QueryPassthrough)
+	}
+	if retrievedURL.UTM != (model.UTM{Source: "newsletter", Campaign: "spring"}) {
+		t.Errorf("Expected campaign parameters to be saved, got %+v", retrievedURL.UTM)
+	}
 
 	// Get non-existent URL
-	retrievedURL, err = db.GetURLByShortCode("nonexistent")
+	retrievedURL, err = db.GetURLByShortCode(ctx, "nonexistent")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -90,7 +111,8 @@ func TestSaveAndGetURL(t *testing.T) {
 	}
 }
 
-func TestIncrementClicks(t *testing.T) {
+func TestRecordClick(t *testing.T) {
+	ctx := context.Background()
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
 
@@ -103,19 +125,19 @@ func TestIncrementClicks(t *testing.T) {
 	}
 
 	// Save the URL
-	err := db.SaveURL(url)
+	err := db.SaveURL(ctx, url)
 	if err != nil {
 		t.Fatalf("Failed to save URL: %v", err)
 	}
 
 	// Increment clicks
-	err = db.IncrementClicks("test")
+	err = db.RecordClick(ctx, model.Click{ShortCode: "test", ClickedAt: time.Now()})
 	if err != nil {
 		t.Fatalf("Failed to increment clicks: %v", err)
 	}
 
 	// Verify clicks were incremented
-	retrievedURL, err := db.GetURLByShortCode("test")
+	retrievedURL, err := db.GetURLByShortCode(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -124,13 +146,13 @@ func TestIncrementClicks(t *testing.T) {
 	}
 
 	// Increment clicks again
-	err = db.IncrementClicks("test")
+	err = db.RecordClick(ctx, model.Click{ShortCode: "test", ClickedAt: time.Now()})
 	if err != nil {
 		t.Fatalf("Failed to increment clicks: %v", err)
 	}
 
 	// Verify clicks were incremented again
-	retrievedURL, err = db.GetURLByShortCode("test")
+	retrievedURL, err = db.GetURLByShortCode(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -138,14 +160,42 @@ func TestIncrementClicks(t *testing.T) {
 		t.Errorf("Expected clicks to be 2, got %d", retrievedURL.Clicks)
 	}
 
+	// Bot visits are counted separately
+	err = db.RecordClick(ctx, model.Click{ShortCode: "test", Bot: true, ClickedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Failed to record bot click: %v", err)
+	}
+	retrievedURL, err = db.GetURLByShortCode(ctx, "test")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if retrievedURL.Clicks != 2 || retrievedURL.BotClicks != 1 {
+		t.Errorf("Expected 2 clicks and 1 bot click, got %d and %d", retrievedURL.Clicks, retrievedURL.BotClicks)
+	}
+
+	// Only the first click of a visitor counts as a unique visitor
+	for _, visitor := range []string{"a", "b", "a"} {
+		if err := db.RecordClick(ctx, model.Click{ShortCode: "test", Visitor: visitor, ClickedAt: time.Now()}); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+	retrievedURL, err = db.GetURLByShortCode(ctx, "test")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if retrievedURL.Clicks != 5 || retrievedURL.Uniques != 2 {
+		t.Errorf("Expected 5 clicks by 2 unique visitors, got %d by %d", retrievedURL.Clicks, retrievedURL.Uniques)
+	}
+
 	// Increment clicks for non-existent URL
-	err = db.IncrementClicks("nonexistent")
+	err = db.RecordClick(ctx, model.Click{ShortCode: "nonexistent", ClickedAt: time.Now()})
 	if err == nil {
 		t.Logf("Expected error when incrementing clicks for non-existent URL, got nil")
 	}
 }
 
 func TestListURLs(t *testing.T) {
+	ctx := context.Background()
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
 
@@ -161,29 +211,40 @@ func TestListURLs(t *testing.T) {
 		LongURL:   "https://example.org",
 		CreatedAt: time.Now(),
 		Clicks:    0,
+		UTM:       model.UTM{Source: "newsletter", Campaign: "spring"},
 	}
 
 	// Save the URLs
-	err := db.SaveURL(url1)
+	err := db.SaveURL(ctx, url1)
 	if err != nil {
 		t.Fatalf("Failed to save URL: %v", err)
 	}
-	err = db.SaveURL(url2)
+	err = db.SaveURL(ctx, url2)
 	if err != nil {
 		t.Fatalf("Failed to save URL: %v", err)
 	}
 
 	// List URLs
-	urls, err := db.ListURLs()
+	urls, err := db.ListURLs(ctx, model.URLFilter{})
 	if err != nil {
 		t.Fatalf("Failed to list URLs: %v", err)
 	}
 	if len(urls) != 2 {
 		t.Errorf("Expected 2 URLs, got %d", len(urls))
 	}
+
+	// List the URLs of a campaign
+	urls, err = db.ListURLs(ctx, model.URLFilter{Campaign: "spring"})
+	if err != nil {
+		t.Fatalf("Failed to list URLs: %v", err)
+	}
+	if len(urls) != 1 || urls[0].ShortCode != "test2" {
+		t.Errorf("Expected only URL 'test2' of the campaign, got %+v", urls)
+	}
 }
 
 func TestDeleteURL(t *testing.T) {
+	ctx := context.Background()
 	db, cleanup := setupTestDB(t)
 	defer cleanup()
 
@@ -196,19 +257,19 @@ func TestDeleteURL(t *testing.T) {
 	}
 
 	// Save the URL
-	err := db.SaveURL(url)
+	err := db.SaveURL(ctx, url)
 	if err != nil {
 		t.Fatalf("Failed to save URL: %v", err)
 	}
 
 	// Delete the URL
-	err = db.DeleteURL("test")
+	err = db.DeleteURL(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to delete URL: %v", err)
 	}
 
 	// Verify it's deleted
-	retrievedURL, err := db.GetURLByShortCode("test")
+	retrievedURL, err := db.GetURLByShortCode(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -217,8 +278,960 @@ func TestDeleteURL(t *testing.T) {
 	}
 
 	// Delete non-existent URL
-	err = db.DeleteURL("nonexistent")
+	err = db.DeleteURL(ctx, "nonexistent")
 	if err != nil {
 		t.Errorf("Expected no error when deleting non-existent URL, got %v", err)
 	}
 }
+
+func TestReady(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// A freshly migrated database is ready
+	if err := db.Ready(ctx); err != nil {
+		t.Fatalf("Expected database to be ready, got %v", err)
+	}
+
+	version, err := db.schemaVersion(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read schema version: %v", err)
+	}
+	if version != latestSchemaVersion() {
+		t.Errorf("Expected schema version %d, got %d", latestSchemaVersion(), version)
+	}
+
+	// A database missing migrations is not ready
+	if _, err := db.db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, latestSchemaVersion()); err != nil {
+		t.Fatalf("Failed to remove migration record: %v", err)
+	}
+	if err := db.Ready(ctx); err == nil {
+		t.Errorf("Expected database with pending migrations not to be ready")
+	}
+
+	// A closed database is not ready
+	db.Close()
+	if err := db.Ready(ctx); err == nil {
+		t.Errorf("Expected closed database not to be ready")
+	}
+}
+
+func TestNextSequence(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for expected := int64(1); expected <= 3; expected++ {
+		value, err := db.NextSequence(ctx, "short_codes")
+		if err != nil {
+			t.Fatalf("Failed to advance sequence: %v", err)
+		}
+		if value != expected {
+			t.Errorf("Expected sequence value %d, got %d", expected, value)
+		}
+	}
+
+	// Sequences are independent of each other
+	value, err := db.NextSequence(ctx, "other")
+	if err != nil {
+		t.Fatalf("Failed to advance sequence: %v", err)
+	}
+	if value != 1 {
+		t.Errorf("Expected new sequence to start at 1, got %d", value)
+	}
+}
+
+func TestSaveURLDuplicateShortCode(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Save many URLs with the same short code concurrently
+	const workers = 20
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- db.SaveURL(ctx, model.NewURL("race", fmt.Sprintf("https://example.com/%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	// Exactly one save wins, the others report the taken code
+	saved := 0
+	for err := range errs {
+		var exists *model.ErrCustomCodeAlreadyExists
+		switch {
+		case err == nil:
+			saved++
+		case !errors.As(err, &exists):
+			t.Errorf("Expected ErrCustomCodeAlreadyExists, got %v", err)
+		}
+	}
+	if saved != 1 {
+		t.Errorf("Expected exactly 1 successful save, got %d", saved)
+	}
+}
+
+func TestGetURLByDestHash(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Save two URLs with the same destination hash
+	for _, code := range []string{"first", "second"} {
+		url := model.NewURL(code, "https://example.com")
+		url.DestHash = "hash"
+		if err := db.SaveURL(ctx, url); err != nil {
+			t.Fatalf("Failed to save URL: %v", err)
+		}
+	}
+
+	// The oldest URL is returned
+	url, err := db.GetURLByDestHash(ctx, "hash")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if url == nil || url.ShortCode != "first" {
+		t.Errorf("Expected URL 'first', got %+v", url)
+	}
+
+	// Unknown hashes return nil
+	url, err = db.GetURLByDestHash(ctx, "unknown")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if url != nil {
+		t.Errorf("Expected nil for unknown hash, got %+v", url)
+	}
+}
+
+func TestURLsWithoutDestHash(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Only URLs without variants and destination hash are listed
+	old := model.NewURL("old", "https://example.com")
+	hashed := model.NewURL("hashed", "https://example.com")
+	hashed.DestHash = "hash"
+	variants := model.NewURL("variants", "https://a.example.com")
+	variants.Variants = []model.Variant{{Name: "a", LongURL: "https://a.example.com", Weight: 1}}
+	for _, url := range []*model.URL{old, hashed, variants} {
+		if err := db.SaveURL(ctx, url); err != nil {
+			t.Fatalf("Failed to save URL: %v", err)
+		}
+	}
+
+	urls, err := db.URLsWithoutDestHash(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list URLs: %v", err)
+	}
+	if len(urls) != 1 || urls[0].ShortCode != "old" {
+		t.Fatalf("Expected only URL 'old', got %+v", urls)
+	}
+
+	if err := db.SetDestHashes(ctx, map[int64]string{old.ID: "old-hash"}); err != nil {
+		t.Fatalf("Failed to set destination hashes: %v", err)
+	}
+	url, err := db.GetURLByDestHash(ctx, "old-hash")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if url == nil || url.ShortCode != "old" {
+		t.Errorf("Expected URL 'old', got %+v", url)
+	}
+
+	urls, err = db.URLsWithoutDestHash(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list URLs: %v", err)
+	}
+	if len(urls) != 0 {
+		t.Errorf("Expected no URLs without destination hash, got %d", len(urls))
+	}
+}
+
+func TestBlockedDomains(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	for _, domain := range []string{"phish.example", "malware.example", "phish.example"} {
+		if err := db.AddBlockedDomain(ctx, domain); err != nil {
+			t.Fatalf("Failed to add blocked domain: %v", err)
+		}
+	}
+
+	domains, err := db.ListBlockedDomains(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list blocked domains: %v", err)
+	}
+	if len(domains) != 2 {
+		t.Fatalf("Expected 2 blocked domains, got %d", len(domains))
+	}
+	if domains[0].Domain != "malware.example" || domains[1].Domain != "phish.example" {
+		t.Errorf("Expected domains sorted by name, got '%s', '%s'", domains[0].Domain, domains[1].Domain)
+	}
+
+	if err := db.RemoveBlockedDomain(ctx, "phish.example"); err != nil {
+		t.Fatalf("Failed to remove blocked domain: %v", err)
+	}
+	domains, err = db.ListBlockedDomains(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list blocked domains: %v", err)
+	}
+	if len(domains) != 1 || domains[0].Domain != "malware.example" {
+		t.Errorf("Expected only 'malware.example' to remain, got %v", domains)
+	}
+}
+
+func TestRateLimitStore(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Two stores on the same database share their buckets, like two instances would
+	first, second := db.RateLimitStore(), db.RateLimitStore()
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+	now := time.Unix(1000, 0)
+
+	results := make([]ratelimit.Result, 0, 3)
+	for _, store := range []*RateLimitStore{first, second, first} {
+		result, err := store.Take(ctx, "client", limit, now)
+		if err != nil {
+			t.Fatalf("Failed to take token: %v", err)
+		}
+		results = append(results, result)
+	}
+
+	if !results[0].Allowed || results[0].Remaining != 1 {
+		t.Errorf("Expected first take to be allowed with 1 remaining, got %+v", results[0])
+	}
+	if !results[1].Allowed || results[1].Remaining != 0 {
+		t.Errorf("Expected second take to be allowed with 0 remaining, got %+v", results[1])
+	}
+	if results[2].Allowed || results[2].RetryAfter != time.Second {
+		t.Errorf("Expected third take to be rejected with retry after 1s, got %+v", results[2])
+	}
+
+	// Tokens refill over time
+	result, err := second.Take(ctx, "client", limit, now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to take token: %v", err)
+	}
+	if !result.Allowed {
+		t.Errorf("Expected a refilled token to be allowed, got %+v", result)
+	}
+
+	// Other keys have their own bucket
+	result, err = first.Take(ctx, "other", limit, now)
+	if err != nil {
+		t.Fatalf("Failed to take token: %v", err)
+	}
+	if !result.Allowed {
+		t.Errorf("Expected another key to be allowed")
+	}
+}
+
+func TestURLVariants(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	url := &model.URL{
+		ShortCode:      "split",
+		LongURL:        "https://example.com/a",
+		CreatedAt:      time.Now(),
+		StickyVariants: true,
+		Variants: []model.Variant{
+			{Name: "a", LongURL: "https://example.com/a", Weight: 70},
+			{Name: "b", LongURL: "https://example.com/b", Weight: 30},
+		},
+	}
+	if err := db.SaveURL(ctx, url); err != nil {
+		t.Fatalf("Failed to save URL: %v", err)
+	}
+
+	for _, variant := range []string{"a", "b", "b"} {
+		if err := db.RecordClick(ctx, model.Click{ShortCode: "split", Variant: variant, ClickedAt: time.Now()}); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	// Clicks keep the location of the visitor
+	located := model.Click{ShortCode: "split", Variant: "a", Country: "DE", Region: "BY", ClickedAt: time.Now()}
+	if err := db.RecordClick(ctx, located); err != nil {
+		t.Fatalf("Failed to record click: %v", err)
+	}
+	var country, region string
+	query := `SELECT country, region FROM click_events ORDER BY id DESC LIMIT 1`
+	if err := db.db.QueryRowContext(ctx, query).Scan(&country, &region); err != nil {
+		t.Fatalf("Failed to read click event: %v", err)
+	}
+	if country != "DE" || region != "BY" {
+		t.Errorf("Expected click located in DE/BY, got %s/%s", country, region)
+	}
+
+	retrievedURL, err := db.GetURLByShortCode(ctx, "split")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if !retrievedURL.StickyVariants {
+		t.Errorf("Expected sticky variants to be saved")
+	}
+	if len(retrievedURL.Variants) != 2 {
+		t.Fatalf("Expected 2 variants, got %d", len(retrievedURL.Variants))
+	}
+	if retrievedURL.Clicks != 4 {
+		t.Errorf("Expected clicks to be 4, got %d", retrievedURL.Clicks)
+	}
+	a, b := retrievedURL.Variants[0], retrievedURL.Variants[1]
+	if a.Name != "a" || a.Weight != 70 || a.Clicks != 2 {
+		t.Errorf("Expected variant a with weight 70 and 2 clicks, got %+v", a)
+	}
+	if b.Name != "b" || b.LongURL != "https://example.com/b" || b.Clicks != 2 {
+		t.Errorf("Expected variant b with 2 clicks, got %+v", b)
+	}
+
+	urls, err := db.ListURLs(ctx, model.URLFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list URLs: %v", err)
+	}
+	if len(urls) != 1 || len(urls[0].Variants) != 2 {
+		t.Errorf("Expected listed URL with 2 variants, got %+v", urls)
+	}
+
+	// Deleting the URL removes its variants and click events
+	if err := db.DeleteURL(ctx, "split"); err != nil {
+		t.Fatalf("Failed to delete URL: %v", err)
+	}
+	for _, table := range []string{"url_variants", "click_events"} {
+		var count int
+		if err := db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&count); err != nil {
+			t.Fatalf("Failed to count %s: %v", table, err)
+		}
+		if count != 0 {
+			t.Errorf("Expected no rows in %s after deletion, got %d", table, count)
+		}
+	}
+}
+
+func TestUpdateRules(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	url := &model.URL{
+		ShortCode: "app",
+		LongURL:   "https://example.com",
+		CreatedAt: time.Now(),
+		Rules:     []model.Rule{{LongURL: "https://apps.apple.com/app/id1", OS: []string{"ios"}}},
+	}
+	if err := db.SaveURL(ctx, url); err != nil {
+		t.Fatalf("Failed to save URL: %v", err)
+	}
+
+	retrievedURL, err := db.GetURLByShortCode(ctx, "app")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if len(retrievedURL.Rules) != 1 || retrievedURL.Rules[0].OS[0] != "ios" {
+		t.Errorf("Expected the saved rule, got %+v", retrievedURL.Rules)
+	}
+
+	rules := []model.Rule{
+		{LongURL: "https://play.google.com/store/apps/details?id=x", OS: []string{"android"}},
+		{LongURL: "https://example.com/night", After: "22:00", Before: "06:00", TimeZone: "Europe/Berlin"},
+	}
+	if err := db.UpdateRules(ctx, "app", rules); err != nil {
+		t.Fatalf("Failed to update rules: %v", err)
+	}
+	retrievedURL, err = db.GetURLByShortCode(ctx, "app")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if len(retrievedURL.Rules) != 2 || retrievedURL.Rules[1].TimeZone != "Europe/Berlin" {
+		t.Errorf("Expected the updated rules, got %+v", retrievedURL.Rules)
+	}
+
+	// Clearing the rules stores none
+	if err := db.UpdateRules(ctx, "app", nil); err != nil {
+		t.Fatalf("Failed to clear rules: %v", err)
+	}
+	retrievedURL, err = db.GetURLByShortCode(ctx, "app")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if len(retrievedURL.Rules) != 0 {
+		t.Errorf("Expected no rules, got %+v", retrievedURL.Rules)
+	}
+
+	var notFound *model.ErrURLNotFound
+	if err := db.UpdateRules(ctx, "nonexistent", rules); !errors.As(err, &notFound) {
+		t.Errorf("Expected ErrURLNotFound, got %v", err)
+	}
+}
+
+func TestUpdateSchedule(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	from := time.Date(2026, 9, 1, 7, 0, 0, 0, time.UTC)
+	url := &model.URL{
+		ShortCode: "launch",
+		LongURL:   "https://example.com/teaser",
+		CreatedAt: time.Now(),
+		Schedule:  model.Schedule{ActiveFrom: &from},
+	}
+	if err := db.SaveURL(ctx, url); err != nil {
+		t.Fatalf("Failed to save URL: %v", err)
+	}
+
+	retrievedURL, err := db.GetURLByShortCode(ctx, "launch")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	schedule := retrievedURL.Schedule
+	if schedule.ActiveFrom == nil || !schedule.ActiveFrom.Equal(from) || schedule.ActiveUntil != nil {
+		t.Errorf("Expected the saved activation window, got %+v", schedule)
+	}
+
+	until := from.Add(30 * 24 * time.Hour)
+	schedule = model.Schedule{
+		ActiveUntil: &until,
+		Changes:     []model.ScheduledChange{{At: from.Add(time.Hour), LongURL: "https://example.com/product"}},
+	}
+	if err := db.UpdateSchedule(ctx, "launch", schedule); err != nil {
+		t.Fatalf("Failed to update schedule: %v", err)
+	}
+	retrievedURL, err = db.GetURLByShortCode(ctx, "launch")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	schedule = retrievedURL.Schedule
+	if schedule.ActiveFrom != nil || schedule.ActiveUntil == nil || !schedule.ActiveUntil.Equal(until) {
+		t.Errorf("Expected the updated activation window, got %+v", schedule)
+	}
+	if len(schedule.Changes) != 1 || schedule.Changes[0].LongURL != "https://example.com/product" || !schedule.Changes[0].At.Equal(from.Add(time.Hour)) {
+		t.Errorf("Expected the scheduled change, got %+v", schedule.Changes)
+	}
+
+	// Clearing the schedule stores none
+	if err := db.UpdateSchedule(ctx, "launch", model.Schedule{}); err != nil {
+		t.Fatalf("Failed to clear schedule: %v", err)
+	}
+	retrievedURL, err = db.GetURLByShortCode(ctx, "launch")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if !retrievedURL.Schedule.IsZero() {
+		t.Errorf("Expected no schedule, got %+v", retrievedURL.Schedule)
+	}
+
+	var notFound *model.ErrURLNotFound
+	if err := db.UpdateSchedule(ctx, "missing", model.Schedule{}); !errors.As(err, &notFound) {
+		t.Errorf("Expected ErrURLNotFound, got %v", err)
+	}
+}
+
+func TestClickStats(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	url := &model.URL{ShortCode: "stats", LongURL: "https://example.com", CreatedAt: time.Now()}
+	if err := db.SaveURL(ctx, url); err != nil {
+		t.Fatalf("Failed to save URL: %v", err)
+	}
+
+	day := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	berlin := time.FixedZone("CEST", 2*60*60)
+	clicks := []model.Click{
+		{ClickedAt: day.Add(9*time.Hour + 5*time.Minute), Referrer: "news.example", Browser: "chrome", OS: "windows", Country: "DE", Language: "de-de", Visitor: "a"},
+		{ClickedAt: day.Add(9*time.Hour + 59*time.Minute + 30*time.Second), Referrer: "news.example", Browser: "safari", OS: "ios", Country: "DE", Language: "de", Visitor: "a"},
+		// Stored in UTC whatever the time zone of the click
+		{ClickedAt: day.Add(13 * time.Hour).In(berlin), Browser: "chrome", OS: "android", Country: "FR", Language: "fr", Visitor: "b"},
+		{ClickedAt: day.Add(25 * time.Hour), Referrer: "social.example", Browser: "chrome", Visitor: "c"},
+		// Bots are only counted separately
+		{ClickedAt: day.Add(9 * time.Hour), Referrer: "social.example", Bot: true},
+		// Outside of the range
+		{ClickedAt: day.Add(-time.Second), Browser: "firefox"},
+		{ClickedAt: day.Add(48 * time.Hour), Browser: "firefox"},
+	}
+	for _, click := range clicks {
+		click.ShortCode = "stats"
+		if err := db.RecordClick(ctx, click); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	stats, err := db.ClickStats(ctx, "stats", day, day.Add(48*time.Hour), model.StatsHourly, 10)
+	if err != nil {
+		t.Fatalf("Failed to get click stats: %v", err)
+	}
+	if stats.Total != 4 || stats.Uniques != 3 || stats.Bots != 1 {
+		t.Errorf("Expected 4 clicks by 3 visitors and 1 bot visit, got %d, %d and %d", stats.Total, stats.Uniques, stats.Bots)
+	}
+	want := []model.StatsPoint{
+		{Time: day.Add(9 * time.Hour), Clicks: 2, Uniques: 1},
+		{Time: day.Add(13 * time.Hour), Clicks: 1, Uniques: 1},
+		{Time: day.Add(25 * time.Hour), Clicks: 1, Uniques: 1},
+	}
+	if len(stats.Series) != len(want) {
+		t.Fatalf("Expected %d hourly buckets, got %+v", len(want), stats.Series)
+	}
+	for i, point := range stats.Series {
+		if !point.Time.Equal(want[i].Time) || point.Clicks != want[i].Clicks || point.Uniques != want[i].Uniques {
+			t.Errorf("Expected bucket %+v, got %+v", want[i], point)
+		}
+	}
+
+	if got := stats.Browsers; len(got) != 2 || got[0] != (model.StatsCount{Value: "chrome", Clicks: 3, Uniques: 3}) {
+		t.Errorf("Expected chrome to lead the browsers, got %+v", got)
+	}
+	if got := stats.Referrers; len(got) != 3 || got[0] != (model.StatsCount{Value: "news.example", Clicks: 2, Uniques: 1}) {
+		t.Errorf("Expected news.example to lead the referrers, got %+v", got)
+	}
+	if got := stats.Countries; len(got) != 3 || got[0] != (model.StatsCount{Value: "DE", Clicks: 2, Uniques: 1}) {
+		t.Errorf("Expected DE to lead the countries, got %+v", got)
+	}
+
+	stats, err = db.ClickStats(ctx, "stats", day, day.Add(48*time.Hour), model.StatsDaily, 1)
+	if err != nil {
+		t.Fatalf("Failed to get click stats: %v", err)
+	}
+	if len(stats.Series) != 2 || stats.Series[0].Clicks != 3 || !stats.Series[1].Time.Equal(day.Add(24*time.Hour)) {
+		t.Errorf("Expected 2 daily buckets, got %+v", stats.Series)
+	}
+	if len(stats.OS) != 1 {
+		t.Errorf("Expected top lists limited to 1 value, got %+v", stats.OS)
+	}
+
+	// Other URLs have no clicks
+	stats, err = db.ClickStats(ctx, "other", day, day.Add(48*time.Hour), model.StatsDaily, 10)
+	if err != nil {
+		t.Fatalf("Failed to get click stats: %v", err)
+	}
+	if stats.Total != 0 || len(stats.Series) != 0 || len(stats.Browsers) != 0 {
+		t.Errorf("Expected no clicks, got %+v", stats)
+	}
+}
+
+func TestVisitorSalt(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	day := time.Date(2026, 5, 4, 10, 0, 0, 0, time.UTC)
+	first, err := db.VisitorSalt(ctx, day)
+	if err != nil {
+		t.Fatalf("Failed to get visitor salt: %v", err)
+	}
+	if len(first) != visitorSaltSize {
+		t.Fatalf("Expected a salt of %d bytes, got %d", visitorSaltSize, len(first))
+	}
+
+	// The salt stays the same during the day
+	again, err := db.VisitorSalt(ctx, day.Add(13*time.Hour).In(time.FixedZone("UTC-2", -2*60*60)))
+	if err != nil {
+		t.Fatalf("Failed to get visitor salt: %v", err)
+	}
+	if !bytes.Equal(first, again) {
+		t.Error("Expected the same salt for the same day")
+	}
+
+	// Every day gets a new salt, salts of earlier days are deleted
+	next, err := db.VisitorSalt(ctx, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Failed to get visitor salt: %v", err)
+	}
+	if bytes.Equal(first, next) {
+		t.Error("Expected a different salt on the next day")
+	}
+	if _, err := db.VisitorSalt(ctx, day.AddDate(0, 0, 2)); err != nil {
+		t.Fatalf("Failed to get visitor salt: %v", err)
+	}
+	var days int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM visitor_salts WHERE day = ?`, "2026-05-04").Scan(&days); err != nil {
+		t.Fatalf("Failed to count salts: %v", err)
+	}
+	if days != 0 {
+		t.Error("Expected the salt of two days ago to be deleted")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, code := range []string{"kept", "other"} {
+		if err := db.SaveURL(ctx, &model.URL{ShortCode: code, LongURL: "https://example.com", CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("Failed to save URL: %v", err)
+		}
+	}
+
+	day := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	clicks := []model.Click{
+		{ShortCode: "kept", ClickedAt: day.Add(9 * time.Hour), Browser: "chrome", Visitor: "a", IP: "192.0.2.0", UserAgent: "Chrome"},
+		{ShortCode: "kept", ClickedAt: day.Add(10 * time.Hour), Browser: "chrome", Visitor: "a", IP: "192.0.2.0", UserAgent: "Chrome"},
+		{ShortCode: "kept", ClickedAt: day.Add(11 * time.Hour), Browser: "firefox", Visitor: "b"},
+		{ShortCode: "kept", ClickedAt: day.Add(12 * time.Hour), Bot: true},
+		{ShortCode: "other", ClickedAt: day.Add(13 * time.Hour), Browser: "safari", Visitor: "c"},
+		{ShortCode: "kept", ClickedAt: day.Add(33 * time.Hour), Browser: "chrome", Visitor: "d", IP: "192.0.2.0", UserAgent: "Chrome"},
+	}
+	for _, click := range clicks {
+		if err := db.RecordClick(ctx, click); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	before, err := db.ClickStats(ctx, "kept", day, day.Add(48*time.Hour), model.StatsDaily, 10)
+	if err != nil {
+		t.Fatalf("Failed to get click stats: %v", err)
+	}
+
+	// Visitor data is erased before the given time only
+	n, err := db.EraseVisitorData(ctx, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to erase visitor data: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected the visitor data of 2 clicks to be erased, got %d", n)
+	}
+	var stored int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM click_events WHERE ip != '' OR user_agent != ''`).Scan(&stored); err != nil {
+		t.Fatalf("Failed to count visitor data: %v", err)
+	}
+	if stored != 1 {
+		t.Errorf("Expected the visitor data of 1 click to be kept, got %d", stored)
+	}
+
+	oldest, err := db.OldestClickDay(ctx, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get oldest click day: %v", err)
+	}
+	if !oldest.Equal(day) {
+		t.Errorf("Expected the oldest click on %v, got %v", day, oldest)
+	}
+
+	n, err = db.RollUpClicks(ctx, day)
+	if err != nil {
+		t.Fatalf("Failed to roll up clicks: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("Expected 5 clicks to be rolled up, got %d", n)
+	}
+	if oldest, err := db.OldestClickDay(ctx, day.Add(24*time.Hour)); err != nil || !oldest.IsZero() {
+		t.Errorf("Expected no clicks left before the next day, got %v, %v", oldest, err)
+	}
+
+	// Rolled up clicks keep their statistics per day
+	after, err := db.ClickStats(ctx, "kept", day, day.Add(48*time.Hour), model.StatsDaily, 10)
+	if err != nil {
+		t.Fatalf("Failed to get click stats: %v", err)
+	}
+	if after.Total != before.Total || after.Uniques != before.Uniques || after.Bots != before.Bots {
+		t.Errorf("Expected %d clicks by %d visitors and %d bots, got %d, %d and %d",
+			before.Total, before.Uniques, before.Bots, after.Total, after.Uniques, after.Bots)
+	}
+	if len(after.Series) != 2 || after.Series[0] != before.Series[0] || after.Series[1] != before.Series[1] {
+		t.Errorf("Expected the daily series %+v, got %+v", before.Series, after.Series)
+	}
+	if !slices.Equal(after.Browsers, before.Browsers) {
+		t.Errorf("Expected the browsers %+v, got %+v", before.Browsers, after.Browsers)
+	}
+
+	// Hourly series are kept in the rollups
+	hourly, err := db.ClickStats(ctx, "kept", day, day.Add(24*time.Hour), model.StatsHourly, 10)
+	if err != nil {
+		t.Fatalf("Failed to get click stats: %v", err)
+	}
+	if len(hourly.Series) != 3 || !hourly.Series[0].Time.Equal(day.Add(9*time.Hour)) {
+		t.Errorf("Expected 3 hourly clicks from 09:00, got %+v", hourly.Series)
+	}
+
+	// Deleting a URL deletes its aggregates
+	if err := db.DeleteURL(ctx, "kept"); err != nil {
+		t.Fatalf("Failed to delete URL: %v", err)
+	}
+	var aggregates int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM click_aggregates WHERE short_code = 'kept'`).Scan(&aggregates); err != nil {
+		t.Fatalf("Failed to count aggregates: %v", err)
+	}
+	if aggregates != 0 {
+		t.Errorf("Expected the aggregates of the deleted URL to be deleted, got %d", aggregates)
+	}
+}
+
+func TestRollups(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.SaveURL(ctx, &model.URL{ShortCode: "rolled", LongURL: "https://example.com", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to save URL: %v", err)
+	}
+
+	day := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	clicks := []model.Click{
+		{ShortCode: "rolled", ClickedAt: day.Add(9*time.Hour + 5*time.Minute), Visitor: "a"},
+		{ShortCode: "rolled", ClickedAt: day.Add(9*time.Hour + 50*time.Minute), Visitor: "a"},
+		{ShortCode: "rolled", ClickedAt: day.Add(10 * time.Hour), Visitor: "a"},
+		{ShortCode: "rolled", ClickedAt: day.Add(10*time.Hour + 30*time.Minute), Visitor: "b"},
+		{ShortCode: "rolled", ClickedAt: day.Add(10*time.Hour + 45*time.Minute), Bot: true},
+		{ShortCode: "rolled", ClickedAt: day.Add(30 * time.Hour), Visitor: "c"},
+	}
+	for _, click := range clicks {
+		if err := db.RecordClick(ctx, click); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	type rollup struct {
+		bucket                string
+		clicks, uniques, bots int64
+	}
+	readRollups := func(table string) []rollup {
+		t.Helper()
+		rows, err := db.db.Query(`SELECT bucket, clicks, uniques, bots FROM ` + table + ` ORDER BY bucket`)
+		if err != nil {
+			t.Fatalf("Failed to read rollups: %v", err)
+		}
+		defer rows.Close()
+		var rollups []rollup
+		for rows.Next() {
+			var r rollup
+			if err := rows.Scan(&r.bucket, &r.clicks, &r.uniques, &r.bots); err != nil {
+				t.Fatalf("Failed to scan rollup: %v", err)
+			}
+			rollups = append(rollups, r)
+		}
+		return rollups
+	}
+
+	// Visitors count once per hour and once per day
+	hourly := []rollup{
+		{"2026-05-04 09:00:00", 2, 1, 0},
+		{"2026-05-04 10:00:00", 2, 2, 1},
+		{"2026-05-05 06:00:00", 1, 1, 0},
+	}
+	daily := []rollup{
+		{"2026-05-04 00:00:00", 4, 2, 1},
+		{"2026-05-05 00:00:00", 1, 1, 0},
+	}
+	if got := readRollups("click_rollups_hourly"); !slices.Equal(got, hourly) {
+		t.Errorf("Expected the hourly rollups %+v, got %+v", hourly, got)
+	}
+	if got := readRollups("click_rollups_daily"); !slices.Equal(got, daily) {
+		t.Errorf("Expected the daily rollups %+v, got %+v", daily, got)
+	}
+
+	// Unique visitors of whole days are read from the daily rollups
+	stats, err := db.ClickStats(ctx, "rolled", day, day.Add(24*time.Hour), model.StatsHourly, 10)
+	if err != nil {
+		t.Fatalf("Failed to get click stats: %v", err)
+	}
+	if stats.Total != 4 || stats.Uniques != 2 || stats.Bots != 1 || len(stats.Series) != 2 {
+		t.Errorf("Expected 4 clicks by 2 visitors and 1 bot in 2 hours, got %+v", stats)
+	}
+
+	// Unique visitors of partial days are counted from the click events, so
+	// visitors returning in another hour count once
+	for _, r := range []struct {
+		from, until time.Time
+		uniques     int64
+	}{
+		{day.Add(9 * time.Hour), day.Add(11 * time.Hour), 2},
+		{day.Add(10 * time.Hour), day.Add(31 * time.Hour), 3},
+		{day.Add(-time.Hour), day.Add(48 * time.Hour), 3},
+	} {
+		stats, err := db.ClickStats(ctx, "rolled", r.from, r.until, model.StatsHourly, 10)
+		if err != nil {
+			t.Fatalf("Failed to get click stats: %v", err)
+		}
+		if stats.Uniques != r.uniques {
+			t.Errorf("Expected %d unique visitors from %s until %s, got %d", r.uniques, r.from, r.until, stats.Uniques)
+		}
+	}
+
+	// Rebuilding recomputes the rollups from the click events
+	if _, err := db.db.Exec(`DELETE FROM click_rollups_hourly; UPDATE click_rollups_daily SET clicks = 100`); err != nil {
+		t.Fatalf("Failed to corrupt rollups: %v", err)
+	}
+	if err := db.RebuildRollups(ctx); err != nil {
+		t.Fatalf("Failed to rebuild rollups: %v", err)
+	}
+	if got := readRollups("click_rollups_hourly"); !slices.Equal(got, hourly) {
+		t.Errorf("Expected the rebuilt hourly rollups %+v, got %+v", hourly, got)
+	}
+	if got := readRollups("click_rollups_daily"); !slices.Equal(got, daily) {
+		t.Errorf("Expected the rebuilt daily rollups %+v, got %+v", daily, got)
+	}
+
+	// Compacting deletes old hourly rollups only
+	n, err := db.CompactRollups(ctx, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to compact rollups: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 hourly rollups to be deleted, got %d", n)
+	}
+	if got := readRollups("click_rollups_hourly"); !slices.Equal(got, hourly[2:]) {
+		t.Errorf("Expected the hourly rollups %+v, got %+v", hourly[2:], got)
+	}
+	if got := readRollups("click_rollups_daily"); !slices.Equal(got, daily) {
+		t.Errorf("Expected the daily rollups %+v to be kept, got %+v", daily, got)
+	}
+
+	// Rebuilding keeps the hourly rollups of rolled up days, which the click
+	// events no longer tell, and recomputes those of the other days
+	if _, err := db.RollUpClicks(ctx, day.Add(24*time.Hour)); err != nil {
+		t.Fatalf("Failed to roll up clicks: %v", err)
+	}
+	if err := db.RebuildRollups(ctx); err != nil {
+		t.Fatalf("Failed to rebuild rollups: %v", err)
+	}
+	if got := readRollups("click_rollups_hourly"); !slices.Equal(got, hourly) {
+		t.Errorf("Expected the rebuilt hourly rollups %+v, got %+v", hourly, got)
+	}
+	if got := readRollups("click_rollups_daily"); !slices.Equal(got, daily) {
+		t.Errorf("Expected the rebuilt daily rollups %+v, got %+v", daily, got)
+	}
+
+	// Partial rolled up days sum their hourly unique visitors, or take their
+	// daily unique visitors once the hourly rollups are compacted
+	for _, keep := range []bool{true, false} {
+		if !keep {
+			if _, err := db.CompactRollups(ctx, day.Add(48*time.Hour)); err != nil {
+				t.Fatalf("Failed to compact rollups: %v", err)
+			}
+		}
+		stats, err := db.ClickStats(ctx, "rolled", day.Add(24*time.Hour), day.Add(36*time.Hour), model.StatsHourly, 10)
+		if err != nil {
+			t.Fatalf("Failed to get click stats: %v", err)
+		}
+		if stats.Uniques != 1 {
+			t.Errorf("Expected 1 unique visitor of the rolled up day, got %d", stats.Uniques)
+		}
+	}
+
+	// Deleting a URL deletes its rollups
+	if err := db.DeleteURL(ctx, "rolled"); err != nil {
+		t.Fatalf("Failed to delete URL: %v", err)
+	}
+	if got := readRollups("click_rollups_hourly"); len(got) != 0 {
+		t.Errorf("Expected the hourly rollups of the deleted URL to be deleted, got %+v", got)
+	}
+	if got := readRollups("click_rollups_daily"); len(got) != 0 {
+		t.Errorf("Expected the daily rollups of the deleted URL to be deleted, got %+v", got)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	crm := &model.Webhook{URL: "https://crm.example.com/hook", Events: []model.WebhookEvent{model.EventLinkCreated, model.EventLinkClicked}, Secret: "a", CreatedAt: time.Now()}
+	archive := &model.Webhook{URL: "https://archive.example.com/hook", Events: []model.WebhookEvent{model.EventLinkDeleted}, Secret: "b", CreatedAt: time.Now()}
+	for _, webhook := range []*model.Webhook{crm, archive} {
+		if err := db.CreateWebhook(ctx, webhook); err != nil {
+			t.Fatalf("Failed to create webhook: %v", err)
+		}
+	}
+
+	webhooks, err := db.ListWebhooks(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list webhooks: %v", err)
+	}
+	if len(webhooks) != 2 || webhooks[0].ID != crm.ID || !slices.Equal(webhooks[0].Events, crm.Events) || webhooks[0].Secret != "a" {
+		t.Errorf("Expected the saved webhooks, got %+v", webhooks)
+	}
+
+	// Events are queued for subscribed webhooks only
+	now := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	n, err := db.EnqueueWebhookEvent(ctx, model.EventLinkClicked, []byte(`{"event":"link.clicked"}`), now)
+	if err != nil {
+		t.Fatalf("Failed to queue event: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 delivery, got %d", n)
+	}
+	if _, err := db.EnqueueWebhookEvent(ctx, model.EventLinkDeleted, []byte(`{"event":"link.deleted"}`), now.Add(time.Second)); err != nil {
+		t.Fatalf("Failed to queue event: %v", err)
+	}
+
+	// Claimed deliveries are held for the lease
+	claimed, err := db.ClaimWebhookDeliveries(ctx, now.Add(time.Second), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Failed to claim deliveries: %v", err)
+	}
+	if len(claimed) != 2 || claimed[0].URL != crm.URL || claimed[0].Secret != "a" || string(claimed[0].Payload) != `{"event":"link.clicked"}` {
+		t.Fatalf("Expected 2 deliveries with their webhook, got %+v", claimed)
+	}
+	if again, err := db.ClaimWebhookDeliveries(ctx, now.Add(30*time.Second), time.Minute, 10); err != nil || len(again) != 0 {
+		t.Errorf("Expected claimed deliveries to be held, got %+v, %v", again, err)
+	}
+
+	// Attempts are recorded in order
+	failed := model.WebhookAttempt{AttemptedAt: now, DurationMS: 12, StatusCode: 500, Error: "unexpected status 500"}
+	if err := db.RecordWebhookAttempt(ctx, claimed[0].ID, failed, model.DeliveryPending, now.Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to record attempt: %v", err)
+	}
+	retried, err := db.ClaimWebhookDeliveries(ctx, now.Add(time.Minute), time.Minute, 10)
+	if err != nil {
+		t.Fatalf("Failed to claim deliveries: %v", err)
+	}
+	if len(retried) != 1 || retried[0].ID != claimed[0].ID || len(retried[0].Attempts) != 1 || retried[0].Attempts[0] != failed {
+		t.Fatalf("Expected the retry with its attempt, got %+v", retried)
+	}
+	delivered := model.WebhookAttempt{AttemptedAt: now.Add(time.Minute), DurationMS: 8, StatusCode: 200}
+	if err := db.RecordWebhookAttempt(ctx, claimed[0].ID, delivered, model.DeliveryDelivered, time.Time{}); err != nil {
+		t.Fatalf("Failed to record attempt: %v", err)
+	}
+
+	log, err := db.ListWebhookDeliveries(ctx, crm.ID, 10)
+	if err != nil {
+		t.Fatalf("Failed to list deliveries: %v", err)
+	}
+	if len(log) != 1 || log[0].Status != model.DeliveryDelivered || log[0].NextAttemptAt != nil || !slices.Equal(log[0].Attempts, []model.WebhookAttempt{failed, delivered}) {
+		t.Errorf("Expected the delivered delivery with 2 attempts, got %+v", log)
+	}
+	var notFound *model.ErrWebhookNotFound
+	if _, err := db.ListWebhookDeliveries(ctx, 99, 10); !errors.As(err, &notFound) {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+
+	// Finished deliveries are deleted, pending ones kept
+	n, err = db.DeleteWebhookDeliveries(ctx, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to delete deliveries: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 delivery to be deleted, got %d", n)
+	}
+	if log, err := db.ListWebhookDeliveries(ctx, archive.ID, 10); err != nil || len(log) != 1 {
+		t.Errorf("Expected the pending delivery to be kept, got %+v, %v", log, err)
+	}
+
+	// Deleting a webhook deletes its deliveries
+	if err := db.DeleteWebhook(ctx, archive.ID); err != nil {
+		t.Fatalf("Failed to delete webhook: %v", err)
+	}
+	if err := db.DeleteWebhook(ctx, archive.ID); !errors.As(err, &notFound) {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+	var deliveries int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM webhook_deliveries`).Scan(&deliveries); err != nil {
+		t.Fatalf("Failed to count deliveries: %v", err)
+	}
+	if deliveries != 0 {
+		t.Errorf("Expected the deliveries of the deleted webhook to be deleted, got %d", deliveries)
+	}
+}
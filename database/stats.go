@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// bucketFormats are the strftime formats truncating click times to the start of a bucket
+var bucketFormats = map[model.StatsInterval]string{
+	model.StatsHourly: "%Y-%m-%d %H:00:00",
+	model.StatsDaily:  "%Y-%m-%d 00:00:00",
+}
+
+// Dimensions of click_aggregates besides the click attributes of statsColumns
+const (
+	// dimensionTotal holds the clicks and unique visitors of a day
+	dimensionTotal = ""
+
+	// dimensionBot holds the bot visits of a day
+	dimensionBot = "bot"
+)
+
+// statsColumns are the click_events columns with top lists in click statistics
+var statsColumns = []string{"referrer", "browser", "os", "country", "language"}
+
+// ClickStats aggregates the clicks of a URL from from until until. The series
+// and totals are read from the rollups of interval, the top lists from the
+// click events and their daily aggregates. The series only holds buckets with
+// clicks, top lists hold up to limit values. Bot visits are only counted in
+// Bots. Click events rolled up into daily aggregates before the hourly rollups
+// existed are counted at the start of their day.
+func (d *Database) ClickStats(ctx context.Context, shortCode string, from, until time.Time, interval model.StatsInterval, limit int) (_ *model.ClickStats, err error) {
+	ctx, end := startQuery(ctx, "click_stats")
+	defer end(&err)
+
+	// Click times are stored in UTC, so they compare in time order
+	from, until = from.UTC(), until.UTC()
+	stats := &model.ClickStats{ShortCode: shortCode, From: from, Until: until, Interval: interval}
+
+	if stats.Series, stats.Bots, err = d.rollupSeries(ctx, shortCode, from, until, interval); err != nil {
+		return nil, err
+	}
+	for _, point := range stats.Series {
+		stats.Total += point.Clicks
+	}
+
+	if stats.Uniques, err = d.uniqueVisitors(ctx, shortCode, from, until); err != nil {
+		return nil, err
+	}
+
+	lists := []*[]model.StatsCount{&stats.Referrers, &stats.Browsers, &stats.OS, &stats.Countries, &stats.Languages}
+	for i, column := range statsColumns {
+		if *lists[i], err = d.topValues(ctx, column, shortCode, from, until, limit); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// uniqueVisitors counts the unique visitors of a URL from from until until.
+// Visitor hashes change daily, so the unique visitors of each day are summed,
+// reading whole days from the daily rollups and counting the visitors of
+// partial days at either end of the range.
+func (d *Database) uniqueVisitors(ctx context.Context, shortCode string, from, until time.Time) (int64, error) {
+	day := model.StatsDaily.Duration()
+	start, end := from.Truncate(day), until.Truncate(day)
+	if !start.Equal(from) {
+		start = start.Add(day)
+	}
+
+	var uniques int64
+	if start.Before(end) {
+		query := `
+		SELECT COALESCE(SUM(uniques), 0)
+		FROM ` + rollupTables[model.StatsDaily] + `
+		WHERE short_code = ? AND bucket >= ? AND bucket < ?
+		`
+		if err := d.db.QueryRowContext(ctx, query, shortCode, start.Format(time.DateTime), end.Format(time.DateTime)).Scan(&uniques); err != nil {
+			return 0, fmt.Errorf("failed to count unique visitors: %w", err)
+		}
+	}
+
+	// The range starts and ends within a day, or covers parts of the days
+	// before and after the whole ones
+	var partial [][2]time.Time
+	if from.Before(start) {
+		first := start
+		if until.Before(first) {
+			first = until
+		}
+		partial = append(partial, [2]time.Time{from, first})
+	}
+	if !end.Before(start) && end.Before(until) {
+		partial = append(partial, [2]time.Time{end, until})
+	}
+	for _, r := range partial {
+		n, err := d.partialDayUniques(ctx, shortCode, r[0], r[1])
+		if err != nil {
+			return 0, err
+		}
+		uniques += n
+	}
+	return uniques, nil
+}
+
+// partialDayUniques counts the unique visitors of a URL from from until until
+// within a UTC day. The click events of rolled up days are gone, so their
+// hourly unique visitors are summed instead, counting visitors returning in
+// another hour again, or their daily unique visitors taken when their hourly
+// rollups were compacted.
+func (d *Database) partialDayUniques(ctx context.Context, shortCode string, from, until time.Time) (int64, error) {
+	day := from.Truncate(model.StatsDaily.Duration())
+
+	var rolledUp bool
+	query := `SELECT EXISTS (SELECT 1 FROM click_aggregates WHERE short_code = ? AND day = ?)`
+	if err := d.db.QueryRowContext(ctx, query, shortCode, day).Scan(&rolledUp); err != nil {
+		return 0, fmt.Errorf("failed to check for rolled up clicks: %w", err)
+	}
+
+	var uniques int64
+	if !rolledUp {
+		query := `
+		SELECT COUNT(DISTINCT NULLIF(visitor, ''))
+		FROM click_events
+		WHERE short_code = ? AND clicked_at >= ? AND clicked_at < ? AND bot = 0
+		`
+		if err := d.db.QueryRowContext(ctx, query, shortCode, from, until).Scan(&uniques); err != nil {
+			return 0, fmt.Errorf("failed to count unique visitors: %w", err)
+		}
+		return uniques, nil
+	}
+
+	query = strings.NewReplacer("{hourly}", rollupTables[model.StatsHourly], "{daily}", rollupTables[model.StatsDaily]).Replace(`
+	SELECT CASE
+		WHEN EXISTS (SELECT 1 FROM {hourly} WHERE short_code = ?1 AND bucket >= ?4 AND bucket < ?5)
+		THEN (SELECT COALESCE(SUM(uniques), 0) FROM {hourly} WHERE short_code = ?1 AND bucket >= ?2 AND bucket < ?3)
+		ELSE (SELECT COALESCE(SUM(uniques), 0) FROM {daily} WHERE short_code = ?1 AND bucket = ?4)
+	END
+	`)
+	err := d.db.QueryRowContext(ctx, query, shortCode,
+		from.Format(time.DateTime), until.Format(time.DateTime),
+		day.Format(time.DateTime), day.Add(model.StatsDaily.Duration()).Format(time.DateTime),
+	).Scan(&uniques)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unique visitors: %w", err)
+	}
+	return uniques, nil
+}
+
+// topValues counts the clicks and unique visitors of a URL per value of a click_events column,
+// returning the limit values with the most clicks
+func (d *Database) topValues(ctx context.Context, column, shortCode string, from, until time.Time, limit int) ([]model.StatsCount, error) {
+	// column is one of statsColumns
+	query := `
+	SELECT value, SUM(clicks) AS total, SUM(uniques)
+	FROM (
+		SELECT ` + column + ` AS value, COUNT(*) AS clicks, COUNT(DISTINCT NULLIF(visitor, '')) AS uniques
+		FROM click_events
+		WHERE short_code = ?1 AND clicked_at >= ?2 AND clicked_at < ?3 AND bot = 0
+		GROUP BY ` + column + `
+		UNION ALL
+		SELECT value, clicks, uniques
+		FROM click_aggregates
+		WHERE short_code = ?1 AND day >= ?2 AND day < ?3 AND dimension = ?4
+	)
+	GROUP BY value
+	ORDER BY total DESC, value
+	LIMIT ?5
+	`
+
+	rows, err := d.db.QueryContext(ctx, query, shortCode, from, until, column, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count clicks by %s: %w", column, err)
+	}
+	defer rows.Close()
+
+	counts := []model.StatsCount{}
+	for rows.Next() {
+		var count model.StatsCount
+		if err := rows.Scan(&count.Value, &count.Clicks, &count.Uniques); err != nil {
+			return nil, fmt.Errorf("failed to scan click count by %s: %w", column, err)
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating click counts by %s: %w", column, err)
+	}
+	return counts, nil
+}
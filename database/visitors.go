@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// visitorSaltSize is the length of visitor salts in bytes
+const visitorSaltSize = 32
+
+// VisitorSalt returns the salt hashing the visitors of the UTC day of day,
+// creating a random salt on first use. Instances sharing the database share
+// the salt. Salts of days before the previous day are deleted, so visitor
+// hashes of older clicks can no longer be reproduced.
+func (d *Database) VisitorSalt(ctx context.Context, day time.Time) (_ []byte, err error) {
+	ctx, end := startQuery(ctx, "visitor_salt")
+	defer end(&err)
+
+	day = day.UTC()
+	salt := make([]byte, visitorSaltSize)
+	rand.Read(salt)
+
+	_, err = d.db.ExecContext(ctx,
+		`INSERT OR IGNORE INTO visitor_salts (day, salt) VALUES (?, ?)`,
+		day.Format(time.DateOnly), salt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create visitor salt: %w", err)
+	}
+
+	err = d.db.QueryRowContext(ctx,
+		`SELECT salt FROM visitor_salts WHERE day = ?`,
+		day.Format(time.DateOnly),
+	).Scan(&salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read visitor salt: %w", err)
+	}
+
+	_, err = d.db.ExecContext(ctx,
+		`DELETE FROM visitor_salts WHERE day < ?`,
+		day.AddDate(0, 0, -1).Format(time.DateOnly),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete old visitor salts: %w", err)
+	}
+
+	return salt, nil
+}
@@ -0,0 +1,394 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// CreateWebhook saves a webhook and sets its ID
+func (d *Database) CreateWebhook(ctx context.Context, webhook *model.Webhook) (err error) {
+	ctx, end := startQuery(ctx, "create_webhook")
+	defer end(&err)
+
+	events, err := encodeList("events", webhook.Events)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO webhooks (url, events, secret, created_at)
+	VALUES (?, ?, ?, ?)
+	`
+
+	result, err := d.db.ExecContext(ctx, query, webhook.URL, events, webhook.Secret, webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save webhook: %w", err)
+	}
+	if webhook.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to get webhook ID: %w", err)
+	}
+	return nil
+}
+
+// ListWebhooks returns all webhooks including their secrets, oldest first
+func (d *Database) ListWebhooks(ctx context.Context) (_ []*model.Webhook, err error) {
+	ctx, end := startQuery(ctx, "list_webhooks")
+	defer end(&err)
+
+	query := `
+	SELECT id, url, events, secret, created_at
+	FROM webhooks
+	ORDER BY id
+	`
+
+	rows, err := d.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []*model.Webhook
+	for rows.Next() {
+		var webhook model.Webhook
+		var events string
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &events, &webhook.Secret, &webhook.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		if err := json.Unmarshal([]byte(events), &webhook.Events); err != nil {
+			return nil, fmt.Errorf("failed to decode events of webhook %d: %w", webhook.ID, err)
+		}
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook rows: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes a webhook together with its deliveries, returning
+// ErrWebhookNotFound for unknown IDs
+func (d *Database) DeleteWebhook(ctx context.Context, id int64) (err error) {
+	ctx, end := startQuery(ctx, "delete_webhook")
+	defer end(&err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 0 {
+		return &model.ErrWebhookNotFound{ID: id}
+	}
+
+	queries := []string{
+		`DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = ?)`,
+		`DELETE FROM webhook_deliveries WHERE webhook_id = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit deletion: %w", err)
+	}
+	return nil
+}
+
+// EnqueueWebhookEvent queues the delivery of an event to every webhook
+// subscribing to it, due at at, and returns the number of deliveries queued
+func (d *Database) EnqueueWebhookEvent(ctx context.Context, event model.WebhookEvent, payload []byte, at time.Time) (_ int64, err error) {
+	ctx, end := startQuery(ctx, "enqueue_webhook_event")
+	defer end(&err)
+
+	query := `
+	INSERT INTO webhook_deliveries (webhook_id, event, payload, status, next_attempt_at, created_at)
+	SELECT id, ?1, ?2, ?3, ?4, ?4
+	FROM webhooks
+	WHERE EXISTS (SELECT 1 FROM json_each(webhooks.events) WHERE value = ?1)
+	`
+
+	result, err := d.db.ExecContext(ctx, query, event, string(payload), model.DeliveryPending, at.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue webhook deliveries: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return n, nil
+}
+
+// deliveryColumns are the webhook_deliveries columns read by scanDelivery
+const deliveryColumns = `id, webhook_id, event, payload, status, next_attempt_at, created_at`
+
+// scanDelivery scans a row selected with deliveryColumns, followed by columns scanned into extra
+func scanDelivery(row scanner, extra ...any) (*model.WebhookDelivery, error) {
+	var delivery model.WebhookDelivery
+	var payload string
+	var next sql.NullTime
+	dest := []any{&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status, &next, &delivery.CreatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	delivery.Payload = json.RawMessage(payload)
+	if next.Valid {
+		delivery.NextAttemptAt = &next.Time
+	}
+	delivery.Attempts = []model.WebhookAttempt{}
+	return &delivery, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit pending deliveries due at now,
+// oldest first, with the URL and secret of their webhook and their attempts
+// so far. Claimed deliveries are postponed by lease, so they are retried when
+// their attempt is not recorded in time, such as after a crash.
+func (d *Database) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) (_ []*model.WebhookDelivery, err error) {
+	ctx, end := startQuery(ctx, "claim_webhook_deliveries")
+	defer end(&err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT ` + deliveryColumns + `, (SELECT url FROM webhooks WHERE id = webhook_id), (SELECT secret FROM webhooks WHERE id = webhook_id)
+	FROM webhook_deliveries
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at, id
+	LIMIT ?
+	`
+
+	rows, err := tx.QueryContext(ctx, query, model.DeliveryPending, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find due webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*model.WebhookDelivery
+	for rows.Next() {
+		var url, secret string
+		delivery, err := scanDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		delivery.URL, delivery.Secret = url, secret
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook delivery rows: %w", err)
+	}
+	rows.Close()
+
+	leased := now.Add(lease).UTC()
+	for _, delivery := range deliveries {
+		_, err := tx.ExecContext(ctx, `UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id = ?`, leased, delivery.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim webhook delivery: %w", err)
+		}
+	}
+
+	if err := loadAttempts(ctx, tx, deliveries); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit claim: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt records an attempt to deliver a webhook delivery and
+// sets its status. Pending deliveries are attempted again at next, finished
+// ones are not.
+func (d *Database) RecordWebhookAttempt(ctx context.Context, deliveryID int64, attempt model.WebhookAttempt, status model.WebhookDeliveryStatus, next time.Time) (err error) {
+	ctx, end := startQuery(ctx, "record_webhook_attempt")
+	defer end(&err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO webhook_attempts (delivery_id, attempt, attempted_at, duration_ms, status_code, error)
+	SELECT ?1, COALESCE(MAX(attempt), 0) + 1, ?2, ?3, ?4, ?5
+	FROM webhook_attempts
+	WHERE delivery_id = ?1
+	`
+	_, err = tx.ExecContext(ctx, query, deliveryID, attempt.AttemptedAt.UTC(), attempt.DurationMS, attempt.StatusCode, attempt.Error)
+	if err != nil {
+		return fmt.Errorf("failed to save webhook attempt: %w", err)
+	}
+
+	var nextAttemptAt sql.NullTime
+	if status == model.DeliveryPending {
+		nextAttemptAt = sql.NullTime{Time: next.UTC(), Valid: true}
+	}
+	query = `
+	UPDATE webhook_deliveries
+	SET status = ?, next_attempt_at = ?
+	WHERE id = ?
+	`
+	if _, err := tx.ExecContext(ctx, query, status, nextAttemptAt, deliveryID); err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit webhook attempt: %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns up to limit deliveries of a webhook with their
+// attempts, newest first, returning ErrWebhookNotFound for unknown IDs
+func (d *Database) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) (_ []*model.WebhookDelivery, err error) {
+	ctx, end := startQuery(ctx, "list_webhook_deliveries")
+	defer end(&err)
+
+	var exists bool
+	if err := d.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = ?)`, webhookID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to find webhook: %w", err)
+	}
+	if !exists {
+		return nil, &model.ErrWebhookNotFound{ID: webhookID}
+	}
+
+	query := `
+	SELECT ` + deliveryColumns + `
+	FROM webhook_deliveries
+	WHERE webhook_id = ?
+	ORDER BY id DESC
+	LIMIT ?
+	`
+
+	rows, err := d.db.QueryContext(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*model.WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook delivery rows: %w", err)
+	}
+	rows.Close()
+
+	if err := loadAttempts(ctx, d.db, deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// loadAttempts sets the attempts of the given deliveries, oldest first
+func loadAttempts(ctx context.Context, q querier, deliveries []*model.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*model.WebhookDelivery, len(deliveries))
+	ids := make([]any, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		byID[delivery.ID] = delivery
+		ids = append(ids, delivery.ID)
+	}
+
+	query := `
+	SELECT delivery_id, attempted_at, duration_ms, status_code, error
+	FROM webhook_attempts
+	WHERE delivery_id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+	ORDER BY delivery_id, attempt
+	`
+
+	rows, err := q.QueryContext(ctx, query, ids...)
+	if err != nil {
+		return fmt.Errorf("failed to load webhook attempts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var deliveryID int64
+		var attempt model.WebhookAttempt
+		if err := rows.Scan(&deliveryID, &attempt.AttemptedAt, &attempt.DurationMS, &attempt.StatusCode, &attempt.Error); err != nil {
+			return fmt.Errorf("failed to scan webhook attempt: %w", err)
+		}
+		delivery := byID[deliveryID]
+		delivery.Attempts = append(delivery.Attempts, attempt)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating webhook attempt rows: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhookDeliveries deletes the delivered and failed deliveries created
+// before before together with their attempts, returning the number of
+// deliveries deleted
+func (d *Database) DeleteWebhookDeliveries(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, end := startQuery(ctx, "delete_webhook_deliveries")
+	defer end(&err)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	DELETE FROM webhook_attempts
+	WHERE delivery_id IN (
+		SELECT id FROM webhook_deliveries WHERE status != ?1 AND created_at < ?2
+	)
+	`
+	if _, err := tx.ExecContext(ctx, query, model.DeliveryPending, before.UTC()); err != nil {
+		return 0, fmt.Errorf("failed to delete webhook attempts: %w", err)
+	}
+
+	query = `DELETE FROM webhook_deliveries WHERE status != ?1 AND created_at < ?2`
+	result, err := tx.ExecContext(ctx, query, model.DeliveryPending, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit deletion: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// adminAuthMiddleware only lets requests carrying the admin token as a bearer
+// token through. The admin API is not served when no token is configured.
+func (h *HTTPHandler) adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiListBlockedDomainsHandler lists the domains blocked by an administrator
+func (h *HTTPHandler) apiListBlockedDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	domains, err := h.urlService.ListBlockedDomains(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list blocked domains", "error", err)
+		http.Error(w, "Failed to list blocked domains", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"domains": domains})
+}
+
+// apiBlockDomainHandler blocks a destination domain
+func (h *HTTPHandler) apiBlockDomainHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Domain string `json:"domain"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if request.Domain == "" {
+		http.Error(w, "Domain is required", http.StatusBadRequest)
+		return
+	}
+
+	domain, err := h.urlService.BlockDomain(r.Context(), request.Domain)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to block domain", "domain", request.Domain, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Blocked domain", "domain", domain)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"domain": domain})
+}
+
+// apiUnblockDomainHandler removes a domain blocked by an administrator
+func (h *HTTPHandler) apiUnblockDomainHandler(w http.ResponseWriter, r *http.Request) {
+	domain := chi.URLParam(r, "domain")
+
+	if err := h.urlService.UnblockDomain(r.Context(), domain); err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to unblock domain", "domain", domain, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Unblocked domain", "domain", domain)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Domain unblocked successfully"})
+}
+
+// apiListWebhooksHandler lists the webhooks without their secrets
+func (h *HTTPHandler) apiListWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := h.urlService.ListWebhooks(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list webhooks", "error", err)
+		http.Error(w, "Failed to list webhooks", http.StatusInternalServerError)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []*model.Webhook{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"webhooks": webhooks})
+}
+
+// apiCreateWebhookHandler subscribes a URL to link events, responding with
+// the webhook including its secret
+func (h *HTTPHandler) apiCreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		URL    string               `json:"url"`
+		Events []model.WebhookEvent `json:"events"`
+		Secret string               `json:"secret"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if request.URL == "" {
+		http.Error(w, "URL is required", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.urlService.CreateWebhook(r.Context(), request.URL, request.Events, request.Secret)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to create webhook", "url", request.URL, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Created webhook", "id", webhook.ID, "url", webhook.URL, "events", webhook.Events)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// apiDeleteWebhookHandler deletes a webhook and its deliveries
+func (h *HTTPHandler) apiDeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.urlService.DeleteWebhook(r.Context(), id); err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to delete webhook", "id", id, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Deleted webhook", "id", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Webhook deleted successfully"})
+}
+
+// apiWebhookDeliveriesHandler lists the latest deliveries of a webhook with
+// their attempts, up to the limit query parameter
+func (h *HTTPHandler) apiWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deliveries, err := h.urlService.ListWebhookDeliveries(r.Context(), id, limit)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to list webhook deliveries", "id", id, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"deliveries": deliveries})
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/useragent"
+)
+
+// prefetchHeaders are request headers browsers and link preview services set
+// when fetching a page speculatively rather than for a person following a link
+var prefetchHeaders = []string{"Sec-Purpose", "Purpose", "X-Purpose", "X-Moz"}
+
+// isBot reports whether a visit was made by a crawler, link preview or script
+// rather than a person. Besides known User-Agents, HEAD requests sent by link
+// checkers and prefetches of browsers and previews count as bot visits.
+func isBot(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+	for _, header := range prefetchHeaders {
+		value := strings.ToLower(r.Header.Get(header))
+		if strings.Contains(value, "prefetch") || strings.Contains(value, "preview") {
+			return true
+		}
+	}
+	return useragent.IsBot(r.UserAgent())
+}
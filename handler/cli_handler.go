@@ -1,10 +1,16 @@
 package handler
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/jobs"
 	"github.com/mstgnz/self-hosted-url-shortener/service"
 	"github.com/spf13/cobra"
 )
@@ -35,13 +41,71 @@ func (h *CLIHandler) SetupCommands() *cobra.Command {
 	shortenCmd := &cobra.Command{
 		Use:   "shorten [url]",
 		Short: "Shorten a URL",
-		Args:  cobra.ExactArgs(1),
+		Args:  cobra.MaximumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
+			var longURL string
+			if len(args) > 0 {
+				longURL = args[0]
+			}
 			customCode, _ := cmd.Flags().GetString("code")
-			h.shortenURL(args[0], customCode)
+			forceNew, _ := cmd.Flags().GetBool("force-new")
+			stripTracking, _ := cmd.Flags().GetBool("strip-tracking")
+			redirectType, _ := cmd.Flags().GetString("redirect")
+			passPath, _ := cmd.Flags().GetBool("pass-path")
+			passQuery, _ := cmd.Flags().GetString("pass-query")
+			var utm model.UTM
+			for name, value := range utm.Fields() {
+				*value, _ = cmd.Flags().GetString(strings.ReplaceAll(name, "_", "-"))
+			}
+			var variants []model.Variant
+			specs, _ := cmd.Flags().GetStringArray("variant")
+			for _, spec := range specs {
+				variant, err := parseVariant(spec)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					os.Exit(1)
+				}
+				variants = append(variants, variant)
+			}
+			stickyVariants, _ := cmd.Flags().GetBool("sticky")
+			schedule := model.Schedule{
+				ActiveFrom:  timeFlag(cmd, "active-from"),
+				ActiveUntil: timeFlag(cmd, "active-until"),
+			}
+			if longURL == "" && len(variants) == 0 {
+				fmt.Fprintln(os.Stderr, "Error: a URL or --variant is required")
+				os.Exit(1)
+			}
+			h.shortenURL(cmd.Context(), model.ShortenRequest{
+				LongURL:          longURL,
+				CustomCode:       customCode,
+				ForceNew:         forceNew,
+				StripTracking:    stripTracking,
+				RedirectType:     model.RedirectType(redirectType),
+				PathPassthrough:  passPath,
+				QueryPassthrough: model.QueryPassthrough(passQuery),
+				UTM:              utm,
+				Variants:         variants,
+				StickyVariants:   stickyVariants,
+				Schedule:         schedule,
+			})
 		},
 	}
 	shortenCmd.Flags().StringP("code", "c", "", "Custom short code")
+	shortenCmd.Flags().Bool("force-new", false, "Create a new short URL even if the destination was already shortened")
+	shortenCmd.Flags().Bool("strip-tracking", false, "Remove tracking parameters such as utm_source from the URL")
+	shortenCmd.Flags().String("redirect", "", "Redirect type: 301, 302, 307, 308, meta or js (default: server default)")
+	shortenCmd.Flags().Bool("pass-path", false, "Append extra path segments of visits to the destination path")
+	shortenCmd.Flags().String("pass-query", "", "Forward query parameters of visits: keep or override the destination's on conflicts")
+	shortenCmd.Flags().String("utm-source", "", "Campaign source appended as utm_source, such as newsletter")
+	shortenCmd.Flags().String("utm-medium", "", "Campaign medium appended as utm_medium, such as email")
+	shortenCmd.Flags().String("utm-campaign", "", "Campaign name appended as utm_campaign")
+	shortenCmd.Flags().String("utm-term", "", "Campaign term appended as utm_term")
+	shortenCmd.Flags().String("utm-content", "", "Campaign content appended as utm_content")
+	shortenCmd.Flags().StringArray("variant", nil, "Weighted destination used instead of the URL, such as \"70 https://example.com/a\" (repeatable)")
+	shortenCmd.Flags().Bool("sticky", false, "Keep serving returning visitors the same variant")
+	shortenCmd.Flags().String("active-from", "", "Start redirecting at this time, as RFC 3339 or \"2006-01-02 15:04\" in local time")
+	shortenCmd.Flags().String("active-until", "", "Stop redirecting at this time, as RFC 3339 or \"2006-01-02 15:04\" in local time")
 	rootCmd.AddCommand(shortenCmd)
 
 	// List command
@@ -49,9 +113,11 @@ func (h *CLIHandler) SetupCommands() *cobra.Command {
 		Use:   "list",
 		Short: "List all shortened URLs",
 		Run: func(cmd *cobra.Command, args []string) {
-			h.listURLs()
+			campaign, _ := cmd.Flags().GetString("campaign")
+			h.listURLs(cmd.Context(), model.URLFilter{Campaign: campaign})
 		},
 	}
+	listCmd.Flags().String("campaign", "", "List only the URLs of this utm_campaign")
 	rootCmd.AddCommand(listCmd)
 
 	// Get command
@@ -60,7 +126,7 @@ func (h *CLIHandler) SetupCommands() *cobra.Command {
 		Short: "Get details of a shortened URL",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			h.getURL(args[0])
+			h.getURL(cmd.Context(), args[0])
 		},
 	}
 	rootCmd.AddCommand(getCmd)
@@ -71,7 +137,7 @@ func (h *CLIHandler) SetupCommands() *cobra.Command {
 		Short: "Delete a shortened URL",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			h.deleteURL(args[0])
+			h.deleteURL(cmd.Context(), args[0])
 		},
 	}
 	rootCmd.AddCommand(deleteCmd)
@@ -83,18 +149,203 @@ func (h *CLIHandler) SetupCommands() *cobra.Command {
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			outputFile, _ := cmd.Flags().GetString("output")
-			h.generateQR(args[0], outputFile)
+			h.generateQR(cmd.Context(), args[0], outputFile)
 		},
 	}
 	qrCmd.Flags().StringP("output", "o", "qr.png", "Output file for QR code")
 	rootCmd.AddCommand(qrCmd)
 
+	// Rules commands
+	rulesCmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Manage the routing rules of a shortened URL",
+	}
+	rulesListCmd := &cobra.Command{
+		Use:   "list [code]",
+		Short: "List the routing rules of a shortened URL",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			h.listRules(cmd.Context(), args[0])
+		},
+	}
+	rulesAddCmd := &cobra.Command{
+		Use:   "add [code] [url]",
+		Short: "Add a routing rule sending matching visits to url",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			rule := model.Rule{LongURL: args[1]}
+			rule.OS, _ = cmd.Flags().GetStringSlice("os")
+			rule.Devices, _ = cmd.Flags().GetStringSlice("device")
+			rule.Browsers, _ = cmd.Flags().GetStringSlice("browser")
+			rule.Languages, _ = cmd.Flags().GetStringSlice("language")
+			rule.Countries, _ = cmd.Flags().GetStringSlice("country")
+			rule.After, _ = cmd.Flags().GetString("after")
+			rule.Before, _ = cmd.Flags().GetString("before")
+			rule.TimeZone, _ = cmd.Flags().GetString("timezone")
+			h.addRule(cmd.Context(), args[0], rule)
+		},
+	}
+	rulesAddCmd.Flags().StringSlice("os", nil, "Operating systems: ios, android, windows, macos, linux, chromeos")
+	rulesAddCmd.Flags().StringSlice("device", nil, "Device classes: mobile, tablet, desktop")
+	rulesAddCmd.Flags().StringSlice("browser", nil, "Browsers: chrome, safari, firefox, edge, opera, samsung")
+	rulesAddCmd.Flags().StringSlice("language", nil, "Preferred languages, such as de or pt-BR")
+	rulesAddCmd.Flags().StringSlice("country", nil, "Countries of the visitor's IP address as two letter codes, such as DE (requires --geoip-db on the server)")
+	rulesAddCmd.Flags().String("after", "", "Match visits from this time of day, as HH:MM")
+	rulesAddCmd.Flags().String("before", "", "Match visits until this time of day, as HH:MM")
+	rulesAddCmd.Flags().String("timezone", "", "Time zone of --after and --before, such as Europe/Berlin (default: UTC)")
+	rulesRemoveCmd := &cobra.Command{
+		Use:   "remove [code] [number]",
+		Short: "Remove a routing rule by its number in the rules list",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			number, err := strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid rule number '%s'\n", args[1])
+				os.Exit(1)
+			}
+			h.removeRule(cmd.Context(), args[0], number)
+		},
+	}
+	rulesClearCmd := &cobra.Command{
+		Use:   "clear [code]",
+		Short: "Remove all routing rules of a shortened URL",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			h.setRules(cmd.Context(), args[0], nil)
+		},
+	}
+	rulesCmd.AddCommand(rulesListCmd, rulesAddCmd, rulesRemoveCmd, rulesClearCmd)
+	rootCmd.AddCommand(rulesCmd)
+
+	// Schedule commands
+	scheduleCmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Manage the activation window and scheduled destination changes of a shortened URL",
+	}
+	scheduleShowCmd := &cobra.Command{
+		Use:   "show [code]",
+		Short: "Show the activation window and scheduled changes of a shortened URL",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			h.showSchedule(cmd.Context(), args[0])
+		},
+	}
+	scheduleWindowCmd := &cobra.Command{
+		Use:   "window [code]",
+		Short: "Set when a shortened URL redirects, omitted times remove the limit",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			url := h.mustGetURL(cmd.Context(), args[0])
+			schedule := url.Schedule
+			schedule.ActiveFrom = timeFlag(cmd, "from")
+			schedule.ActiveUntil = timeFlag(cmd, "until")
+			h.setSchedule(cmd.Context(), args[0], schedule)
+		},
+	}
+	scheduleWindowCmd.Flags().String("from", "", "Start redirecting at this time, as RFC 3339 or \"2006-01-02 15:04\" in local time")
+	scheduleWindowCmd.Flags().String("until", "", "Stop redirecting at this time, as RFC 3339 or \"2006-01-02 15:04\" in local time")
+	scheduleAddCmd := &cobra.Command{
+		Use:   "add [code] [time] [url]",
+		Short: "Schedule a change of the destination to url at time",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			at, err := parseTime(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			url := h.mustGetURL(cmd.Context(), args[0])
+			schedule := url.Schedule
+			schedule.Changes = append(schedule.Changes, model.ScheduledChange{At: at, LongURL: args[2]})
+			h.setSchedule(cmd.Context(), args[0], schedule)
+		},
+	}
+	scheduleRemoveCmd := &cobra.Command{
+		Use:   "remove [code] [number]",
+		Short: "Remove a scheduled change by its number in the schedule",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			number, err := strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid change number '%s'\n", args[1])
+				os.Exit(1)
+			}
+			url := h.mustGetURL(cmd.Context(), args[0])
+			schedule := url.Schedule
+			if number < 1 || number > len(schedule.Changes) {
+				fmt.Fprintf(os.Stderr, "Error: change %d not found\n", number)
+				os.Exit(1)
+			}
+			schedule.Changes = slices.Delete(schedule.Changes, number-1, number)
+			h.setSchedule(cmd.Context(), args[0], schedule)
+		},
+	}
+	scheduleClearCmd := &cobra.Command{
+		Use:   "clear [code]",
+		Short: "Remove the activation window and all scheduled changes of a shortened URL",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			h.setSchedule(cmd.Context(), args[0], model.Schedule{})
+		},
+	}
+	scheduleCmd.AddCommand(scheduleShowCmd, scheduleWindowCmd, scheduleAddCmd, scheduleRemoveCmd, scheduleClearCmd)
+	rootCmd.AddCommand(scheduleCmd)
+
+	// Retention command
+	retentionCmd := &cobra.Command{
+		Use:   "retention",
+		Short: "Erase visitor data and roll up clicks older than the retention periods now",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := jobs.Run(cmd.Context(), h.urlService.RetentionJob(0)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+	rootCmd.AddCommand(retentionCmd)
+
+	// Rollup commands
+	rollupCmd := &cobra.Command{
+		Use:   "rollup",
+		Short: "Maintain the hourly and daily click rollups of the statistics",
+	}
+	rollupRebuildCmd := &cobra.Command{
+		Use:   "rebuild",
+		Short: "Recompute the click rollups from the stored click events",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := h.urlService.RebuildRollups(cmd.Context()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("Click rollups rebuilt")
+		},
+	}
+	rollupCompactCmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Delete old hourly click rollups, keeping their daily rollups",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			days, _ := cmd.Flags().GetInt("keep-hourly")
+			n, err := h.urlService.CompactRollups(cmd.Context(), time.Now(), time.Duration(days)*24*time.Hour)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Deleted %d hourly rollups older than %d days\n", n, days)
+		},
+	}
+	rollupCompactCmd.Flags().Int("keep-hourly", 31, "Days of hourly rollups to keep")
+	rollupCmd.AddCommand(rollupRebuildCmd, rollupCompactCmd)
+	rootCmd.AddCommand(rollupCmd)
+
 	return rootCmd
 }
 
 // shortenURL shortens a URL
-func (h *CLIHandler) shortenURL(longURL, customCode string) {
-	url, err := h.urlService.ShortenURL(longURL, customCode)
+func (h *CLIHandler) shortenURL(ctx context.Context, req model.ShortenRequest) {
+	url, err := h.urlService.ShortenURL(ctx, req)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -104,9 +355,9 @@ func (h *CLIHandler) shortenURL(longURL, customCode string) {
 	fmt.Printf("Short URL: %s\n", shortURL)
 }
 
-// listURLs lists all shortened URLs
-func (h *CLIHandler) listURLs() {
-	urls, err := h.urlService.ListURLs()
+// listURLs lists the shortened URLs matching filter
+func (h *CLIHandler) listURLs(ctx context.Context, filter model.URLFilter) {
+	urls, err := h.urlService.ListURLs(ctx, filter)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -128,8 +379,8 @@ func (h *CLIHandler) listURLs() {
 }
 
 // getURL gets details of a shortened URL
-func (h *CLIHandler) getURL(shortCode string) {
-	url, err := h.urlService.GetURL(shortCode)
+func (h *CLIHandler) getURL(ctx context.Context, shortCode string) {
+	url, err := h.urlService.GetURL(ctx, shortCode)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -149,12 +400,26 @@ func (h *CLIHandler) getURL(shortCode string) {
 	fmt.Printf("Long URL:   %s\n", url.LongURL)
 	fmt.Printf("Created:    %s\n", url.CreatedAt.Format(time.RFC3339))
 	fmt.Printf("Clicks:     %d\n", url.Clicks)
+	fmt.Printf("Uniques:    %d\n", url.Uniques)
+	fmt.Printf("Bot clicks: %d\n", url.BotClicks)
+	if url.UTM.Campaign != "" {
+		fmt.Printf("Campaign:   %s\n", url.UTM.Campaign)
+	}
+	for _, variant := range url.Variants {
+		fmt.Printf("Variant %s:  %s (weight %d, %d clicks)\n", variant.Name, variant.LongURL, variant.Weight, variant.Clicks)
+	}
+	if from := url.Schedule.ActiveFrom; from != nil {
+		fmt.Printf("Active from:  %s\n", from.Local().Format(time.RFC3339))
+	}
+	if until := url.Schedule.ActiveUntil; until != nil {
+		fmt.Printf("Active until: %s\n", until.Local().Format(time.RFC3339))
+	}
 	fmt.Println("------------------------------------------------------------")
 }
 
 // deleteURL deletes a shortened URL
-func (h *CLIHandler) deleteURL(shortCode string) {
-	err := h.urlService.DeleteURL(shortCode)
+func (h *CLIHandler) deleteURL(ctx context.Context, shortCode string) {
+	err := h.urlService.DeleteURL(ctx, shortCode)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -164,9 +429,9 @@ func (h *CLIHandler) deleteURL(shortCode string) {
 }
 
 // generateQR generates a QR code for a shortened URL
-func (h *CLIHandler) generateQR(shortCode, outputFile string) {
+func (h *CLIHandler) generateQR(ctx context.Context, shortCode, outputFile string) {
 	shortURL := fmt.Sprintf("%s/%s", h.baseURL, shortCode)
-	qrCode, err := h.urlService.GenerateQRCode(shortURL)
+	qrCode, err := h.urlService.GenerateQRCode(ctx, shortURL)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -180,3 +445,134 @@ func (h *CLIHandler) generateQR(shortCode, outputFile string) {
 
 	fmt.Printf("QR code saved to %s\n", outputFile)
 }
+
+// listRules lists the routing rules of a shortened URL
+func (h *CLIHandler) listRules(ctx context.Context, shortCode string) {
+	url := h.mustGetURL(ctx, shortCode)
+	if len(url.Rules) == 0 {
+		fmt.Println("No rules found")
+		return
+	}
+
+	for i, rule := range url.Rules {
+		fmt.Printf("%d. %s\n", i+1, rule.LongURL)
+		printCondition("OS", strings.Join(rule.OS, ", "))
+		printCondition("Devices", strings.Join(rule.Devices, ", "))
+		printCondition("Browsers", strings.Join(rule.Browsers, ", "))
+		printCondition("Languages", strings.Join(rule.Languages, ", "))
+		printCondition("Countries", strings.Join(rule.Countries, ", "))
+		if rule.After != "" || rule.Before != "" {
+			timeZone := rule.TimeZone
+			if timeZone == "" {
+				timeZone = "UTC"
+			}
+			printCondition("Time", fmt.Sprintf("%s-%s %s", rule.After, rule.Before, timeZone))
+		}
+	}
+}
+
+// printCondition prints a condition of a rule unless it is empty
+func printCondition(name, value string) {
+	if value != "" {
+		fmt.Printf("   %-10s %s\n", name+":", value)
+	}
+}
+
+// addRule appends a routing rule to a shortened URL
+func (h *CLIHandler) addRule(ctx context.Context, shortCode string, rule model.Rule) {
+	url := h.mustGetURL(ctx, shortCode)
+	h.setRules(ctx, shortCode, append(url.Rules, rule))
+}
+
+// removeRule removes a routing rule by its number, starting at 1
+func (h *CLIHandler) removeRule(ctx context.Context, shortCode string, number int) {
+	url := h.mustGetURL(ctx, shortCode)
+	if number < 1 || number > len(url.Rules) {
+		fmt.Fprintf(os.Stderr, "Error: rule %d not found\n", number)
+		os.Exit(1)
+	}
+	h.setRules(ctx, shortCode, slices.Delete(url.Rules, number-1, number))
+}
+
+// setRules replaces the routing rules of a shortened URL
+func (h *CLIHandler) setRules(ctx context.Context, shortCode string, rules []model.Rule) {
+	url, err := h.urlService.SetRules(ctx, shortCode, rules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("URL with code '%s' has %d rules\n", shortCode, len(url.Rules))
+}
+
+// showSchedule prints the activation window and scheduled changes of a shortened URL
+func (h *CLIHandler) showSchedule(ctx context.Context, shortCode string) {
+	url := h.mustGetURL(ctx, shortCode)
+	if url.Schedule.IsZero() {
+		fmt.Println("No schedule found")
+		return
+	}
+
+	if from := url.Schedule.ActiveFrom; from != nil {
+		fmt.Printf("Active from:  %s\n", from.Local().Format(time.RFC3339))
+	}
+	if until := url.Schedule.ActiveUntil; until != nil {
+		fmt.Printf("Active until: %s\n", until.Local().Format(time.RFC3339))
+	}
+	for i, change := range url.Schedule.Changes {
+		fmt.Printf("%d. %s %s\n", i+1, change.At.Local().Format(time.RFC3339), change.LongURL)
+	}
+}
+
+// setSchedule replaces the activation window and scheduled changes of a shortened URL
+func (h *CLIHandler) setSchedule(ctx context.Context, shortCode string, schedule model.Schedule) {
+	url, err := h.urlService.SetSchedule(ctx, shortCode, schedule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("URL with code '%s' has %d scheduled changes\n", shortCode, len(url.Schedule.Changes))
+}
+
+// timeFormats are the layouts accepted for times on the command line, times
+// without a zone are in the local time zone
+var timeFormats = []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+// parseTime parses a time given on the command line
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range timeFormats {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s', expected RFC 3339 or \"2006-01-02 15:04\"", s)
+}
+
+// timeFlag returns the time of a flag, or nil when it is not set. Invalid times exit.
+func timeFlag(cmd *cobra.Command, name string) *time.Time {
+	value, _ := cmd.Flags().GetString(name)
+	if value == "" {
+		return nil
+	}
+	t, err := parseTime(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --%s: %v\n", name, err)
+		os.Exit(1)
+	}
+	return &t
+}
+
+// mustGetURL retrieves a URL, exiting when it cannot be found
+func (h *CLIHandler) mustGetURL(ctx context.Context, shortCode string) *model.URL {
+	url, err := h.urlService.GetURL(ctx, shortCode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if url == nil {
+		fmt.Fprintln(os.Stderr, "Error: URL not found")
+		os.Exit(1)
+	}
+	return url
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CSRF protection settings
+const (
+	// csrfCookie is the name of the cookie holding the CSRF session
+	csrfCookie = "csrf_session"
+
+	// csrfField is the name of the form field carrying the CSRF token
+	csrfField = "csrf_token"
+
+	// csrfHeader is the request header carrying the CSRF token for scripts
+	csrfHeader = "X-CSRF-Token"
+)
+
+// Context key of the CSRF token of the request
+const csrfTokenKey contextKey = "csrfToken"
+
+// csrfMiddleware protects the web interface against cross-site request
+// forgery with synchronizer tokens. Each browser gets a random session in a
+// cookie, forms carry a token derived from the session with an HMAC, and
+// unsafe requests without a matching token are rejected.
+func (h *HTTPHandler) csrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := ""
+		if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+			session = cookie.Value
+		}
+
+		if !isSafeMethod(r.Method) {
+			token := r.Header.Get(csrfHeader)
+			if token == "" {
+				token = r.PostFormValue(csrfField)
+			}
+			if session == "" || !hmac.Equal([]byte(token), []byte(h.csrfToken(session))) {
+				slog.WarnContext(r.Context(), "Rejected request with invalid CSRF token", "path", r.URL.Path)
+				h.renderError(w, r, http.StatusForbidden, "Your session has expired, please reload the page and try again")
+				return
+			}
+		}
+
+		if session == "" {
+			session = rand.Text()
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    session,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   strings.HasPrefix(h.baseURL, "https://"),
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		ctx := context.WithValue(r.Context(), csrfTokenKey, h.csrfToken(session))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// csrfToken derives the CSRF token of a session
+func (h *HTTPHandler) csrfToken(session string) string {
+	mac := hmac.New(sha256.New, h.csrfKey)
+	mac.Write([]byte(session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// originMiddleware rejects unsafe requests sent by browsers from other sites.
+// Browsers send the Origin header with such requests, the Referer header is
+// checked when it is missing. Requests without either, such as those of API
+// clients, are let through.
+func (h *HTTPHandler) originMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		source := r.Header.Get("Origin")
+		if source == "" {
+			source = r.Header.Get("Referer")
+		}
+		if source != "" && !h.sameOrigin(r, source) {
+			slog.WarnContext(r.Context(), "Rejected cross-site request", "origin", source, "path", r.URL.Path)
+			http.Error(w, "Cross-site request rejected", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether source belongs to the base URL or the host the request was sent to
+func (h *HTTPHandler) sameOrigin(r *http.Request, source string) bool {
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if base, err := url.Parse(h.baseURL); err == nil && strings.EqualFold(u.Scheme, base.Scheme) && strings.EqualFold(u.Host, base.Host) {
+		return true
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// isSafeMethod reports whether a request method does not change state
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
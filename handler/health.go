@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// healthzHandler reports that the process is alive
+func (h *HTTPHandler) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether the service can serve requests
+func (h *HTTPHandler) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := h.urlService.Ready(r.Context()); err != nil {
+		slog.WarnContext(r.Context(), "Readiness check failed", "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// versionHandler reports the build information of the running binary
+func (h *HTTPHandler) versionHandler(w http.ResponseWriter, r *http.Request) {
+	response := map[string]any{
+		"version": "unknown",
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		response["version"] = info.Main.Version
+		response["go_version"] = info.GoVersion
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				response["revision"] = setting.Value
+			case "vcs.time":
+				response["revision_time"] = setting.Value
+			case "vcs.modified":
+				response["modified"] = setting.Value == "true"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
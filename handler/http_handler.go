@@ -2,15 +2,23 @@ package handler
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/geoip"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/ratelimit"
 	"github.com/mstgnz/self-hosted-url-shortener/service"
 )
 
@@ -25,10 +33,80 @@ type HTTPHandler struct {
 	urlService service.URLServiceInterface
 	baseURL    string
 	templates  *template.Template
+	clickQueue *service.ClickQueue
+	adminToken string
+	limiter    *ratelimit.Limiter
+	apiKeys    map[string]bool
+	csrfKey    []byte
+	geoip      *geoip.DB
+
+	defaultRedirect model.RedirectType
+}
+
+// HTTPOption configures optional HTTP handler behaviour
+type HTTPOption func(*HTTPHandler)
+
+// WithClickQueue records redirect clicks through the given queue
+func WithClickQueue(q *service.ClickQueue) HTTPOption {
+	return func(h *HTTPHandler) {
+		h.clickQueue = q
+	}
+}
+
+// WithAdminToken serves the admin API to requests carrying the given bearer token
+func WithAdminToken(token string) HTTPOption {
+	return func(h *HTTPHandler) {
+		h.adminToken = token
+	}
+}
+
+// WithRateLimiter limits the requests of each client with the given limiter
+func WithRateLimiter(limiter *ratelimit.Limiter) HTTPOption {
+	return func(h *HTTPHandler) {
+		h.limiter = limiter
+	}
+}
+
+// WithAPIKeys rate limits requests carrying one of the given keys in the
+// X-API-Key header per key instead of per IP address
+func WithAPIKeys(keys ...string) HTTPOption {
+	return func(h *HTTPHandler) {
+		h.apiKeys = make(map[string]bool, len(keys))
+		for _, key := range keys {
+			if key != "" {
+				h.apiKeys[key] = true
+			}
+		}
+	}
+}
+
+// WithCSRFKey signs CSRF tokens with the given key, so tokens stay valid
+// across restarts and between instances sharing the key
+func WithCSRFKey(key []byte) HTTPOption {
+	return func(h *HTTPHandler) {
+		if len(key) > 0 {
+			h.csrfKey = key
+		}
+	}
+}
+
+// WithDefaultRedirect redirects URLs without a redirect type of their own with the given type
+func WithDefaultRedirect(redirectType model.RedirectType) HTTPOption {
+	return func(h *HTTPHandler) {
+		h.defaultRedirect = redirectType
+	}
+}
+
+// WithGeoIP locates visitors by IP address with the given database, for
+// country routing rules and click analytics
+func WithGeoIP(db *geoip.DB) HTTPOption {
+	return func(h *HTTPHandler) {
+		h.geoip = db
+	}
 }
 
 // NewHTTPHandler creates a new HTTP handler
-func NewHTTPHandler(urlService service.URLServiceInterface, baseURL string, templatesDir string) (*HTTPHandler, error) {
+func NewHTTPHandler(urlService service.URLServiceInterface, baseURL string, templatesDir string, opts ...HTTPOption) (*HTTPHandler, error) {
 	// Load templates with base template first
 	templates := template.New("")
 
@@ -43,6 +121,12 @@ func NewHTTPHandler(urlService service.URLServiceInterface, baseURL string, temp
 			}
 			return s[:n] + "..."
 		},
+		"percent": func(n, total int64) int64 {
+			if total == 0 {
+				return 0
+			}
+			return n * 100 / total
+		},
 	})
 
 	// Parse all templates
@@ -51,19 +135,36 @@ func NewHTTPHandler(urlService service.URLServiceInterface, baseURL string, temp
 		return nil, fmt.Errorf("failed to parse templates: %w", err)
 	}
 
-	return &HTTPHandler{
+	// Sign CSRF tokens with a random key unless a key is configured
+	csrfKey := make([]byte, 32)
+	if _, err := rand.Read(csrfKey); err != nil {
+		return nil, fmt.Errorf("failed to generate CSRF key: %w", err)
+	}
+
+	h := &HTTPHandler{
 		urlService: urlService,
 		baseURL:    baseURL,
 		templates:  templates,
-	}, nil
+		csrfKey:    csrfKey,
+
+		defaultRedirect: model.RedirectFound,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	return h, nil
 }
 
 // SetupRoutes sets up the HTTP routes
 func (h *HTTPHandler) SetupRoutes(router chi.Router) {
 	// Middleware
-	router.Use(middleware.Logger)
+	router.Use(h.accessLogMiddleware)
 	router.Use(middleware.Recoverer)
+	router.Use(h.tracingMiddleware)
+	router.Use(h.metricsMiddleware)
 	router.Use(h.currentYearMiddleware)
+	router.Use(h.originMiddleware)
 
 	// Static files
 	fileServer := http.FileServer(http.Dir("./static"))
@@ -74,23 +175,74 @@ func (h *HTTPHandler) SetupRoutes(router chi.Router) {
 		http.ServeFile(w, r, "./static/favicon.ico")
 	})
 
-	// Web interface routes
-	router.Get("/", h.indexHandler)
-	router.Get("/urls", h.listURLsHandler)
-	router.Post("/shorten", h.shortenURLHandler)
-	router.Get("/qr/{code}", h.qrCodeHandler)
-	router.Get("/delete/{code}", h.deleteURLHandler)
+	// Health and build information
+	router.Get("/healthz", h.healthzHandler)
+	router.Get("/readyz", h.readyzHandler)
+	router.Get("/version", h.versionHandler)
+
+	// Web interface routes, forms changing state require a CSRF token
+	router.With(h.rateLimitMiddleware(RateLimitRead)).Get("/qr/{code}", h.qrCodeHandler)
+	router.Group(func(r chi.Router) {
+		r.Use(h.csrfMiddleware)
+		r.Get("/", h.indexHandler)
+		r.With(h.rateLimitMiddleware(RateLimitRead)).Get("/urls", h.listURLsHandler)
+		r.With(h.rateLimitMiddleware(RateLimitCreate)).Post("/shorten", h.shortenURLHandler)
+		r.Post("/delete/{code}", h.deleteURLHandler)
+		// Keep the passthrough redirect route from answering other methods
+		r.Get("/delete/{code}", methodNotAllowed(http.MethodPost))
+		r.With(h.rateLimitMiddleware(RateLimitRead)).Get("/stats/{code}", h.statsHandler)
+	})
 
 	// API routes
 	router.Route("/api", func(r chi.Router) {
-		r.Post("/shorten", h.apiShortenURLHandler)
-		r.Get("/urls", h.apiListURLsHandler)
-		r.Get("/url/{code}", h.apiGetURLHandler)
+		r.With(h.rateLimitMiddleware(RateLimitCreate)).Post("/shorten", h.apiShortenURLHandler)
+		r.With(h.rateLimitMiddleware(RateLimitRead)).Get("/urls", h.apiListURLsHandler)
+		r.With(h.rateLimitMiddleware(RateLimitRead)).Get("/url/{code}", h.apiGetURLHandler)
 		r.Delete("/url/{code}", h.apiDeleteURLHandler)
+		r.Put("/url/{code}/rules", h.apiSetRulesHandler)
+		r.Put("/url/{code}/schedule", h.apiSetScheduleHandler)
+		r.With(h.rateLimitMiddleware(RateLimitRead)).Get("/url/{code}/stats", h.apiStatsHandler)
+
+		// Admin routes
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(h.adminAuthMiddleware)
+			r.Get("/blocklist", h.apiListBlockedDomainsHandler)
+			r.Post("/blocklist", h.apiBlockDomainHandler)
+			r.Delete("/blocklist/{domain}", h.apiUnblockDomainHandler)
+			r.Get("/webhooks", h.apiListWebhooksHandler)
+			r.Post("/webhooks", h.apiCreateWebhookHandler)
+			r.Delete("/webhooks/{id}", h.apiDeleteWebhookHandler)
+			r.Get("/webhooks/{id}/deliveries", h.apiWebhookDeliveriesHandler)
+		})
 	})
 
-	// Redirect route
-	router.Get("/{code}", h.redirectHandler)
+	// Redirect routes, link checkers probe them with HEAD requests
+	redirects := router.With(h.rateLimitMiddleware(RateLimitRedirect))
+	for _, route := range []string{redirectRoute, passthroughRoute} {
+		redirects.Get(route, h.redirectHandler)
+		redirects.Head(route, h.redirectHandler)
+	}
+}
+
+// ReservedCodes returns the first path segments of the routes served by the
+// handler, which would shadow short codes with the same name
+func ReservedCodes() []string {
+	router := chi.NewRouter()
+	(&HTTPHandler{}).SetupRoutes(router)
+
+	seen := make(map[string]bool)
+	var codes []string
+	chi.Walk(router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		segment, _, _ := strings.Cut(strings.TrimPrefix(route, "/"), "/")
+		if segment == "" || strings.ContainsAny(segment, "{*") || seen[segment] {
+			return nil
+		}
+		seen[segment] = true
+		codes = append(codes, segment)
+		return nil
+	})
+
+	return codes
 }
 
 // currentYearMiddleware adds the current year to the request context
@@ -107,6 +259,7 @@ func (h *HTTPHandler) indexHandler(w http.ResponseWriter, r *http.Request) {
 	err := h.templates.ExecuteTemplate(w, "base.html", map[string]any{
 		"baseURL":     h.baseURL,
 		"currentYear": r.Context().Value(currentYearKey),
+		"csrfToken":   r.Context().Value(csrfTokenKey),
 	})
 
 	if err != nil {
@@ -117,8 +270,9 @@ func (h *HTTPHandler) indexHandler(w http.ResponseWriter, r *http.Request) {
 
 // listURLsHandler handles the URL listing page
 func (h *HTTPHandler) listURLsHandler(w http.ResponseWriter, r *http.Request) {
-	urls, err := h.urlService.ListURLs()
+	urls, err := h.urlService.ListURLs(r.Context(), model.URLFilter{})
 	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list URLs", "error", err)
 		http.Error(w, fmt.Sprintf("Failed to list URLs: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -127,6 +281,7 @@ func (h *HTTPHandler) listURLsHandler(w http.ResponseWriter, r *http.Request) {
 		"urls":        urls,
 		"baseURL":     h.baseURL,
 		"currentYear": r.Context().Value(currentYearKey),
+		"csrfToken":   r.Context().Value(csrfTokenKey),
 	})
 
 	if err != nil {
@@ -144,15 +299,45 @@ func (h *HTTPHandler) shortenURLHandler(w http.ResponseWriter, r *http.Request)
 
 	longURL := r.PostForm.Get("url")
 	customCode := r.PostForm.Get("custom_code")
+	stripTracking := r.PostForm.Get("strip_tracking") != ""
+	redirectType := model.RedirectType(r.PostForm.Get("redirect_type"))
+	pathPassthrough := r.PostForm.Get("path_passthrough") != ""
+	queryPassthrough := model.QueryPassthrough(r.PostForm.Get("query_passthrough"))
+	var utm model.UTM
+	for name, value := range utm.Fields() {
+		*value = r.PostForm.Get(name)
+	}
+	variants, err := parseVariants(r.PostForm.Get("variants"))
+	if err != nil {
+		h.renderError(w, r, http.StatusBadRequest, fmt.Sprintf("Error shortening URL: %v", err))
+		return
+	}
+	stickyVariants := r.PostForm.Get("sticky_variants") != ""
 
-	if longURL == "" {
+	if longURL == "" && len(variants) == 0 {
 		http.Error(w, "URL is required", http.StatusBadRequest)
 		return
 	}
 
-	url, err := h.urlService.ShortenURL(longURL, customCode)
+	url, err := h.urlService.ShortenURL(r.Context(), model.ShortenRequest{
+		LongURL:          longURL,
+		CustomCode:       customCode,
+		StripTracking:    stripTracking,
+		RedirectType:     redirectType,
+		PathPassthrough:  pathPassthrough,
+		QueryPassthrough: queryPassthrough,
+		UTM:              utm,
+		Variants:         variants,
+		StickyVariants:   stickyVariants,
+	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error shortening URL: %v", err), http.StatusInternalServerError)
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to shorten URL", "error", err)
+			h.renderError(w, r, status, "Failed to shorten URL")
+			return
+		}
+		h.renderError(w, r, status, fmt.Sprintf("Error shortening URL: %v", err))
 		return
 	}
 
@@ -163,6 +348,7 @@ func (h *HTTPHandler) shortenURLHandler(w http.ResponseWriter, r *http.Request)
 		"shortURL":    shortURL,
 		"baseURL":     h.baseURL,
 		"currentYear": r.Context().Value(currentYearKey),
+		"csrfToken":   r.Context().Value(csrfTokenKey),
 	})
 
 	if err != nil {
@@ -176,12 +362,10 @@ func (h *HTTPHandler) qrCodeHandler(w http.ResponseWriter, r *http.Request) {
 	code := chi.URLParam(r, "code")
 	shortURL := fmt.Sprintf("%s/%s", h.baseURL, code)
 
-	qrCode, err := h.urlService.GenerateQRCode(shortURL)
+	qrCode, err := h.urlService.GenerateQRCode(r.Context(), shortURL)
 	if err != nil {
-		h.templates.ExecuteTemplate(w, "error.html", map[string]any{
-			"error":       "Failed to generate QR code",
-			"currentYear": r.Context().Value(currentYearKey),
-		})
+		slog.ErrorContext(r.Context(), "Failed to generate QR code", "code", code, "error", err)
+		h.renderError(w, r, http.StatusInternalServerError, "Failed to generate QR code")
 		return
 	}
 
@@ -193,16 +377,40 @@ func (h *HTTPHandler) qrCodeHandler(w http.ResponseWriter, r *http.Request) {
 func (h *HTTPHandler) deleteURLHandler(w http.ResponseWriter, r *http.Request) {
 	code := chi.URLParam(r, "code")
 
-	err := h.urlService.DeleteURL(code)
+	err := h.urlService.DeleteURL(r.Context(), code)
 	if err != nil {
-		h.templates.ExecuteTemplate(w, "error.html", map[string]any{
-			"error":       "Failed to delete URL",
-			"currentYear": r.Context().Value(currentYearKey),
-		})
+		slog.ErrorContext(r.Context(), "Failed to delete URL", "code", code, "error", err)
+		h.renderError(w, r, http.StatusInternalServerError, "Failed to delete URL")
 		return
 	}
 
-	http.Redirect(w, r, "/urls", http.StatusFound)
+	http.Redirect(w, r, "/urls", http.StatusSeeOther)
+}
+
+// renderError renders the error page with the given status code
+func (h *HTTPHandler) renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.WriteHeader(status)
+	err := h.templates.ExecuteTemplate(w, "base.html", map[string]any{
+		"error":       message,
+		"currentYear": r.Context().Value(currentYearKey),
+		"csrfToken":   r.Context().Value(csrfTokenKey),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render error page", "error", err)
+	}
+}
+
+// renderDisabled renders the page shown for links whose destination is blocked
+func (h *HTTPHandler) renderDisabled(w http.ResponseWriter, r *http.Request, url *model.URL) {
+	w.WriteHeader(http.StatusGone)
+	err := h.templates.ExecuteTemplate(w, "base.html", map[string]any{
+		"disabled":    url,
+		"currentYear": r.Context().Value(currentYearKey),
+		"csrfToken":   r.Context().Value(csrfTokenKey),
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render disabled page", "error", err)
+	}
 }
 
 // redirectHandler redirects to the original URL
@@ -215,8 +423,9 @@ func (h *HTTPHandler) redirectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	url, err := h.urlService.GetURL(code)
+	url, err := h.urlService.GetURL(r.Context(), code)
 	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve URL", "code", code, "error", err)
 		http.Error(w, fmt.Sprintf("Failed to retrieve URL: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -226,10 +435,111 @@ func (h *HTTPHandler) redirectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Links are only redirected within their activation window
+	now := time.Now()
+	if url.Schedule.Pending(now) || url.Schedule.Ended(now) {
+		h.renderUnavailable(w, r, url, now)
+		return
+	}
+
+	// Routing rules take precedence over variants and the destination
+	location := h.geoip.LookupString(r.RemoteAddr)
+	visitor := visitorOf(r, now)
+	visitor.Country = location.Country
+	click := model.Click{
+		ShortCode:  code,
+		Country:    location.Country,
+		Region:     location.Region,
+		Referrer:   referrerHost(r.Referer()),
+		Browser:    visitor.Agent.Browser,
+		OS:         visitor.Agent.OS,
+		Language:   strings.ToLower(visitor.Language),
+		Bot:        isBot(r),
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		DoNotTrack: doNotTrack(r),
+		ClickedAt:  now,
+	}
+	longURL := url.DestinationAt(now)
+	var rule *model.Rule
+	if len(url.Rules) > 0 {
+		w.Header().Add("Vary", "User-Agent, Accept-Language")
+		rule = service.MatchRule(url, visitor)
+	}
+	if rule != nil {
+		longURL = rule.LongURL
+	} else if variant := h.chooseVariant(w, r, url); variant != nil {
+		click.Variant = variant.Name
+		longURL = variant.LongURL
+	}
+
+	target, ok := destination(url, longURL, r)
+	if !ok {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	}
+
+	// Refuse to redirect to destinations that were blocked after the link was created
+	if err := h.urlService.CheckDestination(r.Context(), longURL); err != nil {
+		slog.WarnContext(r.Context(), "Blocked redirect", "code", code, "error", err)
+		h.renderDisabled(w, r, url)
+		return
+	}
+
 	// Record click asynchronously
-	go h.urlService.RecordClick(code)
+	h.recordClick(r.Context(), click)
+
+	redirectType := url.RedirectType
+	if redirectType == model.RedirectDefault {
+		redirectType = h.defaultRedirect
+	}
+
+	switch redirectType {
+	case model.RedirectMeta, model.RedirectJS:
+		h.renderRedirectPage(w, r, target, redirectType)
+	default:
+		status, ok := redirectStatus[redirectType]
+		if !ok {
+			status = http.StatusFound
+		}
+		http.Redirect(w, r, target, status)
+	}
+}
+
+// redirectStatus maps HTTP redirect types to their status code
+var redirectStatus = map[model.RedirectType]int{
+	model.RedirectMovedPermanently: http.StatusMovedPermanently,
+	model.RedirectFound:            http.StatusFound,
+	model.RedirectTemporary:        http.StatusTemporaryRedirect,
+	model.RedirectPermanent:        http.StatusPermanentRedirect,
+}
 
-	http.Redirect(w, r, url.LongURL, http.StatusFound)
+// renderRedirectPage renders a page redirecting in the browser, letting
+// scripts on the page such as tracking pixels run before leaving
+func (h *HTTPHandler) renderRedirectPage(w http.ResponseWriter, r *http.Request, target string, redirectType model.RedirectType) {
+	w.Header().Set("Cache-Control", "no-store")
+	err := h.templates.ExecuteTemplate(w, "redirect.html", map[string]any{
+		"target": target,
+		"meta":   redirectType == model.RedirectMeta,
+		"js":     redirectType == model.RedirectJS,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render redirect page", "error", err)
+	}
+}
+
+// recordClick records a click without blocking the redirect
+func (h *HTTPHandler) recordClick(ctx context.Context, click model.Click) {
+	if h.clickQueue != nil {
+		h.clickQueue.Enqueue(ctx, click)
+		return
+	}
+	go func() {
+		ctx := context.WithoutCancel(ctx)
+		if err := h.urlService.RecordClick(ctx, click); err != nil {
+			slog.ErrorContext(ctx, "Failed to record click", "code", click.ShortCode, "error", err)
+		}
+	}()
 }
 
 // API Handlers
@@ -237,8 +547,17 @@ func (h *HTTPHandler) redirectHandler(w http.ResponseWriter, r *http.Request) {
 // apiShortenURLHandler handles API URL shortening requests
 func (h *HTTPHandler) apiShortenURLHandler(w http.ResponseWriter, r *http.Request) {
 	var request struct {
-		URL        string `json:"url"`
-		CustomCode string `json:"custom_code"`
+		URL              string                 `json:"url"`
+		CustomCode       string                 `json:"custom_code"`
+		StripTracking    bool                   `json:"strip_tracking"`
+		RedirectType     model.RedirectType     `json:"redirect_type"`
+		PathPassthrough  bool                   `json:"path_passthrough"`
+		QueryPassthrough model.QueryPassthrough `json:"query_passthrough"`
+		Variants         []model.Variant        `json:"variants"`
+		StickyVariants   bool                   `json:"sticky_variants"`
+		Rules            []model.Rule           `json:"rules"`
+		Schedule         model.Schedule         `json:"schedule"`
+		model.UTM
 	}
 
 	// Parse JSON request
@@ -248,51 +567,53 @@ func (h *HTTPHandler) apiShortenURLHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if request.URL == "" {
+	if request.URL == "" && len(request.Variants) == 0 {
 		http.Error(w, "URL is required", http.StatusBadRequest)
 		return
 	}
 
-	url, err := h.urlService.ShortenURL(request.URL, request.CustomCode)
+	forceNew, _ := strconv.ParseBool(r.URL.Query().Get("force_new"))
+
+	url, err := h.urlService.ShortenURL(r.Context(), model.ShortenRequest{
+		LongURL:          request.URL,
+		CustomCode:       request.CustomCode,
+		ForceNew:         forceNew,
+		StripTracking:    request.StripTracking,
+		RedirectType:     request.RedirectType,
+		PathPassthrough:  request.PathPassthrough,
+		QueryPassthrough: request.QueryPassthrough,
+		UTM:              request.UTM,
+		Variants:         request.Variants,
+		StickyVariants:   request.StickyVariants,
+		Rules:            request.Rules,
+		Schedule:         request.Schedule,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to shorten URL", "error", err)
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	shortURL := fmt.Sprintf("%s/%s", h.baseURL, url.ShortCode)
-
-	response := map[string]any{
-		"id":         url.ID,
-		"short_code": url.ShortCode,
-		"long_url":   url.LongURL,
-		"short_url":  shortURL,
-		"created_at": url.CreatedAt.Format(time.RFC3339),
-		"clicks":     url.Clicks,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(h.urlResponse(url))
 }
 
-// apiListURLsHandler handles API URL listing requests
+// apiListURLsHandler handles API URL listing requests, listing the URLs of
+// the campaign given by the campaign query parameter
 func (h *HTTPHandler) apiListURLsHandler(w http.ResponseWriter, r *http.Request) {
-	urls, err := h.urlService.ListURLs()
+	urls, err := h.urlService.ListURLs(r.Context(), model.URLFilter{Campaign: r.URL.Query().Get("campaign")})
 	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list URLs", "error", err)
 		http.Error(w, "Failed to list URLs", http.StatusInternalServerError)
 		return
 	}
 
 	var response []map[string]any
 	for _, url := range urls {
-		shortURL := fmt.Sprintf("%s/%s", h.baseURL, url.ShortCode)
-		response = append(response, map[string]any{
-			"id":         url.ID,
-			"short_code": url.ShortCode,
-			"long_url":   url.LongURL,
-			"short_url":  shortURL,
-			"created_at": url.CreatedAt.Format(time.RFC3339),
-			"clicks":     url.Clicks,
-		})
+		response = append(response, h.urlResponse(url))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -303,8 +624,9 @@ func (h *HTTPHandler) apiListURLsHandler(w http.ResponseWriter, r *http.Request)
 func (h *HTTPHandler) apiGetURLHandler(w http.ResponseWriter, r *http.Request) {
 	code := chi.URLParam(r, "code")
 
-	url, err := h.urlService.GetURL(code)
+	url, err := h.urlService.GetURL(r.Context(), code)
 	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to retrieve URL", "code", code, "error", err)
 		http.Error(w, "Failed to retrieve URL", http.StatusInternalServerError)
 		return
 	}
@@ -314,27 +636,47 @@ func (h *HTTPHandler) apiGetURLHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	shortURL := fmt.Sprintf("%s/%s", h.baseURL, url.ShortCode)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.urlResponse(url))
+}
 
+// urlResponse builds the API representation of a URL
+func (h *HTTPHandler) urlResponse(url *model.URL) map[string]any {
 	response := map[string]any{
-		"id":         url.ID,
-		"short_code": url.ShortCode,
-		"long_url":   url.LongURL,
-		"short_url":  shortURL,
-		"created_at": url.CreatedAt.Format(time.RFC3339),
-		"clicks":     url.Clicks,
+		"id":                url.ID,
+		"short_code":        url.ShortCode,
+		"long_url":          url.LongURL,
+		"short_url":         fmt.Sprintf("%s/%s", h.baseURL, url.ShortCode),
+		"created_at":        url.CreatedAt.Format(time.RFC3339),
+		"clicks":            url.Clicks,
+		"bot_clicks":        url.BotClicks,
+		"uniques":           url.Uniques,
+		"redirect_type":     url.RedirectType,
+		"path_passthrough":  url.PathPassthrough,
+		"query_passthrough": url.QueryPassthrough,
+		"utm":               url.UTM,
+		"strip_tracking":    url.StripTracking,
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	if len(url.Variants) > 0 {
+		response["variants"] = url.Variants
+		response["sticky_variants"] = url.StickyVariants
+	}
+	if len(url.Rules) > 0 {
+		response["rules"] = url.Rules
+	}
+	if !url.Schedule.IsZero() {
+		response["schedule"] = url.Schedule
+	}
+	return response
 }
 
 // apiDeleteURLHandler handles API URL deletion requests
 func (h *HTTPHandler) apiDeleteURLHandler(w http.ResponseWriter, r *http.Request) {
 	code := chi.URLParam(r, "code")
 
-	err := h.urlService.DeleteURL(code)
+	err := h.urlService.DeleteURL(r.Context(), code)
 	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to delete URL", "code", code, "error", err)
 		http.Error(w, "Failed to delete URL", http.StatusInternalServerError)
 		return
 	}
@@ -342,3 +684,38 @@ func (h *HTTPHandler) apiDeleteURLHandler(w http.ResponseWriter, r *http.Request
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "URL deleted successfully"})
 }
+
+// errorStatus maps service errors to HTTP status codes
+func errorStatus(err error) int {
+	var exists *model.ErrCustomCodeAlreadyExists
+	var reserved *model.ErrReservedCode
+	var invalidCode *model.ErrInvalidShortCode
+	var notFound *model.ErrURLNotFound
+	var invalid *model.ErrInvalidURL
+	var invalidDomain *model.ErrInvalidDomain
+	var invalidRedirect *model.ErrInvalidRedirectType
+	var invalidPassthrough *model.ErrInvalidQueryPassthrough
+	var invalidUTM *model.ErrInvalidUTM
+	var invalidVariants *model.ErrInvalidVariants
+	var invalidRule *model.ErrInvalidRule
+	var invalidSchedule *model.ErrInvalidSchedule
+	var invalidStats *model.ErrInvalidStatsRange
+	var invalidWebhook *model.ErrInvalidWebhook
+	var blocked *model.ErrBlockedURL
+	var webhookNotFound *model.ErrWebhookNotFound
+
+	switch {
+	case errors.As(err, &exists):
+		return http.StatusConflict
+	case errors.As(err, &reserved), errors.As(err, &invalidCode), errors.As(err, &invalid), errors.As(err, &invalidDomain), errors.As(err, &invalidRedirect),
+		errors.As(err, &invalidPassthrough), errors.As(err, &invalidUTM), errors.As(err, &invalidVariants),
+		errors.As(err, &invalidRule), errors.As(err, &invalidSchedule), errors.As(err, &invalidStats), errors.As(err, &invalidWebhook):
+		return http.StatusBadRequest
+	case errors.As(err, &blocked):
+		return http.StatusForbidden
+	case errors.As(err, &notFound), errors.As(err, &webhookNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -1,37 +1,73 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/logger"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/metrics"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/ratelimit"
 	"github.com/mstgnz/self-hosted-url-shortener/service"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
-// MockURLService is a mock implementation of the URL service for testing
+// MockURLService is a mock implementation of the URL service for testing.
+// It is safe for concurrent use, as the handler records clicks in the
+// background while serving requests.
 type MockURLService struct {
-	urls map[string]*model.URL
-	id   int64
+	mu       sync.Mutex
+	urls     map[string]*model.URL
+	id       int64
+	readyErr error
+	blocked  map[string]bool
+	webhooks []*model.Webhook
 }
 
 // NewMockURLService creates a new mock URL service
 func NewMockURLService() *MockURLService {
 	return &MockURLService{
-		urls: make(map[string]*model.URL),
-		id:   1,
+		urls:    make(map[string]*model.URL),
+		id:      1,
+		blocked: make(map[string]bool),
 	}
 }
 
+// copyURL returns a copy of url the handler can read while clicks are recorded
+func copyURL(url *model.URL) *model.URL {
+	copied := *url
+	copied.Variants = slices.Clone(url.Variants)
+	return &copied
+}
+
 // ShortenURL creates a shortened URL
-func (m *MockURLService) ShortenURL(longURL, customCode string) (*model.URL, error) {
+func (m *MockURLService) ShortenURL(ctx context.Context, req model.ShortenRequest) (*model.URL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	longURL, customCode := req.LongURL, req.CustomCode
+	if len(req.Variants) > 0 {
+		longURL = req.Variants[0].LongURL
+	}
 	shortCode := customCode
 	if shortCode == "" {
 		shortCode = "generated"
@@ -39,15 +75,24 @@ func (m *MockURLService) ShortenURL(longURL, customCode string) (*model.URL, err
 
 	// Check if the custom code is already in use
 	if _, exists := m.urls[shortCode]; exists && customCode != "" {
-		return nil, fmt.Errorf("custom code '%s' is already in use", customCode)
+		return nil, &model.ErrCustomCodeAlreadyExists{Code: customCode}
 	}
 
 	url := &model.URL{
-		ID:        m.id,
-		ShortCode: shortCode,
-		LongURL:   longURL,
-		CreatedAt: time.Now(),
-		Clicks:    0,
+		ID:               m.id,
+		ShortCode:        shortCode,
+		LongURL:          longURL,
+		CreatedAt:        time.Now(),
+		Clicks:           0,
+		RedirectType:     req.RedirectType,
+		PathPassthrough:  req.PathPassthrough,
+		QueryPassthrough: req.QueryPassthrough,
+		UTM:              req.UTM,
+		StripTracking:    req.StripTracking,
+		Variants:         req.Variants,
+		StickyVariants:   req.StickyVariants,
+		Rules:            req.Rules,
+		Schedule:         req.Schedule,
 	}
 	m.id++
 	m.urls[shortCode] = url
@@ -55,44 +100,231 @@ func (m *MockURLService) ShortenURL(longURL, customCode string) (*model.URL, err
 }
 
 // GetURL retrieves a URL by its short code
-func (m *MockURLService) GetURL(shortCode string) (*model.URL, error) {
+func (m *MockURLService) GetURL(ctx context.Context, shortCode string) (*model.URL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	url, exists := m.urls[shortCode]
 	if !exists {
 		return nil, nil
 	}
-	return url, nil
+	return copyURL(url), nil
 }
 
-// RecordClick records a click for a URL
-func (m *MockURLService) RecordClick(shortCode string) error {
+// SetRules replaces the routing rules of a URL
+func (m *MockURLService) SetRules(ctx context.Context, shortCode string, rules []model.Rule) (*model.URL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	url, exists := m.urls[shortCode]
+	if !exists {
+		return nil, &model.ErrURLNotFound{Code: shortCode}
+	}
+	url.Rules = rules
+	return copyURL(url), nil
+}
+
+// SetSchedule replaces the activation window and scheduled changes of a URL
+func (m *MockURLService) SetSchedule(ctx context.Context, shortCode string, schedule model.Schedule) (*model.URL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	url, exists := m.urls[shortCode]
 	if !exists {
-		return fmt.Errorf("URL with code '%s' not found", shortCode)
+		return nil, &model.ErrURLNotFound{Code: shortCode}
+	}
+	url.Schedule = schedule
+	return copyURL(url), nil
+}
+
+// GetStats returns statistics counting all clicks of a URL on the first day of the range
+func (m *MockURLService) GetStats(ctx context.Context, shortCode string, from, until time.Time, interval model.StatsInterval) (*model.ClickStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	url, exists := m.urls[shortCode]
+	if !exists {
+		return nil, &model.ErrURLNotFound{Code: shortCode}
+	}
+	if interval == "" {
+		interval = model.StatsDaily
+	}
+	return &model.ClickStats{
+		ShortCode: shortCode,
+		From:      from,
+		Until:     until,
+		Interval:  interval,
+		Total:     url.Clicks,
+		Uniques:   url.Uniques,
+		Series:    []model.StatsPoint{{Time: from, Clicks: url.Clicks, Uniques: url.Uniques}, {Time: from.Add(interval.Duration())}},
+		Browsers:  []model.StatsCount{{Value: "firefox", Clicks: url.Clicks}},
+		Referrers: []model.StatsCount{{Value: "", Clicks: url.Clicks}},
+	}, nil
+}
+
+// RecordClick records a click for a URL
+func (m *MockURLService) RecordClick(ctx context.Context, click model.Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	url, exists := m.urls[click.ShortCode]
+	if !exists {
+		return fmt.Errorf("URL with code '%s' not found", click.ShortCode)
+	}
+	if click.Bot {
+		url.BotClicks++
+		return nil
 	}
 	url.Clicks++
+	if variant := url.Variant(click.Variant); variant != nil {
+		variant.Clicks++
+	}
 	return nil
 }
 
-// ListURLs returns all URLs
-func (m *MockURLService) ListURLs() ([]*model.URL, error) {
+// ListURLs returns the URLs of a campaign, or all URLs without one
+func (m *MockURLService) ListURLs(ctx context.Context, filter model.URLFilter) ([]*model.URL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	urls := make([]*model.URL, 0, len(m.urls))
 	for _, url := range m.urls {
-		urls = append(urls, url)
+		if filter.Campaign != "" && url.UTM.Campaign != filter.Campaign {
+			continue
+		}
+		urls = append(urls, copyURL(url))
 	}
 	return urls, nil
 }
 
 // DeleteURL deletes a URL
-func (m *MockURLService) DeleteURL(shortCode string) error {
+func (m *MockURLService) DeleteURL(ctx context.Context, shortCode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	delete(m.urls, shortCode)
 	return nil
 }
 
 // GenerateQRCode generates a QR code for a URL
-func (m *MockURLService) GenerateQRCode(shortURL string) ([]byte, error) {
+func (m *MockURLService) GenerateQRCode(ctx context.Context, shortURL string) ([]byte, error) {
 	return []byte("mock-qr-code"), nil
 }
 
+// CheckDestination rejects destinations whose host is blocked
+func (m *MockURLService) CheckDestination(ctx context.Context, longURL string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return err
+	}
+	if m.blocked[u.Hostname()] {
+		return &model.ErrBlockedURL{URL: longURL, Domain: u.Hostname()}
+	}
+	return nil
+}
+
+// ListBlockedDomains returns the blocked domains
+func (m *MockURLService) ListBlockedDomains(ctx context.Context) ([]*model.BlockedDomain, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	domains := make([]*model.BlockedDomain, 0, len(m.blocked))
+	for domain := range m.blocked {
+		domains = append(domains, &model.BlockedDomain{Domain: domain})
+	}
+	return domains, nil
+}
+
+// BlockDomain blocks a domain
+func (m *MockURLService) BlockDomain(ctx context.Context, domain string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if strings.ContainsAny(domain, " /") {
+		return "", &model.ErrInvalidDomain{Domain: domain}
+	}
+	m.blocked[domain] = true
+	return domain, nil
+}
+
+// UnblockDomain unblocks a domain
+func (m *MockURLService) UnblockDomain(ctx context.Context, domain string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.blocked, domain)
+	return nil
+}
+
+// CreateWebhook saves a webhook, rejecting URLs without a scheme
+func (m *MockURLService) CreateWebhook(ctx context.Context, url string, events []model.WebhookEvent, secret string) (*model.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !strings.HasPrefix(url, "https://") {
+		return nil, &model.ErrInvalidWebhook{Reason: "not an https URL"}
+	}
+	if secret == "" {
+		secret = "generated"
+	}
+	webhook := &model.Webhook{ID: int64(len(m.webhooks) + 1), URL: url, Events: events, Secret: secret}
+	m.webhooks = append(m.webhooks, webhook)
+	return webhook, nil
+}
+
+// ListWebhooks returns the webhooks without their secrets
+func (m *MockURLService) ListWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var webhooks []*model.Webhook
+	for _, webhook := range m.webhooks {
+		listed := *webhook
+		listed.Secret = ""
+		webhooks = append(webhooks, &listed)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes a webhook
+func (m *MockURLService) DeleteWebhook(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := len(m.webhooks)
+	m.webhooks = slices.DeleteFunc(m.webhooks, func(webhook *model.Webhook) bool { return webhook.ID == id })
+	if len(m.webhooks) == n {
+		return &model.ErrWebhookNotFound{ID: id}
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns a delivered delivery with one attempt for every webhook
+func (m *MockURLService) ListWebhookDeliveries(ctx context.Context, id int64, limit int) ([]*model.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !slices.ContainsFunc(m.webhooks, func(webhook *model.Webhook) bool { return webhook.ID == id }) {
+		return nil, &model.ErrWebhookNotFound{ID: id}
+	}
+	return []*model.WebhookDelivery{{
+		ID:        1,
+		WebhookID: id,
+		Event:     model.EventLinkCreated,
+		Payload:   json.RawMessage(`{"event":"link.created"}`),
+		Status:    model.DeliveryDelivered,
+		Attempts:  []model.WebhookAttempt{{StatusCode: http.StatusOK, DurationMS: 5}},
+	}}, nil
+}
+
+// Ready reports the configured readiness error
+func (m *MockURLService) Ready(ctx context.Context) error {
+	return m.readyErr
+}
+
 // Ensure MockURLService implements service.URLService interface
 var _ service.URLServiceInterface = (*MockURLService)(nil)
 
@@ -127,7 +359,7 @@ func TestRedirectHandler(t *testing.T) {
 	handler, service := setupTestHandler(t)
 
 	// Create a URL
-	url, err := service.ShortenURL("https://example.com", "test")
+	url, err := service.ShortenURL(context.Background(), model.ShortenRequest{LongURL: "https://example.com", CustomCode: "test"})
 	if err != nil {
 		t.Fatalf("Failed to shorten URL: %v", err)
 	}
@@ -202,6 +434,24 @@ func TestAPIHandlers(t *testing.T) {
 		}
 	})
 
+	// Test API list URLs of a campaign
+	t.Run("APIListCampaignURLs", func(t *testing.T) {
+		for campaign, expected := range map[string]int{"": 1, "spring": 0} {
+			w := httptest.NewRecorder()
+			handler.apiListURLsHandler(w, httptest.NewRequest("GET", "/api/urls?campaign="+campaign, nil))
+
+			var response struct {
+				URLs []map[string]any `json:"urls"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+			if len(response.URLs) != expected {
+				t.Errorf("Expected %d URLs of campaign '%s', got %d", expected, campaign, len(response.URLs))
+			}
+		}
+	})
+
 	// Test API get URL
 	t.Run("APIGetURL", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/api/url/api-test", nil)
@@ -254,3 +504,1022 @@ func TestAPIHandlers(t *testing.T) {
 		}
 	})
 }
+
+func TestMetricsMiddleware(t *testing.T) {
+	handler, service := setupTestHandler(t)
+
+	// Create a URL
+	if _, err := service.ShortenURL(context.Background(), model.ShortenRequest{LongURL: "https://example.com", CustomCode: "metrics"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(handler.metricsMiddleware)
+	router.Get("/{code}", handler.redirectHandler)
+	router.Handle("/metrics", metrics.Handler())
+
+	// Perform a redirect
+	req := httptest.NewRequest("GET", "/metrics-missing", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	// Scrape the metrics endpoint
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, `url_shortener_http_requests_total{method="GET",route="/{code}",status="404"} 1`) {
+		t.Errorf("Expected redirect request to be counted, got:\n%s", body)
+	}
+	if !strings.Contains(body, `url_shortener_redirect_duration_seconds_count{status="404"} 1`) {
+		t.Errorf("Expected redirect latency to be observed, got:\n%s", body)
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	handler, service := setupTestHandler(t)
+
+	// Record spans in memory
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	// Create a URL
+	if _, err := service.ShortenURL(context.Background(), model.ShortenRequest{LongURL: "https://example.com", CustomCode: "traced"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(handler.tracingMiddleware)
+	router.Get("/{code}", handler.redirectHandler)
+
+	// Perform a redirect with an incoming W3C trace context
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", "/traced", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name() != "GET /{code}" {
+		t.Errorf("Expected span name 'GET /{code}', got '%s'", spans[0].Name())
+	}
+	if spans[0].SpanContext().TraceID().String() != traceID {
+		t.Errorf("Expected trace ID '%s', got '%s'", traceID, spans[0].SpanContext().TraceID())
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	handler, service := setupTestHandler(t)
+
+	// Capture JSON logs
+	var buf bytes.Buffer
+	l, err := logger.New(&buf, "json", "info")
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defaultLogger := slog.Default()
+	slog.SetDefault(l)
+	defer slog.SetDefault(defaultLogger)
+
+	// Create a URL
+	if _, err := service.ShortenURL(context.Background(), model.ShortenRequest{LongURL: "https://example.com", CustomCode: "logged"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Use(middleware.RequestID)
+	router.Use(handler.accessLogMiddleware)
+	router.Get("/{code}", handler.redirectHandler)
+
+	req := httptest.NewRequest("GET", "/logged", nil)
+	req.Header.Set("X-Request-Id", "req-123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Parse the access log line
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse log line %q: %v", buf.String(), err)
+	}
+	if entry["request_id"] != "req-123" {
+		t.Errorf("Expected request ID 'req-123', got '%v'", entry["request_id"])
+	}
+	if entry["code"] != "logged" {
+		t.Errorf("Expected code 'logged', got '%v'", entry["code"])
+	}
+	if entry["status"] != float64(http.StatusFound) {
+		t.Errorf("Expected status %d, got '%v'", http.StatusFound, entry["status"])
+	}
+	if _, ok := entry["latency"]; !ok {
+		t.Errorf("Expected latency to be logged, got %v", entry)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	handler, service := setupTestHandler(t)
+
+	router := chi.NewRouter()
+	router.Get("/healthz", handler.healthzHandler)
+	router.Get("/readyz", handler.readyzHandler)
+	router.Get("/version", handler.versionHandler)
+	router.Get("/{code}", handler.redirectHandler)
+
+	tests := []struct {
+		name     string
+		path     string
+		readyErr error
+		status   int
+	}{
+		{"Healthz", "/healthz", nil, http.StatusOK},
+		{"Ready", "/readyz", nil, http.StatusOK},
+		{"NotReady", "/readyz", fmt.Errorf("database unreachable"), http.StatusServiceUnavailable},
+		{"Version", "/version", nil, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service.readyErr = tt.readyErr
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, w.Code)
+			}
+
+			var response map[string]any
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to parse response: %v", err)
+			}
+		})
+	}
+}
+
+func TestReservedCodes(t *testing.T) {
+	codes := ReservedCodes()
+
+	reserved := make(map[string]bool)
+	for _, code := range codes {
+		reserved[code] = true
+	}
+
+	for _, code := range []string{"api", "urls", "shorten", "qr", "delete", "static", "healthz", "readyz", "version"} {
+		if !reserved[code] {
+			t.Errorf("Expected '%s' to be reserved, got %v", code, codes)
+		}
+	}
+	for code := range reserved {
+		if strings.ContainsAny(code, "{*/") {
+			t.Errorf("Expected only literal path segments, got '%s'", code)
+		}
+	}
+}
+
+func TestRedirectBlockedDestination(t *testing.T) {
+	mockService := NewMockURLService()
+	handler, err := NewHTTPHandler(mockService, "http://localhost:8080", "../templates")
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	if _, err := mockService.ShortenURL(context.Background(), model.ShortenRequest{LongURL: "https://phish.example/login", CustomCode: "phish"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	// The destination is blocked after the link was created
+	mockService.blocked["phish.example"] = true
+
+	req := httptest.NewRequest("GET", "/phish", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected status code %d, got %d", http.StatusGone, w.Code)
+	}
+	if w.Header().Get("Location") != "" {
+		t.Errorf("Expected no redirect, got '%s'", w.Header().Get("Location"))
+	}
+	if !strings.Contains(w.Body.String(), "Link Disabled") {
+		t.Errorf("Expected the link disabled page, got %s", w.Body.String())
+	}
+}
+
+func TestAdminBlocklist(t *testing.T) {
+	handler, mockService := setupTestHandler(t)
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The admin API is not served without a token
+	if w := request("GET", "/api/admin/blocklist", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d without admin token, got %d", http.StatusNotFound, w.Code)
+	}
+
+	handler.adminToken = "secret"
+	if w := request("GET", "/api/admin/blocklist", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d without credentials, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := request("GET", "/api/admin/blocklist", "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d with a wrong token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	if w := request("POST", "/api/admin/blocklist", "secret", `{"domain": "phish.example"}`); w.Code != http.StatusCreated {
+		t.Errorf("Expected status code %d, got %d", http.StatusCreated, w.Code)
+	}
+	if !mockService.blocked["phish.example"] {
+		t.Errorf("Expected domain to be blocked")
+	}
+	if w := request("POST", "/api/admin/blocklist", "secret", `{"domain": "not a domain"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an invalid domain, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w := request("GET", "/api/admin/blocklist", "secret", "")
+	var response struct {
+		Domains []*model.BlockedDomain `json:"domains"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Domains) != 1 || response.Domains[0].Domain != "phish.example" {
+		t.Errorf("Expected the blocked domain to be listed, got %v", response.Domains)
+	}
+
+	if w := request("DELETE", "/api/admin/blocklist/phish.example", "secret", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if mockService.blocked["phish.example"] {
+		t.Errorf("Expected domain to be unblocked")
+	}
+}
+
+func TestAdminWebhooks(t *testing.T) {
+	handler, mockService := setupTestHandler(t)
+	handler.adminToken = "secret"
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The secret is only returned on creation
+	w := request("POST", "/api/admin/webhooks", `{"url": "https://crm.example.com/hook", "events": ["link.created", "link.clicked"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var created model.Webhook
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if created.ID != 1 || created.Secret != "generated" || len(created.Events) != 2 {
+		t.Errorf("Expected the webhook with its secret, got %+v", created)
+	}
+
+	if w := request("POST", "/api/admin/webhooks", `{"url": "ftp://crm.example.com"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an invalid webhook, got %d", http.StatusBadRequest, w.Code)
+	}
+	if w := request("POST", "/api/admin/webhooks", `{"events": ["link.created"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d without URL, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = request("GET", "/api/admin/webhooks", "")
+	if strings.Contains(w.Body.String(), "generated") || !strings.Contains(w.Body.String(), "crm.example.com") {
+		t.Errorf("Expected the webhook without its secret, got %s", w.Body.String())
+	}
+
+	// Delivery log
+	w = request("GET", "/api/admin/webhooks/1/deliveries?limit=10", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	var log struct {
+		Deliveries []struct {
+			Status   string          `json:"status"`
+			Payload  json.RawMessage `json:"payload"`
+			Attempts []struct {
+				StatusCode int   `json:"status_code"`
+				DurationMS int64 `json:"duration_ms"`
+			} `json:"attempts"`
+		} `json:"deliveries"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&log); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(log.Deliveries) != 1 || log.Deliveries[0].Status != "delivered" || string(log.Deliveries[0].Payload) != `{"event":"link.created"}` ||
+		len(log.Deliveries[0].Attempts) != 1 || log.Deliveries[0].Attempts[0].StatusCode != http.StatusOK {
+		t.Errorf("Expected a delivered delivery, got %+v", log)
+	}
+	for path, status := range map[string]int{
+		"/api/admin/webhooks/2/deliveries":         http.StatusNotFound,
+		"/api/admin/webhooks/abc/deliveries":       http.StatusBadRequest,
+		"/api/admin/webhooks/1/deliveries?limit=0": http.StatusBadRequest,
+	} {
+		if w := request("GET", path, ""); w.Code != status {
+			t.Errorf("Expected status code %d for %s, got %d", status, path, w.Code)
+		}
+	}
+
+	if w := request("DELETE", "/api/admin/webhooks/1", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if len(mockService.webhooks) != 0 {
+		t.Errorf("Expected the webhook to be deleted")
+	}
+	if w := request("DELETE", "/api/admin/webhooks/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for an unknown webhook, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	handler, _ := setupTestHandler(t)
+	handler.limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), map[string]ratelimit.Limit{
+		RateLimitCreate: {Rate: 1.0 / 60, Burst: 2},
+	})
+	handler.apiKeys = map[string]bool{"known-key": true}
+
+	router := chi.NewRouter()
+	router.With(handler.rateLimitMiddleware(RateLimitCreate)).Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.With(handler.rateLimitMiddleware(RateLimitRead)).Get("/api/urls", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func(method, path, remoteAddr, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		w := request("POST", "/api/shorten", "192.0.2.1:1234", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status code %d, got %d", i, http.StatusOK, w.Code)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Errorf("Request %d: unexpected rate limit headers %v", i, w.Header())
+		}
+	}
+
+	// The same client on another port is rejected
+	w := request("POST", "/api/shorten", "192.0.2.1:5678", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected Retry-After of 60 seconds, got '%s'", w.Header().Get("Retry-After"))
+	}
+
+	// Other IPs, known API keys and unlimited classes have their own limits
+	if w := request("POST", "/api/shorten", "192.0.2.2:1234", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected another IP to be allowed, got %d", w.Code)
+	}
+	if w := request("POST", "/api/shorten", "192.0.2.1:1234", "known-key"); w.Code != http.StatusOK {
+		t.Errorf("Expected a known API key to be limited separately, got %d", w.Code)
+	}
+	if w := request("POST", "/api/shorten", "192.0.2.1:1234", "unknown-key"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected an unknown API key to be limited by IP, got %d", w.Code)
+	}
+	if w := request("GET", "/api/urls", "192.0.2.1:1234", ""); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("Expected a class without a limit not to be limited, got %d", w.Code)
+	}
+}
+
+func TestCSRFProtection(t *testing.T) {
+	mockService := NewMockURLService()
+	handler, err := NewHTTPHandler(mockService, "http://localhost:8080", "../templates")
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	// Loading a page sets the session cookie and embeds the token
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly session cookie, got %v", cookies)
+	}
+	session := cookies[0]
+	token := handler.csrfToken(session.Value)
+	if !strings.Contains(w.Body.String(), `name="csrf_token" value="`+token+`"`) {
+		t.Fatalf("Expected the form to contain the CSRF token")
+	}
+
+	post := func(path, token string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		form := url.Values{"url": {"https://example.com"}, "custom_code": {"form"}}
+		if token != "" {
+			form.Set(csrfField, token)
+		}
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	other := &http.Cookie{Name: csrfCookie, Value: "another-session"}
+	tests := []struct {
+		name   string
+		token  string
+		cookie *http.Cookie
+		status int
+	}{
+		{"NoToken", "", session, http.StatusForbidden},
+		{"NoCookie", token, nil, http.StatusForbidden},
+		{"WrongToken", "forged", session, http.StatusForbidden},
+		{"OtherSession", token, other, http.StatusForbidden},
+		{"Valid", token, session, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := post("/shorten", tt.token, tt.cookie); w.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	// Deletion is only possible with a POST carrying the token
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/delete/form", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET deletion to be rejected with %d, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+	if _, exists := mockService.urls["form"]; !exists {
+		t.Fatalf("Expected URL to survive a GET request")
+	}
+	if w := post("/delete/form", "", session); w.Code != http.StatusForbidden {
+		t.Errorf("Expected deletion without token to be rejected, got %d", w.Code)
+	}
+	if w := post("/delete/form", token, session); w.Code != http.StatusSeeOther {
+		t.Errorf("Expected status code %d, got %d", http.StatusSeeOther, w.Code)
+	}
+	if _, exists := mockService.urls["form"]; exists {
+		t.Errorf("Expected URL to be deleted")
+	}
+}
+
+func TestOriginCheck(t *testing.T) {
+	handler, _ := setupTestHandler(t)
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"NoOrigin", "", "", http.StatusOK},
+		{"SameOrigin", "Origin", "http://localhost:8080", http.StatusOK},
+		{"RequestHost", "Origin", "http://example.com", http.StatusOK},
+		{"CrossSite", "Origin", "https://evil.example", http.StatusForbidden},
+		{"NullOrigin", "Origin", "null", http.StatusForbidden},
+		{"CrossSiteReferer", "Referer", "https://evil.example/page", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/shorten", strings.NewReader(`{"url": "https://example.com"}`))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestRedirectTypes(t *testing.T) {
+	mockService := NewMockURLService()
+	handler, err := NewHTTPHandler(mockService, "http://localhost:8080", "../templates", WithDefaultRedirect(model.RedirectTemporary))
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	tests := []struct {
+		redirectType model.RedirectType
+		status       int
+		body         string
+	}{
+		{model.RedirectDefault, http.StatusTemporaryRedirect, ""},
+		{model.RedirectMovedPermanently, http.StatusMovedPermanently, ""},
+		{model.RedirectFound, http.StatusFound, ""},
+		{model.RedirectTemporary, http.StatusTemporaryRedirect, ""},
+		{model.RedirectPermanent, http.StatusPermanentRedirect, ""},
+		{model.RedirectMeta, http.StatusOK, `<meta http-equiv="refresh" content="0; url=https://example.com/page?a=1&amp;b=2">`},
+		{model.RedirectJS, http.StatusOK, `window.location.replace("https://example.com/page?a=1\u0026b=2")`},
+	}
+
+	for i, tt := range tests {
+		t.Run("Type"+string(tt.redirectType), func(t *testing.T) {
+			code := fmt.Sprintf("type%d", i)
+			_, err := mockService.ShortenURL(context.Background(), model.ShortenRequest{
+				LongURL:      "https://example.com/page?a=1&b=2",
+				CustomCode:   code,
+				RedirectType: tt.redirectType,
+			})
+			if err != nil {
+				t.Fatalf("Failed to shorten URL: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/"+code, nil))
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, w.Code)
+			}
+			if tt.body == "" {
+				if w.Header().Get("Location") != "https://example.com/page?a=1&b=2" {
+					t.Errorf("Expected redirect to the destination, got '%s'", w.Header().Get("Location"))
+				}
+				return
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("Expected page to contain %s, got %s", tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRedirectPassthrough(t *testing.T) {
+	handler, mockService := setupTestHandler(t)
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	links := []model.ShortenRequest{
+		{LongURL: "https://example.com/docs?lang=en", CustomCode: "off"},
+		{LongURL: "https://example.com/docs?lang=en", CustomCode: "path", PathPassthrough: true},
+		{LongURL: "https://example.com/docs?lang=en", CustomCode: "keep", PathPassthrough: true, QueryPassthrough: model.QueryPassthroughKeep},
+		{LongURL: "https://example.com/docs?lang=en", CustomCode: "override", QueryPassthrough: model.QueryPassthroughOverride},
+	}
+	for _, link := range links {
+		if _, err := mockService.ShortenURL(context.Background(), link); err != nil {
+			t.Fatalf("Failed to shorten URL: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		location string
+	}{
+		{"NoPassthrough", "/off?utm_source=x", http.StatusFound, "https://example.com/docs?lang=en"},
+		{"PathNotAllowed", "/off/getting-started", http.StatusNotFound, ""},
+		{"Path", "/path/getting-started/?utm_source=x", http.StatusFound, "https://example.com/docs/getting-started/?lang=en"},
+		{"PathEscaped", "/path/a%20b/c%2Fd", http.StatusFound, "https://example.com/docs/a%20b/c%2Fd?lang=en"},
+		{"PathDotSegments", "/path/../../admin", http.StatusFound, "https://example.com/docs/admin?lang=en"},
+		{"KeepDestination", "/keep/intro?lang=de&utm_source=x", http.StatusFound, "https://example.com/docs/intro?lang=en&utm_source=x"},
+		{"Override", "/override?lang=de&utm_source=x", http.StatusFound, "https://example.com/docs?lang=de&utm_source=x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("Expected redirect to '%s', got '%s'", tt.location, location)
+			}
+		})
+	}
+}
+
+func TestRedirectVariants(t *testing.T) {
+	handler, mockService := setupTestHandler(t)
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	_, err := mockService.ShortenURL(context.Background(), model.ShortenRequest{
+		CustomCode: "split",
+		Variants: []model.Variant{
+			{Name: "a", LongURL: "https://example.com/a", Weight: 1},
+			{Name: "b", LongURL: "https://example.com/b", Weight: 1},
+		},
+		StickyVariants: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	// A new visitor gets a variant and a cookie remembering it
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/split", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status code %d, got %d", http.StatusFound, w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "variant_split" || cookies[0].Path != "/split" {
+		t.Fatalf("Expected a variant cookie scoped to the short URL, got %+v", cookies)
+	}
+	expected := "https://example.com/" + cookies[0].Value
+	if location := w.Header().Get("Location"); location != expected {
+		t.Errorf("Expected redirect to '%s', got '%s'", expected, location)
+	}
+
+	// A returning visitor keeps the variant
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("GET", "/split", nil)
+		req.AddCookie(&http.Cookie{Name: "variant_split", Value: "b"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if location := w.Header().Get("Location"); location != "https://example.com/b" {
+			t.Fatalf("Expected sticky redirect to variant b, got '%s'", location)
+		}
+	}
+
+	// The API reports the variants with their clicks
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/url/split", nil))
+	var response struct {
+		Variants []model.Variant `json:"variants"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Variants) != 2 {
+		t.Errorf("Expected 2 variants in the response, got %+v", response.Variants)
+	}
+}
+
+func TestParseVariants(t *testing.T) {
+	variants, err := parseVariants("70 https://example.com/a\n\n  https://example.com/b  \n")
+	if err != nil {
+		t.Fatalf("Failed to parse variants: %v", err)
+	}
+	expected := []model.Variant{
+		{LongURL: "https://example.com/a", Weight: 70},
+		{LongURL: "https://example.com/b"},
+	}
+	if len(variants) != len(expected) || variants[0] != expected[0] || variants[1] != expected[1] {
+		t.Errorf("Expected %+v, got %+v", expected, variants)
+	}
+
+	var invalid *model.ErrInvalidVariants
+	if _, err := parseVariants("heavy https://example.com"); !errors.As(err, &invalid) {
+		t.Errorf("Expected ErrInvalidVariants, got %v", err)
+	}
+}
+
+func TestRedirectRules(t *testing.T) {
+	handler, mockService := setupTestHandler(t)
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	_, err := mockService.ShortenURL(context.Background(), model.ShortenRequest{LongURL: "https://example.com", CustomCode: "app"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	// Rules are managed through the API
+	body := `{"rules": [
+		{"url": "https://apps.apple.com/app/id1", "os": ["ios"]},
+		{"url": "https://play.google.com/store/apps/details?id=x", "os": ["android"]},
+		{"url": "https://example.de", "countries": ["DE"]}
+	]}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/url/app/rules", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name      string
+		userAgent string
+		location  string
+	}{
+		{"IOS", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", "https://apps.apple.com/app/id1"},
+		{"Android", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Mobile Safari/537.36", "https://play.google.com/store/apps/details?id=x"},
+		// Visitors are not located without a GeoIP database, so country rules never match
+		{"Desktop", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36", "https://example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/app", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("Expected redirect to '%s', got '%s'", tt.location, location)
+			}
+			if w.Header().Get("Vary") == "" {
+				t.Errorf("Expected a Vary header for a URL with rules")
+			}
+		})
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/url/missing/rules", strings.NewReader(`{"rules": []}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for an unknown URL, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestRedirectSchedule(t *testing.T) {
+	handler, _ := setupTestHandler(t)
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	now := time.Now().UTC()
+	launch := now.Add(time.Hour).Format(time.RFC3339)
+	body := fmt.Sprintf(`{"url": "https://example.com/teaser", "custom_code": "launch", "schedule": {"active_from": "%s"}}`, launch)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/shorten", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"active_from":"`+launch+`"`) {
+		t.Errorf("Expected the schedule in the response, got %s", w.Body.String())
+	}
+
+	// Links are unavailable before their activation
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/launch", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d before activation, got %d", http.StatusNotFound, w.Code)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Expected the unavailable page not to be cached")
+	}
+
+	tests := []struct {
+		name     string
+		schedule string
+		status   int
+		location string
+	}{
+		{
+			"Changed",
+			fmt.Sprintf(`{"active_from": "%s", "changes": [{"at": "%s", "url": "https://example.com/product"}, {"at": "%s", "url": "https://example.com/sale"}]}`,
+				now.Add(-time.Hour).Format(time.RFC3339), now.Add(-time.Minute).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339)),
+			http.StatusFound,
+			"https://example.com/product",
+		},
+		{
+			"Ended",
+			fmt.Sprintf(`{"active_until": "%s"}`, now.Add(-time.Minute).Format(time.RFC3339)),
+			http.StatusGone,
+			"",
+		},
+		{"Cleared", `{}`, http.StatusFound, "https://example.com/teaser"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/url/launch/schedule", strings.NewReader(tt.schedule)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/launch", nil))
+			if w.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.location {
+				t.Errorf("Expected redirect to '%s', got '%s'", tt.location, location)
+			}
+		})
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", "/api/url/missing/schedule", strings.NewReader(`{}`)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d for an unknown URL, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"de-DE,de;q=0.9,en;q=0.8", "de-DE"},
+		{"en;q=0.8, fr;q=0.9", "fr"},
+		{"*, en;q=0.5", "en"},
+		{"en;q=0", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := preferredLanguage(tt.header); got != tt.want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	mockService := NewMockURLService()
+	handler, err := NewHTTPHandler(mockService, "http://localhost:8080", "../templates")
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	url, err := mockService.ShortenURL(context.Background(), model.ShortenRequest{LongURL: "https://example.com", CustomCode: "stats"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	url.Clicks, url.Uniques = 3, 2
+
+	// The JSON API takes the range from the query
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/url/stats/stats?from=2026-05-01&to=2026-05-03&interval=hour", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var stats model.ClickStats
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	from := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	if !stats.From.Equal(from) || !stats.Until.Equal(from.AddDate(0, 0, 3)) || stats.Interval != model.StatsHourly {
+		t.Errorf("Expected hourly stats from 2026-05-01 until 2026-05-04, got %s from %v until %v", stats.Interval, stats.From, stats.Until)
+	}
+	if stats.Total != 3 || len(stats.Browsers) != 1 {
+		t.Errorf("Expected the stats of the URL, got %+v", stats)
+	}
+
+	// The range defaults to the last seven days
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/url/stats/stats", nil))
+	if err := json.NewDecoder(w.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if days := stats.Until.Sub(stats.From); days != 7*24*time.Hour || stats.Until.Before(time.Now()) {
+		t.Errorf("Expected the last seven days, got %v until %v", stats.From, stats.Until)
+	}
+
+	// The page renders the chart and top lists
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats/stats?from=2026-05-01&to=2026-05-07", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, want := range []string{"May 01: 3 clicks, 2 unique visitors", "<strong>2</strong> unique visitors", "height: 100%", "firefox", "Direct", `value="2026-05-07"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the page to contain %q", want)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"InvalidDate", "/api/url/stats/stats?from=May", http.StatusBadRequest},
+		{"UnknownURL", "/api/url/missing/stats", http.StatusNotFound},
+		{"PageInvalidDate", "/stats/stats?to=tomorrow", http.StatusBadRequest},
+		{"PageUnknownURL", "/stats/missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("Expected status code %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestReferrerHost(t *testing.T) {
+	tests := []struct {
+		referer string
+		want    string
+	}{
+		{"https://www.News.example/article?id=1", "news.example"},
+		{"http://blog.example:8080/", "blog.example"},
+		{"android-app://com.example.app", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := referrerHost(tt.referer); got != tt.want {
+			t.Errorf("referrerHost(%q) = %q, want %q", tt.referer, got, tt.want)
+		}
+	}
+}
+
+func TestIsBot(t *testing.T) {
+	browser := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36"
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{"Browser", "GET", map[string]string{"User-Agent": browser}, false},
+		{"LinkPreview", "GET", map[string]string{"User-Agent": "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"}, true},
+		{"NoUserAgent", "GET", nil, true},
+		{"Head", "HEAD", map[string]string{"User-Agent": browser}, true},
+		{"Prefetch", "GET", map[string]string{"User-Agent": browser, "Sec-Purpose": "prefetch;prerender"}, true},
+		{"LegacyPrefetch", "GET", map[string]string{"User-Agent": browser, "X-Moz": "prefetch"}, true},
+		{"Preview", "GET", map[string]string{"User-Agent": browser, "X-Purpose": "preview"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/test", nil)
+			req.Header.Del("User-Agent")
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := isBot(req); got != tt.want {
+				t.Errorf("isBot() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectHead(t *testing.T) {
+	handler, service := setupTestHandler(t)
+	router := chi.NewRouter()
+	handler.SetupRoutes(router)
+
+	if _, err := service.ShortenURL(context.Background(), model.ShortenRequest{LongURL: "https://example.com", CustomCode: "head"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("HEAD", "/head", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com" {
+		t.Errorf("Expected a redirect to the destination, got %d to '%s'", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestDoNotTrack(t *testing.T) {
+	tests := []struct {
+		header string
+		value  string
+		want   bool
+	}{
+		{"DNT", "1", true},
+		{"Sec-GPC", "1", true},
+		{"DNT", "0", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/test", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		if got := doNotTrack(req); got != tt.want {
+			t.Errorf("doNotTrack() with %s: %q = %v, want %v", tt.header, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redirectRoute is the route pattern of the short code redirect
+const redirectRoute = "/{code}"
+
+// metricsMiddleware records request counts and latencies for Prometheus
+func (h *HTTPHandler) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		elapsed := time.Since(start).Seconds()
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		// Use the route pattern rather than the raw path to keep label cardinality bounded
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, strconv.Itoa(status)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route).Observe(elapsed)
+		if route == redirectRoute || route == passthroughRoute {
+			metrics.RedirectDuration.WithLabelValues(strconv.Itoa(status)).Observe(elapsed)
+		}
+	})
+}
+
+// tracingMiddleware starts a server span for each request, continuing any W3C
+// trace context sent by the client, and names it after the matched route
+func (h *HTTPHandler) tracingMiddleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route := rctx.RoutePattern()
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+	})
+
+	return otelhttp.NewHandler(named, "http.request")
+}
+
+// accessLogMiddleware writes one structured log line per request
+func (h *HTTPHandler) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("remote_ip", r.RemoteAddr),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if route := rctx.RoutePattern(); route != "" {
+				attrs = append(attrs, slog.String("route", route))
+			}
+			if code := rctx.URLParam("code"); code != "" {
+				attrs = append(attrs, slog.String("code", code))
+			}
+		}
+
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// Classes of requests with separate rate limits
+const (
+	RateLimitCreate   = "create"
+	RateLimitRedirect = "redirect"
+	RateLimitRead     = "read"
+)
+
+// apiKeyHeader is the request header carrying an API key
+const apiKeyHeader = "X-API-Key"
+
+// rateLimitMiddleware limits the requests of each client for the given class
+// of requests. Clients are identified by a known API key or their IP address.
+func (h *HTTPHandler) rateLimitMiddleware(class string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if h.limiter == nil || !h.limiter.Limit(class).Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := h.limiter.Allow(r.Context(), class, h.clientKey(r))
+			if err != nil {
+				// Do not turn a failing store into an outage
+				slog.ErrorContext(r.Context(), "Failed to check rate limit", "class", class, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limit := h.limiter.Limit(class)
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				metrics.RateLimited.WithLabelValues(class).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifies the client of a request for rate limiting
+func (h *HTTPHandler) clientKey(r *http.Request) string {
+	if key := r.Header.Get(apiKeyHeader); key != "" && h.apiKeys[key] {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the IP address of the client of a request
+func clientIP(r *http.Request) string {
+	// RemoteAddr holds the bare IP when set by middleware.RealIP
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
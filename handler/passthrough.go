@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// passthroughRoute is the route pattern of redirects with extra path segments
+const passthroughRoute = "/{code}/*"
+
+// destination builds the URL a visit redirects to from the destination
+// longURL, forwarding the extra path and the query parameters of the request
+// as allowed by the link. It reports false when the request has extra path
+// segments the link does not accept.
+func destination(url *model.URL, longURL string, r *http.Request) (string, bool) {
+	rest := chi.URLParam(r, "*")
+	if rest != "" && !url.PathPassthrough {
+		return "", false
+	}
+
+	query := r.URL.Query()
+	if url.QueryPassthrough == model.QueryPassthroughOff {
+		query = nil
+	}
+	if rest == "" && len(query) == 0 {
+		return longURL, true
+	}
+
+	dest, err := neturl.Parse(longURL)
+	if err != nil {
+		return longURL, true
+	}
+
+	if rest != "" {
+		// The router matches the escaped path only when the request needs it
+		if r.URL.RawPath == "" {
+			segments := strings.Split(rest, "/")
+			for i, segment := range segments {
+				segments[i] = neturl.PathEscape(segment)
+			}
+			rest = strings.Join(segments, "/")
+		}
+		// Clean dot segments first so the extra path cannot climb above the destination path
+		cleaned := path.Clean("/" + rest)
+		if strings.HasSuffix(rest, "/") && cleaned != "/" {
+			cleaned += "/"
+		}
+		dest = dest.JoinPath(cleaned)
+	}
+
+	if len(query) > 0 {
+		merged := dest.Query()
+		for key, values := range query {
+			if _, exists := merged[key]; exists && url.QueryPassthrough == model.QueryPassthroughKeep {
+				continue
+			}
+			merged[key] = values
+		}
+		dest.RawQuery = merged.Encode()
+	}
+
+	return dest.String(), true
+}
+
+// methodNotAllowed rejects requests to a route that only accepts the allowed methods
+func methodNotAllowed(allowed ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
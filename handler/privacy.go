@@ -0,0 +1,9 @@
+package handler
+
+import "net/http"
+
+// doNotTrack reports whether a request asks not to be tracked with the
+// Do Not Track or Global Privacy Control header
+func doNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/useragent"
+	"github.com/mstgnz/self-hosted-url-shortener/service"
+)
+
+// visitorOf describes the client of a request visiting at now for matching routing rules
+func visitorOf(r *http.Request, now time.Time) service.Visitor {
+	return service.Visitor{
+		Agent:    useragent.Parse(r.UserAgent()),
+		Language: preferredLanguage(r.Header.Get("Accept-Language")),
+		Time:     now,
+	}
+}
+
+// preferredLanguage returns the language tag with the highest quality in an
+// Accept-Language header, such as "de-DE" for "en;q=0.8, de-DE", or an empty
+// string when there is none
+func preferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for part := range strings.SplitSeq(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// apiSetRulesHandler replaces the routing rules of a URL
+func (h *HTTPHandler) apiSetRulesHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	var request struct {
+		Rules []model.Rule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.urlService.SetRules(r.Context(), code, request.Rules)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to set rules", "code", code, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.urlResponse(url))
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// renderUnavailable renders the page shown for links visited outside their
+// activation window, with 404 before the link becomes active and 410 after it ended
+func (h *HTTPHandler) renderUnavailable(w http.ResponseWriter, r *http.Request, url *model.URL, now time.Time) {
+	pending := url.Schedule.Pending(now)
+	status := http.StatusGone
+	data := map[string]any{
+		"unavailable": url,
+		"pending":     pending,
+		"currentYear": r.Context().Value(currentYearKey),
+		"csrfToken":   r.Context().Value(csrfTokenKey),
+	}
+	if pending {
+		status = http.StatusNotFound
+		data["activeFrom"] = url.Schedule.ActiveFrom.UTC()
+	}
+
+	// The page changes when the link becomes active, so it must not be cached
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := h.templates.ExecuteTemplate(w, "base.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to render unavailable page", "error", err)
+	}
+}
+
+// apiSetScheduleHandler replaces the activation window and scheduled changes of a URL
+func (h *HTTPHandler) apiSetScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	var schedule model.Schedule
+	if err := json.NewDecoder(r.Body).Decode(&schedule); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	url, err := h.urlService.SetSchedule(r.Context(), code, schedule)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to set schedule", "code", code, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.urlResponse(url))
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// defaultStatsDays is the number of days shown when no range is selected
+const defaultStatsDays = 7
+
+// statsDateFormat is the format of the dates selecting the range of statistics
+const statsDateFormat = "2006-01-02"
+
+// chart is the click chart of the statistics page
+type chart struct {
+	Bars []chartBar
+
+	// First and Last label the first and last bar on the axis
+	First string
+	Last  string
+}
+
+// chartBar is a bar of the click chart, Height is relative to the highest bar in percent
+type chartBar struct {
+	Label   string
+	Clicks  int64
+	Uniques int64
+	Height  int64
+}
+
+// statsTable is a top list of the statistics page
+type statsTable struct {
+	Title string
+	// Empty names the empty value, such as direct visits for referrers
+	Empty  string
+	Counts []model.StatsCount
+}
+
+// statsRange is the range of statistics selected by a request
+type statsRange struct {
+	From     time.Time
+	Until    time.Time
+	Interval model.StatsInterval
+
+	// FromDate and ToDate are the first and last day of the range, as selected in the form
+	FromDate string
+	ToDate   string
+}
+
+// parseStatsRange parses the range of statistics from the from and to dates and
+// the interval of a request's query. Both dates are included, the range
+// defaults to the last seven days in UTC.
+func parseStatsRange(r *http.Request) (statsRange, error) {
+	query := r.URL.Query()
+	parseDate := func(name string, fallback time.Time) (time.Time, error) {
+		value := query.Get(name)
+		if value == "" {
+			return fallback, nil
+		}
+		t, err := time.Parse(statsDateFormat, value)
+		if err != nil {
+			return t, &model.ErrInvalidStatsRange{Reason: fmt.Sprintf("%s '%s' is not a date as YYYY-MM-DD", name, value)}
+		}
+		return t, nil
+	}
+
+	to, err := parseDate("to", time.Now().UTC().Truncate(24*time.Hour))
+	if err != nil {
+		return statsRange{}, err
+	}
+	from, err := parseDate("from", to.AddDate(0, 0, 1-defaultStatsDays))
+	if err != nil {
+		return statsRange{}, err
+	}
+
+	return statsRange{
+		From:     from,
+		Until:    to.AddDate(0, 0, 1),
+		Interval: model.StatsInterval(query.Get("interval")),
+		FromDate: from.Format(statsDateFormat),
+		ToDate:   to.Format(statsDateFormat),
+	}, nil
+}
+
+// statsHandler renders the click statistics page of a URL
+func (h *HTTPHandler) statsHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	selected, err := parseStatsRange(r)
+	if err != nil {
+		h.renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	stats, err := h.urlService.GetStats(r.Context(), code, selected.From, selected.Until, selected.Interval)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to get stats", "code", code, "error", err)
+		}
+		h.renderError(w, r, status, fmt.Sprintf("Error loading statistics: %v", err))
+		return
+	}
+
+	err = h.templates.ExecuteTemplate(w, "base.html", map[string]any{
+		"stats":       stats,
+		"chart":       newChart(stats),
+		"tables":      statsTables(stats),
+		"range":       selected,
+		"baseURL":     h.baseURL,
+		"currentYear": r.Context().Value(currentYearKey),
+		"csrfToken":   r.Context().Value(csrfTokenKey),
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error rendering template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// apiStatsHandler returns the click statistics of a URL
+func (h *HTTPHandler) apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	selected, err := parseStatsRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := h.urlService.GetStats(r.Context(), code, selected.From, selected.Until, selected.Interval)
+	if err != nil {
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			slog.ErrorContext(r.Context(), "Failed to get stats", "code", code, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// newChart returns the click chart of a series
+func newChart(stats *model.ClickStats) chart {
+	layout := "Jan 02"
+	if stats.Interval == model.StatsHourly {
+		layout = "Jan 02 15:00"
+	}
+
+	var highest int64
+	for _, point := range stats.Series {
+		highest = max(highest, point.Clicks)
+	}
+
+	var c chart
+	for _, point := range stats.Series {
+		bar := chartBar{Label: point.Time.Format(layout), Clicks: point.Clicks, Uniques: point.Uniques}
+		if highest > 0 {
+			bar.Height = point.Clicks * 100 / highest
+		}
+		c.Bars = append(c.Bars, bar)
+	}
+	if len(c.Bars) > 0 {
+		c.First, c.Last = c.Bars[0].Label, c.Bars[len(c.Bars)-1].Label
+	}
+	return c
+}
+
+// statsTables returns the top lists of the statistics page
+func statsTables(stats *model.ClickStats) []statsTable {
+	return []statsTable{
+		{Title: "Referrers", Empty: "Direct", Counts: stats.Referrers},
+		{Title: "Browsers", Empty: "Unknown", Counts: stats.Browsers},
+		{Title: "Operating Systems", Empty: "Unknown", Counts: stats.OS},
+		{Title: "Countries", Empty: "Unknown", Counts: stats.Countries},
+		{Title: "Languages", Empty: "Unknown", Counts: stats.Languages},
+	}
+}
+
+// referrerHost returns the lowercased host of a Referer header, or an empty
+// string for direct visits and referrers that are not web pages
+func referrerHost(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/service"
+)
+
+// Sticky variant cookie settings
+const (
+	// variantCookiePrefix is prepended to the short code to name the cookie holding the variant served
+	variantCookiePrefix = "variant_"
+
+	// variantCookieMaxAge is how long a visitor keeps being served the same variant
+	variantCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// chooseVariant picks the variant of a URL to serve a request, or nil for URLs
+// without variants. With sticky variants, the variant is remembered in a cookie
+// scoped to the short URL.
+func (h *HTTPHandler) chooseVariant(w http.ResponseWriter, r *http.Request, url *model.URL) *model.Variant {
+	if len(url.Variants) == 0 {
+		return nil
+	}
+
+	name := variantCookiePrefix + url.ShortCode
+	previous := ""
+	if cookie, err := r.Cookie(name); err == nil {
+		previous = cookie.Value
+	}
+
+	variant := service.ChooseVariant(url, previous)
+	if url.StickyVariants && variant.Name != previous {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    variant.Name,
+			Path:     "/" + url.ShortCode,
+			MaxAge:   int(variantCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(h.baseURL, "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return variant
+}
+
+// parseVariant parses a variant written as "URL" or "WEIGHT URL", such as "70 https://example.com"
+func parseVariant(s string) (model.Variant, error) {
+	s = strings.TrimSpace(s)
+	weight, longURL, found := strings.Cut(s, " ")
+	if !found {
+		return model.Variant{LongURL: s}, nil
+	}
+
+	n, err := strconv.Atoi(weight)
+	if err != nil {
+		return model.Variant{}, &model.ErrInvalidVariants{Reason: fmt.Sprintf("'%s' is not a weight", weight)}
+	}
+	return model.Variant{LongURL: strings.TrimSpace(longURL), Weight: n}, nil
+}
+
+// parseVariants parses one variant per non-empty line
+func parseVariants(text string) ([]model.Variant, error) {
+	var variants []model.Variant
+	for line := range strings.Lines(text) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		variant, err := parseVariant(line)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
@@ -12,6 +12,27 @@ func (e *ErrCustomCodeAlreadyExists) Error() string {
 	return fmt.Sprintf("custom code '%s' is already in use", e.Code)
 }
 
+// ErrReservedCode is returned when a custom code is reserved for internal use
+type ErrReservedCode struct {
+	Code string
+}
+
+// Error returns the error message
+func (e *ErrReservedCode) Error() string {
+	return fmt.Sprintf("custom code '%s' is reserved", e.Code)
+}
+
+// ErrInvalidShortCode is returned when a custom code violates the code policy
+type ErrInvalidShortCode struct {
+	Code   string
+	Reason string
+}
+
+// Error returns the error message
+func (e *ErrInvalidShortCode) Error() string {
+	return fmt.Sprintf("invalid short code '%s': %s", e.Code, e.Reason)
+}
+
 // ErrURLNotFound is returned when a URL is not found
 type ErrURLNotFound struct {
 	Code string
@@ -24,12 +45,110 @@ func (e *ErrURLNotFound) Error() string {
 
 // ErrInvalidURL is returned when a URL is invalid
 type ErrInvalidURL struct {
-	URL string
+	URL    string
+	Reason string
 }
 
 // Error returns the error message
 func (e *ErrInvalidURL) Error() string {
-	return fmt.Sprintf("invalid URL: %s", e.URL)
+	if e.Reason == "" {
+		return fmt.Sprintf("invalid URL: %s", e.URL)
+	}
+	return fmt.Sprintf("invalid URL '%s': %s", e.URL, e.Reason)
+}
+
+// ErrBlockedURL is returned when the destination of a URL is on the blocklist
+type ErrBlockedURL struct {
+	URL    string
+	Domain string
+}
+
+// Error returns the error message
+func (e *ErrBlockedURL) Error() string {
+	return fmt.Sprintf("destination '%s' is blocked", e.Domain)
+}
+
+// ErrInvalidDomain is returned when a blocklist entry is not a valid domain
+type ErrInvalidDomain struct {
+	Domain string
+}
+
+// Error returns the error message
+func (e *ErrInvalidDomain) Error() string {
+	return fmt.Sprintf("invalid domain '%s'", e.Domain)
+}
+
+// ErrInvalidRedirectType is returned when a redirect type is unknown
+type ErrInvalidRedirectType struct {
+	Type string
+}
+
+// Error returns the error message
+func (e *ErrInvalidRedirectType) Error() string {
+	return fmt.Sprintf("invalid redirect type '%s', expected one of 301, 302, 307, 308, meta or js", e.Type)
+}
+
+// ErrInvalidQueryPassthrough is returned when a query passthrough mode is unknown
+type ErrInvalidQueryPassthrough struct {
+	Mode string
+}
+
+// Error returns the error message
+func (e *ErrInvalidQueryPassthrough) Error() string {
+	return fmt.Sprintf("invalid query passthrough '%s', expected keep or override", e.Mode)
+}
+
+// ErrInvalidUTM is returned when a campaign parameter is invalid
+type ErrInvalidUTM struct {
+	Param  string
+	Reason string
+}
+
+// Error returns the error message
+func (e *ErrInvalidUTM) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Reason)
+}
+
+// ErrInvalidVariants is returned when the variants of a URL are invalid
+type ErrInvalidVariants struct {
+	Reason string
+}
+
+// Error returns the error message
+func (e *ErrInvalidVariants) Error() string {
+	return fmt.Sprintf("invalid variants: %s", e.Reason)
+}
+
+// ErrInvalidRule is returned when a routing rule is invalid
+type ErrInvalidRule struct {
+	// Index is the position of the rule, starting at 1
+	Index  int
+	Reason string
+}
+
+// Error returns the error message
+func (e *ErrInvalidRule) Error() string {
+	return fmt.Sprintf("invalid rule %d: %s", e.Index, e.Reason)
+}
+
+// ErrInvalidSchedule is returned when the activation window or scheduled changes of a URL are invalid
+type ErrInvalidSchedule struct {
+	Reason string
+}
+
+// Error returns the error message
+func (e *ErrInvalidSchedule) Error() string {
+	return fmt.Sprintf("invalid schedule: %s", e.Reason)
+}
+
+// ErrInvalidStatsRange is returned when the time range or interval of click statistics is invalid
+type ErrInvalidStatsRange struct {
+	Reason string
+}
+
+// Error returns the error message
+func (e *ErrInvalidStatsRange) Error() string {
+	return fmt.Sprintf("invalid statistics range: %s", e.Reason)
 }
 
 // ErrDatabaseError is returned when a database error occurs
@@ -41,3 +160,23 @@ type ErrDatabaseError struct {
 func (e *ErrDatabaseError) Error() string {
 	return fmt.Sprintf("database error: %v", e.Err)
 }
+
+// ErrInvalidWebhook is returned when the URL or events of a webhook are invalid
+type ErrInvalidWebhook struct {
+	Reason string
+}
+
+// Error returns the error message
+func (e *ErrInvalidWebhook) Error() string {
+	return fmt.Sprintf("invalid webhook: %s", e.Reason)
+}
+
+// ErrWebhookNotFound is returned when a webhook is not found
+type ErrWebhookNotFound struct {
+	ID int64
+}
+
+// Error returns the error message
+func (e *ErrWebhookNotFound) Error() string {
+	return fmt.Sprintf("webhook %d not found", e.ID)
+}
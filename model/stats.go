@@ -0,0 +1,76 @@
+package model
+
+import "time"
+
+// StatsInterval is the length of the buckets of a click time series
+type StatsInterval string
+
+// Stats intervals
+const (
+	// StatsHourly counts clicks per hour
+	StatsHourly StatsInterval = "hour"
+
+	// StatsDaily counts clicks per day
+	StatsDaily StatsInterval = "day"
+)
+
+// Duration returns the length of a bucket
+func (i StatsInterval) Duration() time.Duration {
+	if i == StatsHourly {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Valid reports whether i is a known interval
+func (i StatsInterval) Valid() bool {
+	return i == StatsHourly || i == StatsDaily
+}
+
+// ClickStats aggregates the clicks of a URL within a time range
+type ClickStats struct {
+	ShortCode string `json:"short_code"`
+
+	// From and Until bound the range, From is included and Until is not
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
+
+	// Interval is the length of the buckets of Series
+	Interval StatsInterval `json:"interval"`
+
+	// Total is the number of clicks within the range
+	Total int64 `json:"total"`
+
+	// Uniques is the number of unique visitors within the range. Visitors are
+	// told apart per day, so a visitor returning on another day counts again.
+	Uniques int64 `json:"uniques"`
+
+	// Bots is the number of visits by bots within the range, which are left
+	// out of all other figures
+	Bots int64 `json:"bots"`
+
+	// Series counts the clicks of every bucket within the range, including empty ones
+	Series []StatsPoint `json:"series"`
+
+	// The top values of click attributes by number of clicks, an empty value
+	// counts clicks where the attribute is unknown or, for referrers, direct visits
+	Referrers []StatsCount `json:"referrers"`
+	Browsers  []StatsCount `json:"browsers"`
+	OS        []StatsCount `json:"os"`
+	Countries []StatsCount `json:"countries"`
+	Languages []StatsCount `json:"languages"`
+}
+
+// StatsPoint is the number of clicks and unique visitors in the bucket starting at Time
+type StatsPoint struct {
+	Time    time.Time `json:"time"`
+	Clicks  int64     `json:"clicks"`
+	Uniques int64     `json:"uniques"`
+}
+
+// StatsCount is the number of clicks and unique visitors with a value of a click attribute
+type StatsCount struct {
+	Value   string `json:"value"`
+	Clicks  int64  `json:"clicks"`
+	Uniques int64  `json:"uniques"`
+}
@@ -1,9 +1,117 @@
 package model
 
 import (
+	"iter"
+	"net/url"
+	"slices"
 	"time"
 )
 
+// RedirectType selects how a short URL redirects to its destination
+type RedirectType string
+
+// Redirect types
+const (
+	// RedirectDefault uses the default redirect type of the server
+	RedirectDefault RedirectType = ""
+
+	// RedirectMovedPermanently redirects with 301 Moved Permanently
+	RedirectMovedPermanently RedirectType = "301"
+
+	// RedirectFound redirects with 302 Found
+	RedirectFound RedirectType = "302"
+
+	// RedirectTemporary redirects with 307 Temporary Redirect
+	RedirectTemporary RedirectType = "307"
+
+	// RedirectPermanent redirects with 308 Permanent Redirect
+	RedirectPermanent RedirectType = "308"
+
+	// RedirectMeta renders a page redirecting with a meta refresh tag
+	RedirectMeta RedirectType = "meta"
+
+	// RedirectJS renders a page redirecting with JavaScript
+	RedirectJS RedirectType = "js"
+)
+
+// RedirectTypes lists the redirect types a URL can choose
+var RedirectTypes = []RedirectType{
+	RedirectMovedPermanently,
+	RedirectFound,
+	RedirectTemporary,
+	RedirectPermanent,
+	RedirectMeta,
+	RedirectJS,
+}
+
+// Valid reports whether t is a known redirect type or the default
+func (t RedirectType) Valid() bool {
+	return t == RedirectDefault || slices.Contains(RedirectTypes, t)
+}
+
+// QueryPassthrough selects how query parameters of a visit are forwarded to the destination
+type QueryPassthrough string
+
+// Query passthrough modes
+const (
+	// QueryPassthroughOff ignores the query parameters of a visit
+	QueryPassthroughOff QueryPassthrough = ""
+
+	// QueryPassthroughKeep forwards query parameters, keeping the destination's own on conflicts
+	QueryPassthroughKeep QueryPassthrough = "keep"
+
+	// QueryPassthroughOverride forwards query parameters, replacing the destination's own on conflicts
+	QueryPassthroughOverride QueryPassthrough = "override"
+)
+
+// Valid reports whether m is a known query passthrough mode
+func (m QueryPassthrough) Valid() bool {
+	return m == QueryPassthroughOff || m == QueryPassthroughKeep || m == QueryPassthroughOverride
+}
+
+// UTM holds the campaign parameters of a link
+type UTM struct {
+	Source   string `json:"utm_source,omitempty"`
+	Medium   string `json:"utm_medium,omitempty"`
+	Campaign string `json:"utm_campaign,omitempty"`
+	Term     string `json:"utm_term,omitempty"`
+	Content  string `json:"utm_content,omitempty"`
+}
+
+// IsZero reports whether no campaign parameter is set
+func (u UTM) IsZero() bool {
+	return u == UTM{}
+}
+
+// Fields yields the query parameter names of the parameters with pointers to
+// them, always in the same order
+func (u *UTM) Fields() iter.Seq2[string, *string] {
+	return func(yield func(string, *string) bool) {
+		_ = yield("utm_source", &u.Source) &&
+			yield("utm_medium", &u.Medium) &&
+			yield("utm_campaign", &u.Campaign) &&
+			yield("utm_term", &u.Term) &&
+			yield("utm_content", &u.Content)
+	}
+}
+
+// Values returns the parameters that are set as query parameters
+func (u UTM) Values() url.Values {
+	values := url.Values{}
+	for name, value := range u.Fields() {
+		if *value != "" {
+			values.Set(name, *value)
+		}
+	}
+	return values
+}
+
+// URLFilter selects the URLs to list, empty fields match every URL
+type URLFilter struct {
+	// Campaign matches URLs with the utm_campaign parameter
+	Campaign string
+}
+
 // URL represents a shortened URL in the database
 type URL struct {
 	ID        int64     `json:"id"`
@@ -11,6 +119,233 @@ type URL struct {
 	LongURL   string    `json:"long_url"`
 	CreatedAt time.Time `json:"created_at"`
 	Clicks    int64     `json:"clicks"`
+	DestHash  string    `json:"-"`
+
+	// BotClicks counts visits by crawlers, link previews and scripts, which are
+	// not counted in Clicks
+	BotClicks int64 `json:"bot_clicks"`
+
+	// Uniques counts unique visitors per day, summed over all days
+	Uniques int64 `json:"uniques"`
+
+	// RedirectType is how the URL redirects, empty uses the server default
+	RedirectType RedirectType `json:"redirect_type,omitempty"`
+
+	// PathPassthrough appends extra path segments of a visit to the destination path
+	PathPassthrough bool `json:"path_passthrough"`
+
+	// QueryPassthrough forwards query parameters of a visit to the destination
+	QueryPassthrough QueryPassthrough `json:"query_passthrough,omitempty"`
+
+	// UTM holds the campaign parameters appended to the destination
+	UTM UTM `json:"utm"`
+
+	// StripTracking removes tracking parameters from the destinations set
+	// later, such as those of scheduled changes
+	StripTracking bool `json:"strip_tracking"`
+
+	// Variants are weighted destinations a visit is distributed across,
+	// LongURL is the destination of the first variant
+	Variants []Variant `json:"variants,omitempty"`
+
+	// StickyVariants serves returning visitors the variant they were first served
+	StickyVariants bool `json:"sticky_variants"`
+
+	// Rules route visits matching their conditions to other destinations,
+	// the first matching rule wins
+	Rules []Rule `json:"rules,omitempty"`
+
+	// Schedule restricts when the URL redirects and changes its destination over time
+	Schedule Schedule `json:"schedule,omitzero"`
+}
+
+// Variant is one of several weighted destinations of a URL
+type Variant struct {
+	Name    string `json:"name"`
+	LongURL string `json:"url"`
+	Weight  int    `json:"weight"`
+	Clicks  int64  `json:"clicks"`
+}
+
+// Variant returns the variant with the given name, or nil
+func (u *URL) Variant(name string) *Variant {
+	for i := range u.Variants {
+		if u.Variants[i].Name == name {
+			return &u.Variants[i]
+		}
+	}
+	return nil
+}
+
+// Rule sends visits matching all of its conditions to its own destination.
+// A condition listing several values matches any of them, conditions left
+// empty match every visit.
+type Rule struct {
+	// LongURL is the destination of matching visits
+	LongURL string `json:"url"`
+
+	// OS lists operating systems, such as ios or android
+	OS []string `json:"os,omitempty"`
+
+	// Devices lists device classes: mobile, tablet or desktop
+	Devices []string `json:"devices,omitempty"`
+
+	// Browsers lists browsers, such as chrome or safari
+	Browsers []string `json:"browsers,omitempty"`
+
+	// Languages lists language tags matched against the preferred language of
+	// the visitor, "pt" matches "pt-BR"
+	Languages []string `json:"languages,omitempty"`
+
+	// Countries lists ISO 3166-1 alpha-2 country codes, such as DE, matched
+	// against the country of the visitor's IP address. Visits are only located
+	// when a GeoIP database is configured.
+	Countries []string `json:"countries,omitempty"`
+
+	// After and Before restrict the time of day, as HH:MM in TimeZone.
+	// A window ending before it starts spans midnight.
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+
+	// TimeZone is the IANA time zone of After and Before, UTC when empty
+	TimeZone string `json:"timezone,omitempty"`
+}
+
+// Schedule restricts when a URL redirects and changes its destination at set
+// times. Visits before ActiveFrom or from ActiveUntil on are not redirected.
+type Schedule struct {
+	// ActiveFrom is when the URL starts redirecting, nil for immediately
+	ActiveFrom *time.Time `json:"active_from,omitempty"`
+
+	// ActiveUntil is when the URL stops redirecting, nil for never
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+
+	// Changes replace the destination at their time, ordered by time
+	Changes []ScheduledChange `json:"changes,omitempty"`
+}
+
+// ScheduledChange replaces the destination of a URL from a point in time
+type ScheduledChange struct {
+	At      time.Time `json:"at"`
+	LongURL string    `json:"url"`
+}
+
+// IsZero reports whether the schedule neither restricts nor changes the URL
+func (s Schedule) IsZero() bool {
+	return s.ActiveFrom == nil && s.ActiveUntil == nil && len(s.Changes) == 0
+}
+
+// Pending reports whether t is before the URL becomes active
+func (s Schedule) Pending(t time.Time) bool {
+	return s.ActiveFrom != nil && t.Before(*s.ActiveFrom)
+}
+
+// Ended reports whether the URL is no longer active at t
+func (s Schedule) Ended(t time.Time) bool {
+	return s.ActiveUntil != nil && !t.Before(*s.ActiveUntil)
+}
+
+// DestinationAt returns the destination of the URL at t, set by the last
+// scheduled change that took effect or LongURL before the first change
+func (u *URL) DestinationAt(t time.Time) string {
+	longURL := u.LongURL
+	for _, change := range u.Schedule.Changes {
+		if t.Before(change.At) {
+			break
+		}
+		longURL = change.LongURL
+	}
+	return longURL
+}
+
+// Click is a recorded visit of a short URL
+type Click struct {
+	// ShortCode is the short code that was visited
+	ShortCode string
+
+	// Variant is the name of the variant served, empty for URLs without variants
+	Variant string
+
+	// Country and Region locate the visitor by IP address as ISO codes, such
+	// as DE and BY, empty when unknown
+	Country string
+	Region  string
+
+	// Referrer is the host of the page linking to the short URL, empty for direct visits
+	Referrer string
+
+	// Browser and OS classify the User-Agent of the visitor, empty when unknown
+	Browser string
+	OS      string
+
+	// Language is the preferred language tag of the visitor, lowercased, empty when unknown
+	Language string
+
+	// Bot marks visits by crawlers, link previews and scripts rather than people
+	Bot bool
+
+	// IP and UserAgent identify the visitor for counting unique visitors. The
+	// IP address is stored in full, anonymized or not at all depending on the
+	// configuration, and both are erased after the configured retention period.
+	IP        string
+	UserAgent string
+
+	// DoNotTrack marks visits asking not to be tracked with the DNT or Sec-GPC
+	// header, which are counted without storing data identifying the visitor
+	DoNotTrack bool
+
+	// Visitor is a hash telling apart the visitors of the URL on the day of the
+	// click, which cannot be linked to the visitor or across days and URLs.
+	// Empty for bots and unknown visitors.
+	Visitor string
+
+	// ClickedAt is the time of the visit
+	ClickedAt time.Time
+}
+
+// ShortenRequest holds the parameters for creating a shortened URL
+type ShortenRequest struct {
+	// LongURL is the destination of the short URL, empty when Variants are given
+	LongURL string
+
+	// CustomCode is the requested short code, a code is generated when empty
+	CustomCode string
+
+	// ForceNew creates a new short URL even when deduplication would return an existing one
+	ForceNew bool
+
+	// StripTracking removes tracking parameters such as utm_source from the destination
+	StripTracking bool
+
+	// RedirectType is how the short URL redirects, empty uses the server default
+	RedirectType RedirectType
+
+	// PathPassthrough appends extra path segments of a visit to the destination path
+	PathPassthrough bool
+
+	// QueryPassthrough forwards query parameters of a visit to the destination
+	QueryPassthrough QueryPassthrough
+
+	// UTM holds campaign parameters to append to the destination
+	UTM UTM
+
+	// Variants are weighted destinations to distribute visits across instead of LongURL
+	Variants []Variant
+
+	// StickyVariants serves returning visitors the variant they were first served
+	StickyVariants bool
+
+	// Rules route visits matching their conditions to other destinations
+	Rules []Rule
+
+	// Schedule restricts when the short URL redirects and changes its destination over time
+	Schedule Schedule
+}
+
+// BlockedDomain is a destination domain blocked by an administrator
+type BlockedDomain struct {
+	Domain    string    `json:"domain"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // NewURL creates a new URL with default values
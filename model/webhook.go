@@ -0,0 +1,123 @@
+package model
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// WebhookEvent is a change of a link that webhooks can subscribe to
+type WebhookEvent string
+
+// Webhook events
+const (
+	// EventLinkCreated is sent when a short URL is created
+	EventLinkCreated WebhookEvent = "link.created"
+
+	// EventLinkDeleted is sent when a short URL is deleted
+	EventLinkDeleted WebhookEvent = "link.deleted"
+
+	// EventLinkClicked is sent when a short URL is visited by a person
+	EventLinkClicked WebhookEvent = "link.clicked"
+)
+
+// WebhookEvents lists the events a webhook can subscribe to
+var WebhookEvents = []WebhookEvent{EventLinkCreated, EventLinkDeleted, EventLinkClicked}
+
+// Valid reports whether e is a known event
+func (e WebhookEvent) Valid() bool {
+	return slices.Contains(WebhookEvents, e)
+}
+
+// Webhook is a subscription POSTing the events it subscribes to to a URL
+type Webhook struct {
+	ID     int64          `json:"id"`
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events"`
+
+	// Secret signs the payloads, it is only shown when the webhook is created
+	Secret string `json:"secret,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribes reports whether the webhook subscribes to event
+func (w *Webhook) Subscribes(event WebhookEvent) bool {
+	return slices.Contains(w.Events, event)
+}
+
+// WebhookPayload is the JSON body POSTed to webhooks
+type WebhookPayload struct {
+	Event WebhookEvent `json:"event"`
+	Time  time.Time    `json:"time"`
+
+	// URL is the created or deleted URL
+	URL *URL `json:"url,omitempty"`
+
+	// Click is the visit of a clicked URL
+	Click *WebhookClick `json:"click,omitempty"`
+}
+
+// WebhookClick describes a visit in webhook payloads, leaving out data
+// identifying the visitor
+type WebhookClick struct {
+	ShortCode string    `json:"short_code"`
+	Variant   string    `json:"variant,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	Referrer  string    `json:"referrer,omitempty"`
+	Browser   string    `json:"browser,omitempty"`
+	OS        string    `json:"os,omitempty"`
+	Language  string    `json:"language,omitempty"`
+	ClickedAt time.Time `json:"clicked_at"`
+}
+
+// WebhookDeliveryStatus is the state of the delivery of an event to a webhook
+type WebhookDeliveryStatus string
+
+// Webhook delivery states
+const (
+	// DeliveryPending is waiting for its first or next attempt
+	DeliveryPending WebhookDeliveryStatus = "pending"
+
+	// DeliveryDelivered was accepted by the webhook
+	DeliveryDelivered WebhookDeliveryStatus = "delivered"
+
+	// DeliveryFailed was given up after the last attempt failed
+	DeliveryFailed WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an event queued for delivery to a webhook
+type WebhookDelivery struct {
+	ID        int64                 `json:"id"`
+	WebhookID int64                 `json:"webhook_id"`
+	Event     WebhookEvent          `json:"event"`
+	Payload   json.RawMessage       `json:"payload"`
+	Status    WebhookDeliveryStatus `json:"status"`
+	CreatedAt time.Time             `json:"created_at"`
+
+	// NextAttemptAt is when the delivery is attempted next, nil once it is
+	// delivered or failed
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+
+	// Attempts lists the attempts so far, oldest first
+	Attempts []WebhookAttempt `json:"attempts"`
+
+	// URL and Secret of the webhook, set on deliveries claimed for sending
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhookAttempt is an attempt to deliver an event to a webhook
+type WebhookAttempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+
+	// DurationMS is how long the request took in milliseconds
+	DurationMS int64 `json:"duration_ms"`
+
+	// StatusCode is the HTTP status of the response, 0 when none was received
+	StatusCode int `json:"status_code,omitempty"`
+
+	// Error describes why the attempt failed, empty on success
+	Error string `json:"error,omitempty"`
+}
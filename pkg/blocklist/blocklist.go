@@ -0,0 +1,209 @@
+package blocklist
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/idna"
+)
+
+// Source identifies where a blocklist entry comes from
+type Source string
+
+// Entry sources
+const (
+	// SourceFile marks entries loaded from a blocklist file
+	SourceFile Source = "file"
+
+	// SourceAdmin marks entries added through the admin API
+	SourceAdmin Source = "admin"
+)
+
+// hostsAddresses lists the addresses hosts files use to sink blocked domains
+var hostsAddresses = map[string]bool{
+	"0.0.0.0":   true,
+	"127.0.0.1": true,
+	"::":        true,
+	"::1":       true,
+}
+
+// hostsNames lists names found in hosts files that are never blocked
+var hostsNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"0.0.0.0":               true,
+}
+
+// List holds blocked domains. A domain blocks its subdomains too. Entries from
+// files and entries added by an administrator are kept apart, so reloading the
+// files does not drop administrator entries.
+type List struct {
+	mu    sync.RWMutex
+	paths []string
+	files map[string]bool
+	admin map[string]bool
+}
+
+// New creates an empty list reading file entries from the given paths
+func New(paths ...string) *List {
+	return &List{
+		paths: paths,
+		files: make(map[string]bool),
+		admin: make(map[string]bool),
+	}
+}
+
+// Reload reads the blocklist files again, replacing all file entries. The
+// previous entries are kept when a file cannot be read.
+func (l *List) Reload() error {
+	files := make(map[string]bool)
+	for _, path := range l.paths {
+		if err := loadFile(path, files); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	l.files = files
+	l.mu.Unlock()
+	return nil
+}
+
+// Add blocks the given domains as administrator entries
+func (l *List) Add(domains ...string) error {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		d, err := Normalize(domain)
+		if err != nil {
+			return err
+		}
+		normalized = append(normalized, d)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, d := range normalized {
+		l.admin[d] = true
+	}
+	return nil
+}
+
+// Remove unblocks the given administrator entry, file entries cannot be removed
+func (l *List) Remove(domain string) {
+	d, err := Normalize(domain)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.admin, d)
+}
+
+// Len returns the number of entries from each source
+func (l *List) Len() map[Source]int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return map[Source]int{
+		SourceFile:  len(l.files),
+		SourceAdmin: len(l.admin),
+	}
+}
+
+// Blocked reports whether host or one of its parent domains is blocked and
+// returns the matching entry
+func (l *List) Blocked(host string) (string, bool) {
+	host, err := Normalize(host)
+	if err != nil {
+		return "", false
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if _, err := netip.ParseAddr(host); err == nil {
+		return host, l.files[host] || l.admin[host]
+	}
+
+	for domain := host; domain != ""; {
+		if l.files[domain] || l.admin[domain] {
+			return domain, true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+		domain = parent
+	}
+	return "", false
+}
+
+// Normalize returns the canonical form of a blocklist entry: lowercase
+// punycode without a trailing dot or leading wildcard
+func Normalize(domain string) (string, error) {
+	domain = strings.TrimSpace(domain)
+	domain = strings.TrimPrefix(domain, "*.")
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" {
+		return "", fmt.Errorf("domain is empty")
+	}
+
+	if addr, err := netip.ParseAddr(strings.Trim(domain, "[]")); err == nil {
+		return addr.Unmap().String(), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid domain '%s'", domain)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// loadFile adds the entries of a blocklist file to entries. Files contain one
+// domain per line or use the hosts file format ("0.0.0.0 example.com"), and
+// may contain comments starting with '#'.
+func loadFile(path string, entries map[string]bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open blocklist: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// Hosts files list the sink address first, followed by one or more names
+		if len(fields) > 1 && hostsAddresses[fields[0]] {
+			fields = fields[1:]
+		}
+
+		for _, field := range fields {
+			if hostsNames[strings.ToLower(field)] {
+				continue
+			}
+			domain, err := Normalize(field)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			entries[domain] = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read blocklist: %w", err)
+	}
+	return nil
+}
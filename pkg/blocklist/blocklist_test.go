@@ -0,0 +1,97 @@
+package blocklist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestBlocked(t *testing.T) {
+	dir := t.TempDir()
+	domains := writeFile(t, dir, "domains.txt", "# phishing\nphish.example\n*.Malware.Example.\n\nbücher.example # idn\n")
+	hosts := writeFile(t, dir, "hosts", "127.0.0.1 localhost\n0.0.0.0 ads.example tracker.example\n::1 ip6-localhost\n")
+
+	list := New(domains, hosts)
+	if err := list.Reload(); err != nil {
+		t.Fatalf("Failed to load blocklist: %v", err)
+	}
+
+	tests := []struct {
+		host    string
+		blocked bool
+	}{
+		{"phish.example", true},
+		{"login.phish.example", true},
+		{"PHISH.EXAMPLE", true},
+		{"notphish.example", false},
+		{"malware.example", true},
+		{"cdn.malware.example", true},
+		{"xn--bcher-kva.example", true},
+		{"ads.example", true},
+		{"tracker.example", true},
+		{"localhost", false},
+		{"example", false},
+		{"example.com", false},
+	}
+
+	for _, tt := range tests {
+		if _, blocked := list.Blocked(tt.host); blocked != tt.blocked {
+			t.Errorf("Blocked(%q) = %v, expected %v", tt.host, blocked, tt.blocked)
+		}
+	}
+
+	if entry, _ := list.Blocked("a.b.phish.example"); entry != "phish.example" {
+		t.Errorf("Expected matching entry 'phish.example', got '%s'", entry)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "domains.txt", "old.example\n")
+
+	list := New(path)
+	if err := list.Reload(); err != nil {
+		t.Fatalf("Failed to load blocklist: %v", err)
+	}
+	if err := list.Add("admin.example"); err != nil {
+		t.Fatalf("Failed to add domain: %v", err)
+	}
+
+	writeFile(t, dir, "domains.txt", "new.example\n")
+	if err := list.Reload(); err != nil {
+		t.Fatalf("Failed to reload blocklist: %v", err)
+	}
+
+	if _, blocked := list.Blocked("old.example"); blocked {
+		t.Errorf("Expected removed file entry to be unblocked")
+	}
+	if _, blocked := list.Blocked("new.example"); !blocked {
+		t.Errorf("Expected new file entry to be blocked")
+	}
+	if _, blocked := list.Blocked("admin.example"); !blocked {
+		t.Errorf("Expected admin entry to survive a reload")
+	}
+
+	// A broken file keeps the previous entries
+	writeFile(t, dir, "domains.txt", "bad domain!\n")
+	if err := list.Reload(); err == nil {
+		t.Errorf("Expected an error for an invalid entry")
+	}
+	if _, blocked := list.Blocked("new.example"); !blocked {
+		t.Errorf("Expected previous entries to be kept after a failed reload")
+	}
+
+	list.Remove("admin.example")
+	if _, blocked := list.Blocked("admin.example"); blocked {
+		t.Errorf("Expected removed admin entry to be unblocked")
+	}
+}
@@ -0,0 +1,88 @@
+// Package geoip resolves client IP addresses to their country and region.
+//
+// Lookups read a locally supplied database file in the MaxMind DB format, such
+// as GeoLite2-Country or GeoLite2-City, and never access the network.
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// Location is where an IP address is located
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, such as DE
+	Country string
+
+	// Region is the ISO 3166-2 code of the largest subdivision without the
+	// country prefix, such as BY, empty for country databases
+	Region string
+}
+
+// record holds the fields of a database record read for a location
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// DB is an opened location database. A nil DB locates nothing, so callers
+// need no checks when the feature is disabled.
+type DB struct {
+	reader *maxminddb.Reader
+}
+
+// Open opens the database file at path
+func Open(path string) (*DB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Close closes the database file
+func (db *DB) Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.reader.Close()
+}
+
+// Lookup returns the location of an IP address, or a zero Location when the
+// address is not in the database
+func (db *DB) Lookup(addr netip.Addr) Location {
+	if db == nil || !addr.IsValid() {
+		return Location{}
+	}
+
+	var rec record
+	if err := db.reader.Lookup(addr.Unmap()).Decode(&rec); err != nil {
+		return Location{}
+	}
+
+	loc := Location{Country: strings.ToUpper(rec.Country.ISOCode)}
+	if len(rec.Subdivisions) > 0 {
+		loc.Region = strings.ToUpper(rec.Subdivisions[0].ISOCode)
+	}
+	return loc
+}
+
+// LookupString returns the location of an IP address given as a string,
+// optionally with a port such as the remote address of an HTTP request
+func (db *DB) LookupString(s string) Location {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return db.Lookup(addrPort.Addr())
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return Location{}
+	}
+	return db.Lookup(addr)
+}
@@ -0,0 +1,153 @@
+package geoip
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testDatabase builds an IPv4 MaxMind DB with 24 bit records mapping networks to
+// encoded data records
+func testDatabase(t *testing.T, networks map[string][]byte) string {
+	t.Helper()
+
+	type node struct {
+		child [2]int // index of the child node, 0 for none
+		data  [2]int // offset of the data record plus one, 0 for none
+	}
+	nodes := []node{{}}
+	var data []byte
+	for network, rec := range networks {
+		prefix := netip.MustParsePrefix(network)
+		addr := prefix.Addr().As4()
+		n := 0
+		for i := range prefix.Bits() {
+			bit := int(addr[i/8]>>(7-i%8)) & 1
+			if i == prefix.Bits()-1 {
+				nodes[n].data[bit] = len(data) + 1
+				break
+			}
+			if nodes[n].child[bit] == 0 {
+				nodes = append(nodes, node{})
+				nodes[n].child[bit] = len(nodes) - 1
+			}
+			n = nodes[n].child[bit]
+		}
+		data = append(data, rec...)
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		for bit := range 2 {
+			value := len(nodes)
+			switch {
+			case n.child[bit] != 0:
+				value = n.child[bit]
+			case n.data[bit] != 0:
+				value = len(nodes) + 16 + n.data[bit] - 1
+			}
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	buf.Write(encodeMap(
+		"node_count", encodeUint(len(nodes)),
+		"record_size", encodeUint(24),
+		"ip_version", encodeUint(4),
+		"binary_format_major_version", encodeUint(2),
+		"binary_format_minor_version", encodeUint(0),
+		"database_type", encodeString("Test"),
+	))
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write database: %v", err)
+	}
+	return path
+}
+
+func encodeString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func encodeUint(n int) []byte {
+	return []byte{6<<5 | 4, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+}
+
+// encodeMap encodes alternating string keys and encoded values
+func encodeMap(pairs ...any) []byte {
+	b := []byte{7<<5 | byte(len(pairs)/2)}
+	for i := 0; i < len(pairs); i += 2 {
+		b = append(b, encodeString(pairs[i].(string))...)
+		b = append(b, pairs[i+1].([]byte)...)
+	}
+	return b
+}
+
+func encodeArray(values ...[]byte) []byte {
+	b := []byte{byte(len(values)), 11 - 7}
+	for _, v := range values {
+		b = append(b, v...)
+	}
+	return b
+}
+
+func TestLookup(t *testing.T) {
+	path := testDatabase(t, map[string][]byte{
+		"81.0.0.0/8": encodeMap(
+			"country", encodeMap("iso_code", encodeString("de")),
+			"subdivisions", encodeArray(encodeMap("iso_code", encodeString("BY"))),
+		),
+		"2.0.0.0/8": encodeMap(
+			"country", encodeMap("iso_code", encodeString("US")),
+		),
+	})
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name string
+		addr string
+		want Location
+	}{
+		{"CountryAndRegion", "81.2.3.4", Location{Country: "DE", Region: "BY"}},
+		{"Country", "2.1.1.1", Location{Country: "US"}},
+		{"WithPort", "2.1.1.1:4321", Location{Country: "US"}},
+		{"IPv4Mapped", "::ffff:81.2.3.4", Location{Country: "DE", Region: "BY"}},
+		{"NotFound", "10.0.0.1", Location{}},
+		{"IPv6", "2001:db8::1", Location{}},
+		{"Invalid", "not an address", Location{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := db.LookupString(tt.addr); got != tt.want {
+				t.Errorf("LookupString(%q) = %+v, want %+v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilDB(t *testing.T) {
+	var db *DB
+	if got := db.LookupString("81.2.3.4"); got != (Location{}) {
+		t.Errorf("Expected no location without a database, got %+v", got)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("Expected closing a nil database to succeed, got %v", err)
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("Expected an error opening a missing file")
+	}
+}
@@ -0,0 +1,90 @@
+// Package jobs runs background maintenance jobs at fixed intervals.
+//
+// Every job runs once when the runner starts and then after each interval.
+// Runs of the same job never overlap, and the start, end and failures of every
+// run are logged together with the progress the job reports.
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Job is a task run periodically in the background
+type Job struct {
+	// Name identifies the job in logs
+	Name string
+
+	// Interval is the time between the end of a run and the start of the next
+	Interval time.Duration
+
+	// Run performs the job, logging its progress to log
+	Run func(ctx context.Context, log *slog.Logger) error
+}
+
+// Runner runs jobs in the background until it is stopped
+type Runner struct {
+	jobs   []Job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRunner creates a runner of the given jobs
+func NewRunner(jobs ...Job) *Runner {
+	return &Runner{jobs: jobs}
+}
+
+// Add adds a job, jobs added after Start are not run
+func (r *Runner) Add(job Job) {
+	r.jobs = append(r.jobs, job)
+}
+
+// Start starts running the jobs, each in its own goroutine
+func (r *Runner) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	for _, job := range r.jobs {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			r.loop(ctx, job)
+		}()
+	}
+}
+
+// Stop cancels running jobs and waits for them to return
+func (r *Runner) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+// loop runs a job until ctx is canceled
+func (r *Runner) loop(ctx context.Context, job Job) {
+	for {
+		Run(ctx, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(job.Interval):
+		}
+	}
+}
+
+// Run runs a job once, logging its start and end
+func Run(ctx context.Context, job Job) error {
+	log := slog.Default().With("job", job.Name)
+	log.InfoContext(ctx, "Starting job")
+
+	start := time.Now()
+	err := job.Run(ctx, log)
+	if err != nil {
+		log.ErrorContext(ctx, "Job failed", "duration", time.Since(start), "error", err)
+		return err
+	}
+	log.InfoContext(ctx, "Finished job", "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,73 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunner(t *testing.T) {
+	var runs atomic.Int32
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+
+	runner := NewRunner(Job{
+		Name:     "count",
+		Interval: time.Millisecond,
+		Run: func(ctx context.Context, log *slog.Logger) error {
+			if runs.Add(1) == 3 {
+				close(started)
+			}
+			return nil
+		},
+	})
+	runner.Add(Job{
+		Name:     "wait",
+		Interval: time.Hour,
+		Run: func(ctx context.Context, log *slog.Logger) error {
+			<-ctx.Done()
+			close(canceled)
+			return ctx.Err()
+		},
+	})
+	runner.Start(context.Background())
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the job to run repeatedly")
+	}
+
+	// Stopping cancels running jobs and waits for them
+	runner.Stop()
+	select {
+	case <-canceled:
+	default:
+		t.Error("Expected the running job to be canceled before Stop returns")
+	}
+	n := runs.Load()
+	time.Sleep(10 * time.Millisecond)
+	if runs.Load() != n {
+		t.Error("Expected no runs after Stop")
+	}
+}
+
+func TestRun(t *testing.T) {
+	failure := errors.New("failure")
+	err := Run(context.Background(), Job{
+		Name: "fail",
+		Run: func(ctx context.Context, log *slog.Logger) error {
+			return failure
+		},
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the error of the job, got %v", err)
+	}
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	NewRunner().Stop()
+}
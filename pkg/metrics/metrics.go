@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "url_shortener"
+
+var (
+	// HTTPRequests counts handled HTTP requests by route, method and status code
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPRequestDuration observes HTTP request latencies by route
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latencies in seconds by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// RedirectDuration observes the latency of short code redirects by status code
+	RedirectDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redirect_duration_seconds",
+		Help:      "Latency of short code redirects in seconds by status code.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"status"})
+
+	// URLsShortened counts successfully created short URLs
+	URLsShortened = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "urls_shortened_total",
+		Help:      "Total number of short URLs created.",
+	})
+
+	// URLsDeleted counts deleted short URLs
+	URLsDeleted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "urls_deleted_total",
+		Help:      "Total number of short URLs deleted.",
+	})
+
+	// CacheLookups counts URL cache lookups by result (hit or miss)
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Total number of URL cache lookups by result.",
+	}, []string{"result"})
+
+	// DBQueryDuration observes database query durations by operation
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query durations in seconds by operation.",
+		Buckets:   []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"operation"})
+
+	// BlockedDestinations counts blocked destinations by stage (create or redirect)
+	BlockedDestinations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocked_destinations_total",
+		Help:      "Total number of blocked destinations by stage.",
+	}, []string{"stage"})
+
+	// RateLimited counts requests rejected by the rate limiter by class
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Total number of requests rejected by the rate limiter by class.",
+	}, []string{"class"})
+
+	// ClickQueueDepth reports the number of clicks waiting to be recorded
+	ClickQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "click_queue_depth",
+		Help:      "Number of clicks waiting to be recorded.",
+	})
+
+	// ClicksDropped counts clicks dropped because the click queue was full
+	ClicksDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clicks_dropped_total",
+		Help:      "Total number of clicks dropped because the click queue was full.",
+	})
+
+	// WebhookAttempts counts attempts to deliver webhook events by result (delivered, retry or failed)
+	WebhookAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_attempts_total",
+		Help:      "Total number of webhook delivery attempts by result.",
+	}, []string{"result"})
+)
+
+// Handler returns the HTTP handler serving metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveQuery records the duration of a database operation started at start
+func ObserveQuery(operation string, start time.Time) {
+	DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
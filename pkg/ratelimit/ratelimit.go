@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket refilling at Rate tokens per second up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the limit restricts anything
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// String returns the limit in the format accepted by ParseLimit
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "0"
+	}
+	period := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+	return fmt.Sprintf("%d/%s", l.Burst, period)
+}
+
+// ParseLimit parses a limit of the form "count/period", such as "10/m",
+// "100/s" or "500/1h". The bucket holds count tokens and refills completely
+// over period. An empty string or "0" disables the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+
+	countStr, periodStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit '%s', expected count/period such as 10/m", s)
+	}
+
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit count '%s'", countStr)
+	}
+	if count == 0 {
+		return Limit{}, nil
+	}
+
+	// Allow a bare unit such as "m" for one minute
+	if periodStr != "" && (periodStr[0] < '0' || periodStr[0] > '9') {
+		periodStr = "1" + periodStr
+	}
+	period, err := time.ParseDuration(periodStr)
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit period '%s'", periodStr)
+	}
+
+	return Limit{Rate: float64(count) / period.Seconds(), Burst: count}, nil
+}
+
+// Result describes the outcome of taking a token
+type Result struct {
+	// Allowed reports whether a token was available
+	Allowed bool
+
+	// Remaining is the number of whole tokens left in the bucket
+	Remaining int
+
+	// RetryAfter is the time until the next token is available, zero when allowed
+	RetryAfter time.Duration
+
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps token buckets. Stores shared by several instances let them
+// enforce a common limit.
+type Store interface {
+	// Take takes a token from the bucket of key at time now
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// NewResult computes the result of a take leaving tokens in the bucket
+func NewResult(limit Limit, allowed bool, tokens float64) Result {
+	result := Result{
+		Allowed:   allowed,
+		Remaining: max(int(math.Floor(tokens)), 0),
+		Reset:     seconds((float64(limit.Burst) - tokens) / limit.Rate),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	return result
+}
+
+// seconds converts a number of seconds to a duration
+func seconds(s float64) time.Duration {
+	if s <= 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// Limiter applies limits to classes of requests
+type Limiter struct {
+	store  Store
+	limits map[string]Limit
+}
+
+// NewLimiter creates a limiter keeping its buckets in store
+func NewLimiter(store Store, limits map[string]Limit) *Limiter {
+	return &Limiter{store: store, limits: limits}
+}
+
+// Limit returns the limit of a class of requests
+func (l *Limiter) Limit(class string) Limit {
+	return l.limits[class]
+}
+
+// Allow takes a token for key from the bucket of class. Classes without an
+// enabled limit are always allowed.
+func (l *Limiter) Allow(ctx context.Context, class, key string) (Result, error) {
+	limit := l.limits[class]
+	if !limit.Enabled() {
+		return Result{Allowed: true}, nil
+	}
+	return l.store.Take(ctx, class+":"+key, limit, time.Now())
+}
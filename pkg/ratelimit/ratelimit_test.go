@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in    string
+		rate  float64
+		burst int
+		err   bool
+	}{
+		{"10/m", 10.0 / 60, 10, false},
+		{"100/s", 100, 100, false},
+		{"30/30s", 1, 30, false},
+		{"24/1h", 24.0 / 3600, 24, false},
+		{"0", 0, 0, false},
+		{"", 0, 0, false},
+		{"0/m", 0, 0, false},
+		{"10", 0, 0, true},
+		{"x/m", 0, 0, true},
+		{"10/fortnight", 0, 0, true},
+		{"-1/m", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		limit, err := ParseLimit(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseLimit(%q) expected an error", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLimit(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if limit.Burst != tt.burst || limit.Rate < tt.rate*0.999 || limit.Rate > tt.rate*1.001 {
+			t.Errorf("ParseLimit(%q) = %+v, expected rate %v and burst %d", tt.in, limit, tt.rate, tt.burst)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limit := Limit{Rate: 1, Burst: 3}
+	now := time.Unix(1000, 0)
+
+	// The bucket starts full
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(ctx, "client", limit, now)
+		if err != nil {
+			t.Fatalf("Failed to take token: %v", err)
+		}
+		if !result.Allowed || result.Remaining != i {
+			t.Errorf("Expected allowed with %d remaining, got %+v", i, result)
+		}
+	}
+
+	result, _ := store.Take(ctx, "client", limit, now)
+	if result.Allowed {
+		t.Errorf("Expected an empty bucket to reject")
+	}
+	if result.RetryAfter != time.Second {
+		t.Errorf("Expected retry after 1s, got %v", result.RetryAfter)
+	}
+	if result.Reset != 3*time.Second {
+		t.Errorf("Expected reset after 3s, got %v", result.Reset)
+	}
+
+	// Other clients have their own bucket
+	if result, _ := store.Take(ctx, "other", limit, now); !result.Allowed {
+		t.Errorf("Expected another client to be allowed")
+	}
+
+	// Tokens refill over time
+	if result, _ := store.Take(ctx, "client", limit, now.Add(1500*time.Millisecond)); !result.Allowed {
+		t.Errorf("Expected a refilled token to be allowed")
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), map[string]Limit{
+		"create": {Rate: 1, Burst: 1},
+	})
+
+	if result, _ := limiter.Allow(ctx, "create", "client"); !result.Allowed {
+		t.Errorf("Expected the first request to be allowed")
+	}
+	if result, _ := limiter.Allow(ctx, "create", "client"); result.Allowed {
+		t.Errorf("Expected the second request to be rejected")
+	}
+
+	// Classes are limited separately and classes without a limit are not limited
+	for i := 0; i < 5; i++ {
+		if result, _ := limiter.Allow(ctx, "read", "client"); !result.Allowed {
+			t.Errorf("Expected unlimited class to be allowed")
+		}
+	}
+}
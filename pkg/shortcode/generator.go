@@ -0,0 +1,265 @@
+package shortcode
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Alphabets used by the generators
+const (
+	// Base62Alphabet contains all ASCII letters and digits
+	Base62Alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// UnambiguousAlphabet omits characters that are easily confused, such as 0/O/o and 1/l/I
+	UnambiguousAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// Generator creates candidate short codes
+type Generator interface {
+	// Generate returns a new candidate code. Length is the desired number of
+	// characters, generators may return longer or shorter codes when their
+	// scheme requires it.
+	Generate(ctx context.Context, length int) (string, error)
+}
+
+// SequenceFunc returns the next value of a persistent counter
+type SequenceFunc func(ctx context.Context) (int64, error)
+
+// GeneratorConfig holds the settings used by NewGenerator
+type GeneratorConfig struct {
+	// Alphabet is used by the unambiguous generator, empty means UnambiguousAlphabet
+	Alphabet string
+
+	// Salt obfuscates the codes of the hashids generator
+	Salt string
+
+	// Next provides the counter used by the sequential and hashids generators
+	Next SequenceFunc
+}
+
+// GeneratorNames lists the names accepted by NewGenerator
+var GeneratorNames = []string{"random", "sequential", "hashids", "pronounceable", "unambiguous"}
+
+// NewGenerator creates the generator with the given name
+func NewGenerator(name string, cfg GeneratorConfig) (Generator, error) {
+	switch name {
+	case "random":
+		return NewRandomGenerator(Base62Alphabet)
+	case "unambiguous":
+		alphabet := cfg.Alphabet
+		if alphabet == "" {
+			alphabet = UnambiguousAlphabet
+		}
+		return NewRandomGenerator(alphabet)
+	case "sequential":
+		if cfg.Next == nil {
+			return nil, fmt.Errorf("sequential generator requires a sequence")
+		}
+		return &SequentialGenerator{next: cfg.Next}, nil
+	case "hashids":
+		if cfg.Next == nil {
+			return nil, fmt.Errorf("hashids generator requires a sequence")
+		}
+		return NewObfuscatedGenerator(cfg.Next, cfg.Salt), nil
+	case "pronounceable":
+		return &PronounceableGenerator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown code generator '%s', expected one of %s", name, strings.Join(GeneratorNames, ", "))
+	}
+}
+
+// RandomGenerator creates uniformly random codes from an alphabet
+type RandomGenerator struct {
+	alphabet string
+}
+
+// NewRandomGenerator creates a random generator using the given alphabet
+func NewRandomGenerator(alphabet string) (*RandomGenerator, error) {
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	return &RandomGenerator{alphabet: alphabet}, nil
+}
+
+// Generate returns a random code of the given length
+func (g *RandomGenerator) Generate(ctx context.Context, length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := randomIndex(len(g.alphabet))
+		if err != nil {
+			return "", err
+		}
+		code[i] = g.alphabet[n]
+	}
+	return string(code), nil
+}
+
+// SequentialGenerator encodes a persistent counter in base62, producing the
+// shortest possible codes. The requested length is ignored.
+type SequentialGenerator struct {
+	next SequenceFunc
+}
+
+// Generate returns the base62 encoding of the next counter value
+func (g *SequentialGenerator) Generate(ctx context.Context, length int) (string, error) {
+	id, err := g.next(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get next sequence value: %w", err)
+	}
+	return encode(uint64(id), Base62Alphabet, 1), nil
+}
+
+// ObfuscatedGenerator creates Hashids-style codes: a persistent counter is
+// mapped through a salted bijection so consecutive IDs give unrelated codes of
+// a fixed length, without ever colliding with each other
+type ObfuscatedGenerator struct {
+	next       SequenceFunc
+	alphabet   string
+	multiplier uint64
+	offset     uint64
+}
+
+// NewObfuscatedGenerator creates an obfuscated generator using the given salt
+func NewObfuscatedGenerator(next SequenceFunc, salt string) *ObfuscatedGenerator {
+	sum := sha256.Sum256([]byte(salt))
+	base := uint64(len(Base62Alphabet))
+
+	// The multiplier must be coprime with the alphabet size for the mapping to be a bijection
+	multiplier := binary.BigEndian.Uint64(sum[0:8])>>32 | 1
+	for gcd(multiplier, base) != 1 {
+		multiplier += 2
+	}
+
+	return &ObfuscatedGenerator{
+		next:       next,
+		alphabet:   shuffle(Base62Alphabet, sum[:]),
+		multiplier: multiplier,
+		offset:     binary.BigEndian.Uint64(sum[8:16]),
+	}
+}
+
+// Generate returns the obfuscated encoding of the next counter value. Codes
+// grow beyond length once the counter no longer fits.
+func (g *ObfuscatedGenerator) Generate(ctx context.Context, length int) (string, error) {
+	id, err := g.next(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get next sequence value: %w", err)
+	}
+
+	base := uint64(len(g.alphabet))
+	space := uint64(1)
+	for i := 0; i < length || space <= uint64(id); i++ {
+		if space > (1<<63)/base {
+			break
+		}
+		space *= base
+	}
+	digits := 0
+	for n := space; n > 1; n /= base {
+		digits++
+	}
+
+	// (id * multiplier + offset) mod space is a bijection on [0, space)
+	hi, lo := bits.Mul64(uint64(id)%space, g.multiplier%space)
+	product := bits.Rem64(hi, lo, space)
+	value := (product + g.offset%space) % space
+
+	return encode(value, g.alphabet, digits), nil
+}
+
+// PronounceableGenerator creates codes of alternating consonants and vowels, such as "kotabe"
+type PronounceableGenerator struct{}
+
+// Generate returns a pronounceable code of the given length
+func (g *PronounceableGenerator) Generate(ctx context.Context, length int) (string, error) {
+	const consonants = "bcdfghjkmnprstvz"
+	const vowels = "aeiou"
+
+	code := make([]byte, length)
+	for i := range code {
+		set := consonants
+		if i%2 == 1 {
+			set = vowels
+		}
+		n, err := randomIndex(len(set))
+		if err != nil {
+			return "", err
+		}
+		code[i] = set[n]
+	}
+	return string(code), nil
+}
+
+// randomIndex returns a uniformly random number in [0, n) for n <= 256.
+// Bytes that would bias the result towards low values are rejected.
+func randomIndex(n int) (int, error) {
+	limit := 256 - 256%n
+	var b [1]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		if int(b[0]) < limit {
+			return int(b[0]) % n, nil
+		}
+	}
+}
+
+// encode writes value in the given alphabet, left padded to at least width digits
+func encode(value uint64, alphabet string, width int) string {
+	base := uint64(len(alphabet))
+	var digits []byte
+	for value > 0 || len(digits) < width {
+		digits = append(digits, alphabet[value%base])
+		value /= base
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits)
+}
+
+// shuffle deterministically permutes an alphabet using the given key
+func shuffle(alphabet string, key []byte) string {
+	chars := []byte(alphabet)
+	state := sha256.Sum256(key)
+	for i := len(chars) - 1; i > 0; i-- {
+		state = sha256.Sum256(state[:])
+		j := int(binary.BigEndian.Uint64(state[:8]) % uint64(i+1))
+		chars[i], chars[j] = chars[j], chars[i]
+	}
+	return string(chars)
+}
+
+// gcd returns the greatest common divisor of a and b
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// validateAlphabet checks that an alphabet is usable for short codes
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return fmt.Errorf("alphabet must contain between 2 and 256 characters")
+	}
+
+	seen := make(map[rune]bool)
+	for _, c := range alphabet {
+		if !isAllowedChar(c) {
+			return fmt.Errorf("alphabet may only contain letters, digits, '-' and '_'")
+		}
+		if seen[c] {
+			return fmt.Errorf("alphabet contains duplicate character '%c'", c)
+		}
+		seen[c] = true
+	}
+
+	return nil
+}
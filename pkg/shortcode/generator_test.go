@@ -0,0 +1,173 @@
+package shortcode
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// counter returns a sequence function counting up from 1
+func counter() SequenceFunc {
+	var n int64
+	return func(ctx context.Context) (int64, error) {
+		n++
+		return n, nil
+	}
+}
+
+func TestRandomGenerator(t *testing.T) {
+	ctx := context.Background()
+
+	for _, name := range []string{"random", "unambiguous"} {
+		generator, err := NewGenerator(name, GeneratorConfig{})
+		if err != nil {
+			t.Fatalf("Failed to create %s generator: %v", name, err)
+		}
+
+		alphabet := Base62Alphabet
+		if name == "unambiguous" {
+			alphabet = UnambiguousAlphabet
+		}
+
+		for i := 0; i < 100; i++ {
+			code, err := generator.Generate(ctx, 8)
+			if err != nil {
+				t.Fatalf("Failed to generate code: %v", err)
+			}
+			if len(code) != 8 {
+				t.Errorf("Expected code of length 8, got '%s'", code)
+			}
+			for _, c := range code {
+				if !strings.ContainsRune(alphabet, c) {
+					t.Errorf("Expected %s code to only use its alphabet, got '%s'", name, code)
+				}
+			}
+		}
+	}
+
+	if _, err := NewRandomGenerator("aab"); err == nil {
+		t.Errorf("Expected error for alphabet with duplicate characters")
+	}
+	if _, err := NewRandomGenerator("a/b"); err == nil {
+		t.Errorf("Expected error for alphabet with disallowed characters")
+	}
+}
+
+func TestSequentialGenerator(t *testing.T) {
+	ctx := context.Background()
+	generator, err := NewGenerator("sequential", GeneratorConfig{Next: counter()})
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	var codes []string
+	for i := 0; i < 63; i++ {
+		code, err := generator.Generate(ctx, 6)
+		if err != nil {
+			t.Fatalf("Failed to generate code: %v", err)
+		}
+		codes = append(codes, code)
+	}
+
+	if codes[0] != "b" || codes[60] != "9" || codes[61] != "ba" || codes[62] != "bb" {
+		t.Errorf("Expected base62 counter codes, got %v", codes)
+	}
+}
+
+func TestObfuscatedGenerator(t *testing.T) {
+	ctx := context.Background()
+	generator, err := NewGenerator("hashids", GeneratorConfig{Next: counter(), Salt: "pepper"})
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		code, err := generator.Generate(ctx, 5)
+		if err != nil {
+			t.Fatalf("Failed to generate code: %v", err)
+		}
+		if len(code) != 5 {
+			t.Errorf("Expected code of length 5, got '%s'", code)
+		}
+		if seen[code] {
+			t.Fatalf("Expected unique codes, got duplicate '%s'", code)
+		}
+		seen[code] = true
+	}
+
+	// The same salt gives the same codes, a different salt different ones
+	a := NewObfuscatedGenerator(counter(), "pepper")
+	b := NewObfuscatedGenerator(counter(), "pepper")
+	c := NewObfuscatedGenerator(counter(), "salt")
+	codeA, _ := a.Generate(ctx, 5)
+	codeB, _ := b.Generate(ctx, 5)
+	codeC, _ := c.Generate(ctx, 5)
+	if codeA != codeB {
+		t.Errorf("Expected equal codes for equal salts, got '%s' and '%s'", codeA, codeB)
+	}
+	if codeA == codeC {
+		t.Errorf("Expected different codes for different salts, got '%s'", codeA)
+	}
+}
+
+func TestPronounceableGenerator(t *testing.T) {
+	generator := &PronounceableGenerator{}
+	code, err := generator.Generate(context.Background(), 6)
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+	if len(code) != 6 {
+		t.Fatalf("Expected code of length 6, got '%s'", code)
+	}
+	for i, c := range code {
+		if isVowel := strings.ContainsRune("aeiou", c); isVowel != (i%2 == 1) {
+			t.Errorf("Expected alternating consonants and vowels, got '%s'", code)
+			break
+		}
+	}
+}
+
+func TestNewGeneratorUnknown(t *testing.T) {
+	if _, err := NewGenerator("nope", GeneratorConfig{}); err == nil {
+		t.Errorf("Expected error for unknown generator")
+	}
+	if _, err := NewGenerator("sequential", GeneratorConfig{}); err == nil {
+		t.Errorf("Expected error for sequential generator without sequence")
+	}
+}
+
+func TestLengthTuner(t *testing.T) {
+	tuner := NewLengthTuner(6, 8)
+
+	// Occasional collisions keep the length
+	for i := 0; i < tunerWindow; i++ {
+		tuner.Record(i%10 == 0)
+	}
+	if tuner.Length() != 6 {
+		t.Errorf("Expected length 6 at low collision rate, got %d", tuner.Length())
+	}
+
+	// A high collision rate grows the length
+	for i := 0; i < tunerWindow; i++ {
+		tuner.Record(i%2 == 0)
+	}
+	if tuner.Length() != 7 {
+		t.Errorf("Expected length 7 at high collision rate, got %d", tuner.Length())
+	}
+
+	// Consecutive collisions grow the length immediately, up to the maximum
+	for i := 0; i < 3*tunerStreak; i++ {
+		tuner.Record(true)
+	}
+	if tuner.Length() != 8 {
+		t.Errorf("Expected length capped at 8, got %d", tuner.Length())
+	}
+
+	// Lengths below 1 start at 1
+	for _, length := range []int{0, -1} {
+		if got := NewLengthTuner(length, 8).Length(); got != 1 {
+			t.Errorf("Expected length %d to start at 1, got %d", length, got)
+		}
+	}
+}
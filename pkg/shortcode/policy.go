@@ -0,0 +1,134 @@
+package shortcode
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// Default length limits for custom short codes
+const (
+	DefaultMinLength = 3
+	DefaultMaxLength = 32
+)
+
+// Policy decides which short codes may be used
+type Policy struct {
+	mu        sync.RWMutex
+	minLength int
+	maxLength int
+	reserved  map[string]bool
+	blocked   []string
+}
+
+// NewPolicy creates a policy accepting codes between minLength and maxLength characters
+func NewPolicy(minLength, maxLength int) *Policy {
+	return &Policy{
+		minLength: minLength,
+		maxLength: maxLength,
+		reserved:  make(map[string]bool),
+	}
+}
+
+// Reserve prevents the given codes from being used, ignoring case
+func (p *Policy) Reserve(codes ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, code := range codes {
+		p.reserved[strings.ToLower(code)] = true
+	}
+}
+
+// Block prevents codes containing any of the given words from being used, ignoring case
+func (p *Policy) Block(words ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			p.blocked = append(p.blocked, word)
+		}
+	}
+}
+
+// Validate checks a custom code against the policy
+func (p *Policy) Validate(code string) error {
+	length := len(code)
+	if length < p.minLength || length > p.maxLength {
+		return &model.ErrInvalidShortCode{
+			Code:   code,
+			Reason: fmt.Sprintf("must be between %d and %d characters long", p.minLength, p.maxLength),
+		}
+	}
+
+	for _, c := range code {
+		if !isAllowedChar(c) {
+			return &model.ErrInvalidShortCode{
+				Code:   code,
+				Reason: "may only contain letters, digits, '-' and '_'",
+			}
+		}
+	}
+
+	return p.check(code)
+}
+
+// Allowed reports whether a generated code is neither reserved nor blocked
+func (p *Policy) Allowed(code string) bool {
+	return p.check(code) == nil
+}
+
+// check rejects reserved codes and codes containing blocked words
+func (p *Policy) check(code string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	lower := strings.ToLower(code)
+	if p.reserved[lower] {
+		return &model.ErrReservedCode{Code: code}
+	}
+
+	for _, word := range p.blocked {
+		if strings.Contains(lower, word) {
+			return &model.ErrInvalidShortCode{Code: code, Reason: "contains a blocked word"}
+		}
+	}
+
+	return nil
+}
+
+// isAllowedChar reports whether c may appear in a short code
+func isAllowedChar(c rune) bool {
+	return (c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') ||
+		c == '-' || c == '_'
+}
+
+// LoadWords reads a word list with one word per line, skipping blank lines and '#' comments
+func LoadWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open word list: %w", err)
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read word list: %w", err)
+	}
+
+	return words, nil
+}
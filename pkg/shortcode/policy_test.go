@@ -0,0 +1,84 @@
+package shortcode
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	policy := NewPolicy(3, 12)
+	policy.Reserve("urls", "api")
+	policy.Block("spam")
+
+	tests := []struct {
+		code     string
+		valid    bool
+		reserved bool
+	}{
+		{"my-link", true, false},
+		{"My_Link_42", true, false},
+		{"ab", false, false},
+		{"thirteen-char", false, false},
+		{"with space", false, false},
+		{"a/b/c", false, false},
+		{"émoji", false, false},
+		{"urls", false, true},
+		{"API", false, true},
+		{"nospamhere", false, false},
+	}
+
+	for _, tt := range tests {
+		err := policy.Validate(tt.code)
+		if tt.valid {
+			if err != nil {
+				t.Errorf("Expected '%s' to be valid, got %v", tt.code, err)
+			}
+			continue
+		}
+
+		var reserved *model.ErrReservedCode
+		var invalid *model.ErrInvalidShortCode
+		switch {
+		case tt.reserved && !errors.As(err, &reserved):
+			t.Errorf("Expected '%s' to be reserved, got %v", tt.code, err)
+		case !tt.reserved && !errors.As(err, &invalid):
+			t.Errorf("Expected '%s' to be invalid, got %v", tt.code, err)
+		}
+	}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	policy := NewPolicy(3, 12)
+	policy.Reserve("readyz")
+	policy.Block("spam")
+
+	if policy.Allowed("readyz") {
+		t.Errorf("Expected reserved code not to be allowed")
+	}
+	if policy.Allowed("xSPAMx") {
+		t.Errorf("Expected code with blocked word not to be allowed")
+	}
+	if !policy.Allowed("a1b2c3") {
+		t.Errorf("Expected code 'a1b2c3' to be allowed")
+	}
+}
+
+func TestLoadWords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	content := "# offensive words\nspam\n\n  scam  \n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write word list: %v", err)
+	}
+
+	words, err := LoadWords(path)
+	if err != nil {
+		t.Fatalf("Failed to load words: %v", err)
+	}
+	if len(words) != 2 || words[0] != "spam" || words[1] != "scam" {
+		t.Errorf("Expected [spam scam], got %v", words)
+	}
+}
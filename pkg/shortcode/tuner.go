@@ -0,0 +1,83 @@
+package shortcode
+
+import "sync"
+
+// Collision tuning parameters
+const (
+	// tunerWindow is the number of attempts over which the collision rate is measured
+	tunerWindow = 50
+
+	// tunerThreshold is the collision rate above which the length grows
+	tunerThreshold = 0.2
+
+	// tunerStreak is the number of consecutive collisions that grow the length immediately
+	tunerStreak = 3
+)
+
+// LengthTuner tracks collisions of generated codes and grows the code length
+// when the collision rate rises, keeping generation fast as the keyspace fills
+type LengthTuner struct {
+	mu         sync.Mutex
+	length     int
+	maxLength  int
+	attempts   int
+	collisions int
+	streak     int
+}
+
+// NewLengthTuner creates a tuner starting at length and never exceeding
+// maxLength. Lengths below 1 start at 1.
+func NewLengthTuner(length, maxLength int) *LengthTuner {
+	length = max(length, 1)
+	if maxLength < length {
+		maxLength = length
+	}
+	return &LengthTuner{length: length, maxLength: maxLength}
+}
+
+// Length returns the current code length
+func (t *LengthTuner) Length() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.length
+}
+
+// Record registers whether a generated code collided with an existing one
+func (t *LengthTuner) Record(collided bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.attempts++
+	if collided {
+		t.collisions++
+		t.streak++
+	} else {
+		t.streak = 0
+	}
+
+	switch {
+	case t.streak >= tunerStreak:
+		t.grow()
+	case t.attempts >= tunerWindow:
+		if float64(t.collisions)/float64(t.attempts) > tunerThreshold {
+			t.grow()
+		} else {
+			t.reset()
+		}
+	}
+}
+
+// grow increases the length by one and starts a new measurement window
+func (t *LengthTuner) grow() {
+	if t.length < t.maxLength {
+		t.length++
+	}
+	t.reset()
+}
+
+// reset starts a new measurement window
+func (t *LengthTuner) reset() {
+	t.attempts = 0
+	t.collisions = 0
+	t.streak = 0
+}
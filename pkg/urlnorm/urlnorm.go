@@ -0,0 +1,403 @@
+package urlnorm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"golang.org/x/net/idna"
+)
+
+// MaxLength is the maximum length of a destination URL
+const MaxLength = 2048
+
+// DefaultSchemes lists the schemes accepted when no schemes are configured
+var DefaultSchemes = []string{"http", "https"}
+
+// trackingParams lists query parameters that only identify the campaign or
+// click a link was shared from
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"gbraid":  true,
+	"wbraid":  true,
+	"msclkid": true,
+	"yclid":   true,
+	"twclid":  true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+	"_gl":     true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+}
+
+// Resolver looks up the addresses of a host name, it is implemented by *net.Resolver
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// Config holds the settings of a Normalizer
+type Config struct {
+	// Schemes lists the accepted schemes, empty means DefaultSchemes
+	Schemes []string
+
+	// AllowPrivate accepts loopback, private and link-local targets such as localhost
+	AllowPrivate bool
+
+	// StripTracking always removes tracking parameters, not only when requested
+	StripTracking bool
+
+	// Resolver resolves host names to reject names pointing at private
+	// addresses, nil only checks IP literals and local names
+	Resolver Resolver
+}
+
+// Normalizer validates destination URLs and rewrites them to a canonical form
+type Normalizer struct {
+	schemes       []string
+	allowPrivate  bool
+	stripTracking bool
+	resolver      Resolver
+}
+
+// New creates a normalizer with the given config
+func New(cfg Config) *Normalizer {
+	schemes := cfg.Schemes
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+	normalized := make([]string, 0, len(schemes))
+	for _, scheme := range schemes {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			normalized = append(normalized, scheme)
+		}
+	}
+
+	return &Normalizer{
+		schemes:       normalized,
+		allowPrivate:  cfg.AllowPrivate,
+		stripTracking: cfg.StripTracking,
+		resolver:      cfg.Resolver,
+	}
+}
+
+// Normalize validates a destination URL and returns its canonical form. URLs
+// without a scheme get https://, the host is lowercased and converted to
+// punycode and default ports are removed. Tracking parameters are removed when
+// stripTracking is set or the normalizer always strips them.
+func (n *Normalizer) Normalize(ctx context.Context, rawURL string, stripTracking bool) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", invalid(rawURL, "URL is empty")
+	}
+	if len(rawURL) > MaxLength {
+		return "", invalid(rawURL, fmt.Sprintf("URL is longer than %d characters", MaxLength))
+	}
+	for _, c := range rawURL {
+		if c < 0x20 || c == 0x7f {
+			return "", invalid(rawURL, "URL contains control characters")
+		}
+	}
+
+	// Add the scheme when missing, "host:port" is not mistaken for a scheme
+	if !strings.Contains(rawURL, "://") {
+		if scheme, rest, ok := strings.Cut(rawURL, ":"); ok && isScheme(scheme) && !isPort(rest) {
+			return "", invalid(rawURL, fmt.Sprintf("scheme '%s' is not allowed", strings.ToLower(scheme)))
+		}
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", invalid(rawURL, "URL cannot be parsed")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !slices.Contains(n.schemes, u.Scheme) {
+		return "", invalid(rawURL, fmt.Sprintf("scheme '%s' is not allowed", u.Scheme))
+	}
+	if u.User != nil {
+		return "", invalid(rawURL, "URL must not contain credentials")
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", invalid(rawURL, err.Error())
+	}
+	if err := n.checkTarget(ctx, host); err != nil {
+		return "", invalid(rawURL, err.Error())
+	}
+
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if stripTracking || n.stripTracking {
+		u.RawQuery = stripTrackingParams(u.RawQuery)
+	}
+	return u.String(), nil
+}
+
+// normalizeHost lowercases a host and converts international names to
+// punycode. IPv4 addresses in the legacy forms browsers accept, such as 127.1
+// or 0x7f.0.0.1, are converted to dotted decimal.
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("URL has no host")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String(), nil
+	}
+
+	host = strings.TrimSuffix(host, ".")
+	if parts := strings.Split(host, "."); isNumericPart(parts[len(parts)-1]) {
+		addr, ok := parseLegacyIPv4(parts)
+		if !ok {
+			return "", fmt.Errorf("host '%s' is not a valid IPv4 address", host)
+		}
+		return addr.String(), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("host '%s' is not a valid domain name", host)
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// isNumericPart reports whether a part of a host is a number, which makes
+// browsers read the host as an IPv4 address
+func isNumericPart(part string) bool {
+	if rest, ok := cutHexPrefix(part); ok {
+		return strings.Trim(rest, "0123456789abcdefABCDEF") == ""
+	}
+	return part != "" && strings.Trim(part, "0123456789") == ""
+}
+
+// cutHexPrefix returns part without its 0x prefix and whether it had one
+func cutHexPrefix(part string) (string, bool) {
+	if rest, ok := strings.CutPrefix(part, "0x"); ok {
+		return rest, true
+	}
+	return strings.CutPrefix(part, "0X")
+}
+
+// parseLegacyIPv4 parses the parts of an IPv4 address like inet_aton: up to
+// four decimal, octal (leading 0) or hexadecimal (leading 0x) numbers, the
+// last of which fills the remaining bytes of the address
+func parseLegacyIPv4(parts []string) (netip.Addr, bool) {
+	if len(parts) > 4 {
+		return netip.Addr{}, false
+	}
+
+	var value uint64
+	for i, part := range parts {
+		var n uint64
+		var err error
+		if rest, ok := cutHexPrefix(part); ok {
+			if rest != "" {
+				n, err = strconv.ParseUint(rest, 16, 32)
+			}
+		} else if len(part) > 1 && part[0] == '0' {
+			n, err = strconv.ParseUint(part[1:], 8, 32)
+		} else {
+			n, err = strconv.ParseUint(part, 10, 32)
+		}
+		if err != nil {
+			return netip.Addr{}, false
+		}
+
+		if i < len(parts)-1 {
+			if n > 255 {
+				return netip.Addr{}, false
+			}
+			value |= n << (8 * (3 - i))
+			continue
+		}
+		if n >= 1<<(8*(4-i)) {
+			return netip.Addr{}, false
+		}
+		value |= n
+	}
+
+	return netip.AddrFrom4([4]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}), true
+}
+
+// CheckHost rejects hosts on the local network unless private targets are
+// allowed, like the hosts of destinations are checked
+func (n *Normalizer) CheckHost(ctx context.Context, host string) error {
+	host, err := normalizeHost(host)
+	if err != nil {
+		return err
+	}
+	return n.checkTarget(ctx, host)
+}
+
+// DialControl returns a net.Dialer Control function refusing connections to
+// addresses that are not reachable on the public internet, or nil when
+// private targets are allowed. Checking the address being dialed covers host
+// names resolving differently than when they were checked.
+func (n *Normalizer) DialControl() func(network, address string, c syscall.RawConn) error {
+	if n.allowPrivate {
+		return nil
+	}
+	return func(network, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return fmt.Errorf("invalid address '%s': %w", address, err)
+		}
+		if addr := addrPort.Addr().Unmap(); isPrivate(addr) {
+			return fmt.Errorf("private address '%s' is not allowed", addr)
+		}
+		return nil
+	}
+}
+
+// checkTarget rejects hosts on the local network unless private targets are allowed
+func (n *Normalizer) checkTarget(ctx context.Context, host string) error {
+	if n.allowPrivate {
+		return nil
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if isPrivate(addr) {
+			return fmt.Errorf("private address '%s' is not allowed", host)
+		}
+		return nil
+	}
+
+	// Single label names and reserved local domains only resolve on the local network
+	if !strings.Contains(host, ".") || isLocalDomain(host) {
+		return fmt.Errorf("local host '%s' is not allowed", host)
+	}
+
+	if n.resolver != nil {
+		addrs, err := n.resolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return fmt.Errorf("host '%s' cannot be resolved", host)
+		}
+		for _, addr := range addrs {
+			if isPrivate(addr.Unmap()) {
+				return fmt.Errorf("host '%s' resolves to private address '%s'", host, addr.Unmap())
+			}
+		}
+	}
+
+	return nil
+}
+
+// reservedPrefixes are the ranges beyond those of netip that are not routed
+// on the public internet: the "this network" range, shared address space of
+// carrier-grade NAT, the range reserved for benchmarking and the NAT64 prefix
+// embedding IPv4 addresses, which may be private
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// isPrivate reports whether an address is not reachable on the public internet
+func isPrivate(addr netip.Addr) bool {
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified()
+}
+
+// isLocalDomain reports whether a host belongs to a domain reserved for local use
+func isLocalDomain(host string) bool {
+	for _, suffix := range []string{"localhost", "local", "internal", "home.arpa", "lan"} {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripTrackingParams removes tracking parameters from a raw query, keeping
+// the order and encoding of the remaining parameters
+func stripTrackingParams(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if decoded, err := url.QueryUnescape(name); err == nil {
+			name = decoded
+		}
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "utm_") || trackingParams[name] {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
+
+// isScheme reports whether s is syntactically a URL scheme
+func isScheme(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isPort reports whether s starts with a port number, as in the rest of "host:8080/path"
+func isPort(s string) bool {
+	end := strings.IndexAny(s, "/?#")
+	if end == -1 {
+		end = len(s)
+	}
+	if end == 0 {
+		return false
+	}
+	for _, c := range s[:end] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// invalid returns an ErrInvalidURL with the given reason
+func invalid(rawURL, reason string) error {
+	return &model.ErrInvalidURL{URL: rawURL, Reason: reason}
+}
@@ -0,0 +1,125 @@
+package useragent
+
+import "strings"
+
+// botPatterns are lowercase substrings of the User-Agents of crawlers, link
+// preview fetchers, monitoring services and HTTP libraries. Most crawlers
+// identify themselves with a word ending in "bot", matched by hasBotWord, or
+// with "crawl" or "spider", the remaining entries cover clients that do not.
+// In-app browsers of social apps send their app's name too, so only names of
+// dedicated preview fetchers are listed.
+var botPatterns = []string{
+	// Generic crawler tokens
+	"crawl",
+	"spider",
+	"slurp",
+	"scrape",
+	"preview",
+	"fetcher",
+	"headless",
+
+	// Link previews of social networks and messengers
+	"facebookexternalhit",
+	"facebookcatalog",
+	"meta-externalagent",
+	"whatsapp",
+	"skypeuripreview",
+	"vkshare",
+	"embedly",
+	"iframely",
+	"cardyb",
+	"mastodon/",
+	"pleroma",
+	"nuzzel",
+	"outbrain",
+	"quora link preview",
+	"flipboardproxy",
+
+	// Search engines and SEO tools not caught by the generic tokens
+	"google-inspectiontool",
+	"googleother",
+	"mediapartners-google",
+	"feedfetcher",
+	"ia_archiver",
+	"archive.org",
+	"lighthouse",
+	"pagespeed",
+	"gtmetrix",
+	"pingdom",
+	"uptime",
+	"statuscake",
+
+	// HTTP clients and libraries
+	"curl/",
+	"wget/",
+	"httpie/",
+	"python-requests",
+	"python-urllib",
+	"python-httpx",
+	"aiohttp",
+	"go-http-client",
+	"okhttp",
+	"java/",
+	"apache-httpclient",
+	"libwww-perl",
+	"axios/",
+	"node-fetch",
+	"undici",
+	"guzzlehttp",
+	"ruby/",
+	"phantomjs",
+	"scrapy",
+}
+
+// botUserAgents are complete lowercase User-Agents of HTTP libraries too short
+// to match as substrings, such as the default of Ruby's Net::HTTP
+var botUserAgents = map[string]bool{
+	"ruby": true,
+}
+
+// botLookalikes are words ending in "bot" that name devices rather than
+// crawlers, such as Cubot phones
+var botLookalikes = map[string]bool{
+	"cubot": true,
+}
+
+// IsBot reports whether a User-Agent belongs to a crawler, link preview
+// fetcher or HTTP library rather than a person's browser. Requests without a
+// User-Agent are sent by scripts and count as bots too.
+func IsBot(ua string) bool {
+	ua = strings.ToLower(strings.TrimSpace(ua))
+	if ua == "" || botUserAgents[ua] || hasBotWord(ua) {
+		return true
+	}
+	for _, pattern := range botPatterns {
+		if strings.Contains(ua, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBotWord reports whether a lowercase User-Agent contains a word ending in
+// "bot", such as Googlebot/2.1 or Slackbot-LinkExpanding, other than the
+// names of devices in botLookalikes
+func hasBotWord(ua string) bool {
+	for i := 0; ; {
+		n := strings.Index(ua[i:], "bot")
+		if n < 0 {
+			return false
+		}
+		start, end := i+n, i+n+len("bot")
+		for start > 0 && isWordChar(ua[start-1]) {
+			start--
+		}
+		if (end == len(ua) || !isWordChar(ua[end])) && !botLookalikes[ua[start:end]] {
+			return true
+		}
+		i = end
+	}
+}
+
+// isWordChar reports whether c is a lowercase letter or digit
+func isWordChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || '0' <= c && c <= '9'
+}
@@ -0,0 +1,115 @@
+// Package useragent classifies clients by their User-Agent header.
+//
+// The parser only tells apart the operating systems, device classes and
+// browsers links are commonly routed by. It matches well-known tokens rather
+// than parsing versions, unknown clients get empty fields.
+package useragent
+
+import "strings"
+
+// Operating systems
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+)
+
+// Device classes
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+)
+
+// Browsers
+const (
+	BrowserChrome  = "chrome"
+	BrowserSafari  = "safari"
+	BrowserFirefox = "firefox"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+	BrowserSamsung = "samsung"
+)
+
+// OperatingSystems lists the operating systems recognized by Parse
+var OperatingSystems = []string{OSiOS, OSAndroid, OSWindows, OSMacOS, OSLinux, OSChromeOS}
+
+// Devices lists the device classes recognized by Parse
+var Devices = []string{DeviceMobile, DeviceTablet, DeviceDesktop}
+
+// Browsers lists the browsers recognized by Parse
+var Browsers = []string{BrowserChrome, BrowserSafari, BrowserFirefox, BrowserEdge, BrowserOpera, BrowserSamsung}
+
+// Agent is the classification of a User-Agent
+type Agent struct {
+	OS      string
+	Device  string
+	Browser string
+}
+
+// token maps a substring of a User-Agent to a value
+type token struct {
+	substr string
+	value  string
+}
+
+// osTokens are checked in order, more specific tokens first
+var osTokens = []token{
+	{"iPhone", OSiOS},
+	{"iPad", OSiOS},
+	{"iPod", OSiOS},
+	{"Android", OSAndroid},
+	{"CrOS", OSChromeOS},
+	{"Windows", OSWindows},
+	{"Macintosh", OSMacOS},
+	{"Mac OS X", OSMacOS},
+	{"Linux", OSLinux},
+}
+
+// browserTokens are checked in order, browsers based on Chrome or Safari
+// mention them too and come first
+var browserTokens = []token{
+	{"Edg/", BrowserEdge},
+	{"EdgA/", BrowserEdge},
+	{"EdgiOS/", BrowserEdge},
+	{"Edge/", BrowserEdge},
+	{"OPR/", BrowserOpera},
+	{"Opera", BrowserOpera},
+	{"SamsungBrowser/", BrowserSamsung},
+	{"Firefox/", BrowserFirefox},
+	{"FxiOS/", BrowserFirefox},
+	{"CriOS/", BrowserChrome},
+	{"Chrome/", BrowserChrome},
+	{"Safari/", BrowserSafari},
+}
+
+// Parse classifies a User-Agent
+func Parse(ua string) Agent {
+	var agent Agent
+	agent.OS = match(ua, osTokens)
+	agent.Browser = match(ua, browserTokens)
+
+	switch {
+	case strings.Contains(ua, "iPad"), agent.OS == OSAndroid && !strings.Contains(ua, "Mobile"), strings.Contains(ua, "Tablet"):
+		agent.Device = DeviceTablet
+	case agent.OS == OSiOS, strings.Contains(ua, "Mobi"):
+		agent.Device = DeviceMobile
+	case agent.OS != "":
+		agent.Device = DeviceDesktop
+	}
+
+	return agent
+}
+
+// match returns the value of the first token found in ua
+func match(ua string, tokens []token) string {
+	for _, t := range tokens {
+		if strings.Contains(ua, t.substr) {
+			return t.value
+		}
+	}
+	return ""
+}
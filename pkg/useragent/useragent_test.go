@@ -0,0 +1,127 @@
+package useragent
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want Agent
+	}{
+		{
+			"iPhoneSafari",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			Agent{OSiOS, DeviceMobile, BrowserSafari},
+		},
+		{
+			"iPhoneChrome",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/123.0.6312.52 Mobile/15E148 Safari/604.1",
+			Agent{OSiOS, DeviceMobile, BrowserChrome},
+		},
+		{
+			"iPad",
+			"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+			Agent{OSiOS, DeviceTablet, BrowserSafari},
+		},
+		{
+			"AndroidPhone",
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Mobile Safari/537.36",
+			Agent{OSAndroid, DeviceMobile, BrowserChrome},
+		},
+		{
+			"AndroidTablet",
+			"Mozilla/5.0 (Linux; Android 13; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Safari/537.36",
+			Agent{OSAndroid, DeviceTablet, BrowserSamsung},
+		},
+		{
+			"WindowsEdge",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36 Edg/123.0.2420.65",
+			Agent{OSWindows, DeviceDesktop, BrowserEdge},
+		},
+		{
+			"MacFirefox",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:124.0) Gecko/20100101 Firefox/124.0",
+			Agent{OSMacOS, DeviceDesktop, BrowserFirefox},
+		},
+		{
+			"LinuxOpera",
+			"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36 OPR/108.0.0.0",
+			Agent{OSLinux, DeviceDesktop, BrowserOpera},
+		},
+		{
+			"ChromeOS",
+			"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36",
+			Agent{OSChromeOS, DeviceDesktop, BrowserChrome},
+		},
+		{"Curl", "curl/8.5.0", Agent{}},
+		{"Empty", "", Agent{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.ua); got != tt.want {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBot(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want bool
+	}{
+		{"Slack", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", true},
+		{"Twitter", "Twitterbot/1.0", true},
+		{"Facebook", "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", true},
+		{"WhatsApp", "WhatsApp/2.23.20.0", true},
+		{"Googlebot", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", true},
+		{"HeadlessChrome", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/123.0.0.0 Safari/537.36", true},
+		{"Curl", "curl/8.5.0", true},
+		{"GoClient", "Go-http-client/2.0", true},
+		{"Empty", " ", true},
+		{"DuckDuckBot", "DuckDuckBot-Https/1.1; (+https://duckduckgo.com/duckduckbot)", true},
+		{"Telegram", "TelegramBot (like TwitterBot)", true},
+		{"RubyNetHTTP", "Ruby", true},
+		{"RubyRestClient", "rest-client/2.1.0 (linux x86_64) ruby/3.2.2p53", true},
+		{
+			"CubotPhone",
+			"Mozilla/5.0 (Linux; Android 10; CUBOT_X30 Build/QP1A.190711.020; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/123.0.6312.99 Mobile Safari/537.36",
+			false,
+		},
+		{
+			"CubotPhoneChrome",
+			"Mozilla/5.0 (Linux; Android 9; CUBOT P30) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Mobile Safari/537.36",
+			false,
+		},
+		{
+			"RubyDevice",
+			"Mozilla/5.0 (Linux; Android 12; Ruby Pro) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Mobile Safari/537.36",
+			false,
+		},
+		{
+			"iPhoneSafari",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			false,
+		},
+		{
+			"FacebookInAppBrowser",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [FBAN/FBIOS;FBAV/458.0.0.0]",
+			false,
+		},
+		{
+			"WindowsEdge",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36 Edg/123.0.2420.65",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsBot(tt.ua); got != tt.want {
+				t.Errorf("IsBot(%q) = %v, want %v", tt.ua, got, tt.want)
+			}
+		})
+	}
+}
@@ -7,6 +7,8 @@ import (
 )
 
 // IsValidURL checks if a URL is valid
+//
+// Deprecated: use urlnorm.Normalizer, which also checks the scheme and target of the URL.
 func IsValidURL(rawURL string) bool {
 	// Add scheme if missing
 	if !strings.HasPrefix(rawURL, "http://") && !strings.HasPrefix(rawURL, "https://") {
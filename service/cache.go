@@ -0,0 +1,77 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/metrics"
+)
+
+// maxCacheEntries bounds the number of URLs kept in the cache
+const maxCacheEntries = 10000
+
+// cacheEntry is a cached URL together with its expiry time
+type cacheEntry struct {
+	url       model.URL
+	expiresAt time.Time
+}
+
+// urlCache is a small in-memory TTL cache for URL lookups by short code
+type urlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+}
+
+// newURLCache creates a new URL cache with the given TTL
+func newURLCache(ttl time.Duration) *urlCache {
+	return &urlCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// get returns a copy of the cached URL for a short code
+func (c *urlCache) get(shortCode string) (*model.URL, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[shortCode]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(c.entries, shortCode)
+		metrics.CacheLookups.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+
+	metrics.CacheLookups.WithLabelValues("hit").Inc()
+	url := entry.url
+	return &url, true
+}
+
+// set stores a copy of a URL in the cache
+func (c *urlCache) set(url *model.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxCacheEntries {
+		for code, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, code)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			return
+		}
+	}
+
+	c.entries[url.ShortCode] = cacheEntry{url: *url, expiresAt: now.Add(c.ttl)}
+}
+
+// delete removes a short code from the cache
+func (c *urlCache) delete(shortCode string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, shortCode)
+}
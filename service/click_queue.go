@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/metrics"
+)
+
+// ClickQueue records clicks asynchronously with a fixed pool of workers
+type ClickQueue struct {
+	urlService URLServiceInterface
+	clicks     chan queuedClick
+	wg         sync.WaitGroup
+}
+
+// queuedClick is a click waiting to be recorded
+type queuedClick struct {
+	ctx   context.Context
+	click model.Click
+}
+
+// NewClickQueue creates a click queue holding up to size pending clicks and starts its workers
+func NewClickQueue(urlService URLServiceInterface, size, workers int) *ClickQueue {
+	if workers < 1 {
+		workers = 1
+	}
+
+	q := &ClickQueue{
+		urlService: urlService,
+		clicks:     make(chan queuedClick, size),
+	}
+
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+
+	return q
+}
+
+// Enqueue queues a click for recording, dropping it if the queue is full.
+// The click is recorded with the values of ctx, such as the trace span, but
+// independent of its cancellation.
+func (q *ClickQueue) Enqueue(ctx context.Context, click model.Click) bool {
+	select {
+	case q.clicks <- queuedClick{ctx: context.WithoutCancel(ctx), click: click}:
+		metrics.ClickQueueDepth.Set(float64(len(q.clicks)))
+		return true
+	default:
+		metrics.ClicksDropped.Inc()
+		return false
+	}
+}
+
+// Len returns the number of clicks waiting to be recorded
+func (q *ClickQueue) Len() int {
+	return len(q.clicks)
+}
+
+// Close stops accepting clicks and waits until the queued clicks are recorded
+func (q *ClickQueue) Close() {
+	close(q.clicks)
+	q.wg.Wait()
+}
+
+// work records queued clicks until the queue is closed
+func (q *ClickQueue) work() {
+	defer q.wg.Done()
+
+	for queued := range q.clicks {
+		metrics.ClickQueueDepth.Set(float64(len(q.clicks)))
+		if err := q.urlService.RecordClick(queued.ctx, queued.click); err != nil {
+			slog.ErrorContext(queued.ctx, "Failed to record click", "code", queued.click.ShortCode, "error", err)
+		}
+	}
+}
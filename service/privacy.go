@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/jobs"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/tracing"
+)
+
+// IPMode selects how the IP addresses of visitors are stored with clicks
+type IPMode string
+
+// IP modes
+const (
+	// IPModeOff stores no IP addresses
+	IPModeOff IPMode = "off"
+
+	// IPModeAnonymize stores IP addresses without their host part, keeping
+	// the /24 network of IPv4 and the /48 network of IPv6 addresses
+	IPModeAnonymize IPMode = "anonymize"
+
+	// IPModeFull stores complete IP addresses
+	IPModeFull IPMode = "full"
+)
+
+// Valid reports whether m is a known IP mode
+func (m IPMode) Valid() bool {
+	return m == IPModeOff || m == IPModeAnonymize || m == IPModeFull
+}
+
+// Retention sets how long data about visitors is kept. A zero period keeps
+// the data forever.
+type Retention struct {
+	// VisitorData is how long the IP addresses and User-Agents of clicks are kept
+	VisitorData time.Duration
+
+	// Clicks is how long click events are kept before they are rolled up into
+	// daily aggregates, which keep the statistics of the day without the
+	// visitor data, while the click rollups keep its hourly series
+	Clicks time.Duration
+
+	// WebhookDeliveries is how long delivered and failed webhook deliveries
+	// are kept in the delivery log
+	WebhookDeliveries time.Duration
+}
+
+// WithIPMode stores the IP addresses of visitors with clicks in the given mode
+func WithIPMode(mode IPMode) Option {
+	return func(s *URLService) {
+		s.ipMode = mode
+	}
+}
+
+// WithDoNotTrack stores no data identifying visitors that send the DNT or
+// Sec-GPC header when respect is true
+func WithDoNotTrack(respect bool) Option {
+	return func(s *URLService) {
+		s.respectDoNotTrack = respect
+	}
+}
+
+// WithRetention applies the given retention periods in ApplyRetention
+func WithRetention(retention Retention) Option {
+	return func(s *URLService) {
+		s.retention = retention
+	}
+}
+
+// storedIP returns the IP address of a visitor as stored in the IP mode of the service
+func (s *URLService) storedIP(ip string) string {
+	switch s.ipMode {
+	case IPModeFull:
+		return ip
+	case IPModeAnonymize:
+		return anonymizeIP(ip)
+	default:
+		return ""
+	}
+}
+
+// anonymizeIP returns the network of an IP address, zeroing the last 8 bits
+// of IPv4 and the last 80 bits of IPv6 addresses. Invalid addresses are
+// dropped.
+func anonymizeIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.WithZone("").Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
+
+// ApplyRetention erases visitor data, rolls up click events and deletes
+// finished webhook deliveries older than their retention periods, logging the
+// progress to log. Click events are rolled up by whole days once all clicks of
+// the day are older than the retention period.
+func (s *URLService) ApplyRetention(ctx context.Context, now time.Time, log *slog.Logger) (err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.ApplyRetention")
+	defer end(&err)
+
+	if s.retention.VisitorData > 0 {
+		before := now.Add(-s.retention.VisitorData)
+		n, err := s.db.EraseVisitorData(ctx, before)
+		if err != nil {
+			return err
+		}
+		log.InfoContext(ctx, "Erased visitor data of clicks", "clicks", n, "before", before.UTC().Format(time.RFC3339))
+	}
+
+	if s.retention.Clicks > 0 {
+		before := now.Add(-s.retention.Clicks).UTC().Truncate(24 * time.Hour)
+		var days, total int64
+		for {
+			day, err := s.db.OldestClickDay(ctx, before)
+			if err != nil {
+				return err
+			}
+			if day.IsZero() {
+				break
+			}
+
+			n, err := s.db.RollUpClicks(ctx, day)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return fmt.Errorf("no clicks rolled up on %s", day.Format(time.DateOnly))
+			}
+			days++
+			total += n
+			log.InfoContext(ctx, "Rolled up clicks", "day", day.Format(time.DateOnly), "clicks", n)
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		log.InfoContext(ctx, "Rolled up clicks older than the retention period", "days", days, "clicks", total)
+	}
+
+	if s.retention.WebhookDeliveries > 0 {
+		before := now.Add(-s.retention.WebhookDeliveries)
+		n, err := s.db.DeleteWebhookDeliveries(ctx, before)
+		if err != nil {
+			return err
+		}
+		log.InfoContext(ctx, "Deleted webhook deliveries", "deliveries", n, "before", before.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// privatize removes the data a click may not store: the visitor data of
+// visits asking not to be tracked and the IP address beyond the IP mode
+func (s *URLService) privatize(click *model.Click) {
+	if click.DoNotTrack && s.respectDoNotTrack {
+		click.IP, click.UserAgent = "", ""
+		return
+	}
+	click.IP = s.storedIP(click.IP)
+}
+
+// RetentionJob returns a job applying the retention periods every interval
+func (s *URLService) RetentionJob(interval time.Duration) jobs.Job {
+	return jobs.Job{
+		Name:     "retention",
+		Interval: interval,
+		Run: func(ctx context.Context, log *slog.Logger) error {
+			return s.ApplyRetention(ctx, time.Now(), log)
+		},
+	}
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/tracing"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/useragent"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxRules is the maximum number of routing rules of a URL
+const maxRules = 20
+
+// languageTagPattern matches language tags such as de or pt-BR
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// countryCodePattern matches ISO 3166-1 alpha-2 country codes such as DE
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// locations caches time zones loaded for rules by name
+var locations sync.Map
+
+// Visitor describes the client of a visit for matching routing rules
+type Visitor struct {
+	// Agent is the classified User-Agent of the client
+	Agent useragent.Agent
+
+	// Language is the preferred language tag of the client, empty when unknown
+	Language string
+
+	// Country is the ISO country code of the client's IP address, empty when unknown
+	Country string
+
+	// Time is the time of the visit
+	Time time.Time
+}
+
+// SetRules replaces the routing rules of a URL
+func (s *URLService) SetRules(ctx context.Context, shortCode string, rules []model.Rule) (_ *model.URL, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.SetRules", attribute.String("url.short_code", shortCode))
+	defer end(&err)
+
+	url, err := s.db.GetURLByShortCode(ctx, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get URL: %w", err)
+	}
+	if url == nil {
+		return nil, &model.ErrURLNotFound{Code: shortCode}
+	}
+
+	rules, err = s.normalizeRules(ctx, rules, url.StripTracking, url.UTM)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.UpdateRules(ctx, shortCode, rules); err != nil {
+		return nil, err
+	}
+
+	if s.cache != nil {
+		s.cache.delete(shortCode)
+	}
+	url.Rules = rules
+	return url, nil
+}
+
+// normalizeRules validates routing rules and normalizes their destinations and condition values
+func (s *URLService) normalizeRules(ctx context.Context, rules []model.Rule, strip bool, utm model.UTM) ([]model.Rule, error) {
+	if len(rules) > maxRules {
+		return nil, &model.ErrInvalidRule{Index: maxRules + 1, Reason: fmt.Sprintf("at most %d rules are allowed", maxRules)}
+	}
+
+	normalized := make([]model.Rule, 0, len(rules))
+	for i, rule := range rules {
+		invalid := func(format string, args ...any) error {
+			return &model.ErrInvalidRule{Index: i + 1, Reason: fmt.Sprintf(format, args...)}
+		}
+
+		var err error
+		if rule.OS, err = normalizeValues(rule.OS, useragent.OperatingSystems); err != nil {
+			return nil, invalid("os %v", err)
+		}
+		if rule.Devices, err = normalizeValues(rule.Devices, useragent.Devices); err != nil {
+			return nil, invalid("device %v", err)
+		}
+		if rule.Browsers, err = normalizeValues(rule.Browsers, useragent.Browsers); err != nil {
+			return nil, invalid("browser %v", err)
+		}
+		languages := make([]string, len(rule.Languages))
+		for j, tag := range rule.Languages {
+			tag = strings.TrimSpace(tag)
+			if !languageTagPattern.MatchString(tag) {
+				return nil, invalid("'%s' is not a language tag", tag)
+			}
+			languages[j] = strings.ToLower(tag)
+		}
+		rule.Languages = languages
+
+		countries := make([]string, len(rule.Countries))
+		for j, code := range rule.Countries {
+			code = strings.ToUpper(strings.TrimSpace(code))
+			if !countryCodePattern.MatchString(code) {
+				return nil, invalid("'%s' is not a two letter country code", code)
+			}
+			countries[j] = code
+		}
+		rule.Countries = countries
+
+		if rule.After != "" {
+			if _, ok := parseClock(rule.After); !ok {
+				return nil, invalid("after '%s' is not a time of day as HH:MM", rule.After)
+			}
+		}
+		if rule.Before != "" {
+			if _, ok := parseClock(rule.Before); !ok {
+				return nil, invalid("before '%s' is not a time of day as HH:MM", rule.Before)
+			}
+		}
+		if rule.TimeZone != "" {
+			if _, err := loadLocation(rule.TimeZone); err != nil {
+				return nil, invalid("unknown time zone '%s'", rule.TimeZone)
+			}
+		}
+
+		if len(rule.OS) == 0 && len(rule.Devices) == 0 && len(rule.Browsers) == 0 &&
+			len(rule.Languages) == 0 && len(rule.Countries) == 0 && rule.After == "" && rule.Before == "" {
+			return nil, invalid("at least one condition is required")
+		}
+
+		if rule.LongURL, err = s.normalizeDestination(ctx, rule.LongURL, strip, utm); err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, rule)
+	}
+
+	return normalized, nil
+}
+
+// normalizeValues lowercases condition values and checks them against the known values
+func normalizeValues(values, known []string) ([]string, error) {
+	normalized := make([]string, len(values))
+	for i, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if !slices.Contains(known, value) {
+			return nil, fmt.Errorf("'%s' is unknown, expected one of %s", value, strings.Join(known, ", "))
+		}
+		normalized[i] = value
+	}
+	return normalized, nil
+}
+
+// MatchRule returns the first routing rule of a URL matching the visitor, or nil
+func MatchRule(url *model.URL, visitor Visitor) *model.Rule {
+	for i := range url.Rules {
+		if ruleMatches(&url.Rules[i], visitor) {
+			return &url.Rules[i]
+		}
+	}
+	return nil
+}
+
+// ruleMatches reports whether the visitor meets all conditions of a rule
+func ruleMatches(rule *model.Rule, visitor Visitor) bool {
+	if len(rule.OS) > 0 && !slices.Contains(rule.OS, visitor.Agent.OS) {
+		return false
+	}
+	if len(rule.Devices) > 0 && !slices.Contains(rule.Devices, visitor.Agent.Device) {
+		return false
+	}
+	if len(rule.Browsers) > 0 && !slices.Contains(rule.Browsers, visitor.Agent.Browser) {
+		return false
+	}
+	if len(rule.Languages) > 0 && !slices.ContainsFunc(rule.Languages, func(tag string) bool {
+		return languageMatches(tag, visitor.Language)
+	}) {
+		return false
+	}
+	if len(rule.Countries) > 0 && !slices.Contains(rule.Countries, visitor.Country) {
+		return false
+	}
+	if rule.After != "" || rule.Before != "" {
+		return inTimeWindow(rule, visitor.Time)
+	}
+	return true
+}
+
+// languageMatches reports whether a rule's language tag covers the visitor's
+// language, either exactly or as a prefix ending at a subtag boundary
+func languageMatches(tag, language string) bool {
+	language = strings.ToLower(language)
+	return language == tag || strings.HasPrefix(language, tag+"-")
+}
+
+// inTimeWindow reports whether t falls between the After and Before times of a rule
+func inTimeWindow(rule *model.Rule, t time.Time) bool {
+	loc := time.UTC
+	if rule.TimeZone != "" {
+		var err error
+		if loc, err = loadLocation(rule.TimeZone); err != nil {
+			return false
+		}
+	}
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+
+	after, hasAfter := parseClock(rule.After)
+	before, hasBefore := parseClock(rule.Before)
+	switch {
+	case hasAfter && hasBefore && after <= before:
+		return now >= after && now < before
+	case hasAfter && hasBefore:
+		// The window spans midnight
+		return now >= after || now < before
+	case hasAfter:
+		return now >= after
+	default:
+		return now < before
+	}
+}
+
+// parseClock parses a time of day as HH:MM into minutes since midnight
+func parseClock(s string) (int, bool) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
+
+// loadLocation loads a time zone, caching it for later visits
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxScheduledChanges is the maximum number of scheduled destination changes of a URL
+const maxScheduledChanges = 20
+
+// SetSchedule replaces the activation window and scheduled changes of a URL
+func (s *URLService) SetSchedule(ctx context.Context, shortCode string, schedule model.Schedule) (_ *model.URL, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.SetSchedule", attribute.String("url.short_code", shortCode))
+	defer end(&err)
+
+	url, err := s.db.GetURLByShortCode(ctx, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get URL: %w", err)
+	}
+	if url == nil {
+		return nil, &model.ErrURLNotFound{Code: shortCode}
+	}
+
+	schedule, err = s.normalizeSchedule(ctx, schedule, len(url.Variants) > 0, url.StripTracking, url.UTM)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.UpdateSchedule(ctx, shortCode, schedule); err != nil {
+		return nil, err
+	}
+
+	if s.cache != nil {
+		s.cache.delete(shortCode)
+	}
+	url.Schedule = schedule
+	return url, nil
+}
+
+// normalizeSchedule validates a schedule, normalizes the destinations of its
+// changes and orders them by time. Times are stored in UTC.
+func (s *URLService) normalizeSchedule(ctx context.Context, schedule model.Schedule, variants, strip bool, utm model.UTM) (model.Schedule, error) {
+	invalid := func(format string, args ...any) error {
+		return &model.ErrInvalidSchedule{Reason: fmt.Sprintf(format, args...)}
+	}
+
+	normalized := model.Schedule{
+		ActiveFrom:  utcTime(schedule.ActiveFrom),
+		ActiveUntil: utcTime(schedule.ActiveUntil),
+	}
+	if normalized.ActiveFrom != nil && normalized.ActiveUntil != nil && !normalized.ActiveUntil.After(*normalized.ActiveFrom) {
+		return model.Schedule{}, invalid("active_until must be after active_from")
+	}
+
+	if len(schedule.Changes) > maxScheduledChanges {
+		return model.Schedule{}, invalid("at most %d scheduled changes are allowed", maxScheduledChanges)
+	}
+	if len(schedule.Changes) > 0 && variants {
+		return model.Schedule{}, invalid("the destination of a URL with variants cannot be changed")
+	}
+	for i, change := range schedule.Changes {
+		if change.At.IsZero() {
+			return model.Schedule{}, invalid("change %d has no time", i+1)
+		}
+		longURL, err := s.normalizeDestination(ctx, change.LongURL, strip, utm)
+		if err != nil {
+			return model.Schedule{}, err
+		}
+		normalized.Changes = append(normalized.Changes, model.ScheduledChange{At: change.At.UTC(), LongURL: longURL})
+	}
+
+	slices.SortStableFunc(normalized.Changes, func(a, b model.ScheduledChange) int {
+		return a.At.Compare(b.At)
+	})
+	for i := 1; i < len(normalized.Changes); i++ {
+		if normalized.Changes[i].At.Equal(normalized.Changes[i-1].At) {
+			return model.Schedule{}, invalid("two changes are scheduled at %s", normalized.Changes[i].At.Format(time.RFC3339))
+		}
+	}
+
+	return normalized, nil
+}
+
+// utcTime returns a copy of t in UTC, or nil
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
@@ -1,29 +1,61 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/mstgnz/self-hosted-url-shortener/database"
 	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/blocklist"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/shortcode"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/urlnorm"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/useragent"
 )
 
 // MockDatabase implements the database interface for testing
 type MockDatabase struct {
-	urls map[string]*model.URL
-	id   int64
+	urls      map[string]*model.URL
+	id        int64
+	sequences map[string]int64
+	blocked   map[string]*model.BlockedDomain
+	clicks    []model.Click
+	saltLoads int
+	rolledUp  []time.Time
+
+	compactedBefore time.Time
+
+	webhooks   []*model.Webhook
+	deliveries []*model.WebhookDelivery
 }
 
 // NewMockDatabase creates a new mock database
 func NewMockDatabase() *MockDatabase {
 	return &MockDatabase{
-		urls: make(map[string]*model.URL),
-		id:   1,
+		urls:      make(map[string]*model.URL),
+		id:        1,
+		sequences: make(map[string]int64),
+		blocked:   make(map[string]*model.BlockedDomain),
 	}
 }
 
-// SaveURL saves a URL to the mock database
-func (m *MockDatabase) SaveURL(url *model.URL) error {
+// SaveURL saves a URL to the mock database, rejecting taken short codes
+func (m *MockDatabase) SaveURL(ctx context.Context, url *model.URL) error {
+	if _, exists := m.urls[url.ShortCode]; exists {
+		return &model.ErrCustomCodeAlreadyExists{Code: url.ShortCode}
+	}
 	url.ID = m.id
 	m.id++
 	m.urls[url.ShortCode] = url
@@ -31,7 +63,7 @@ func (m *MockDatabase) SaveURL(url *model.URL) error {
 }
 
 // GetURLByShortCode retrieves a URL by its short code
-func (m *MockDatabase) GetURLByShortCode(shortCode string) (*model.URL, error) {
+func (m *MockDatabase) GetURLByShortCode(ctx context.Context, shortCode string) (*model.URL, error) {
 	url, exists := m.urls[shortCode]
 	if !exists {
 		return nil, nil
@@ -39,31 +71,327 @@ func (m *MockDatabase) GetURLByShortCode(shortCode string) (*model.URL, error) {
 	return url, nil
 }
 
-// IncrementClicks increments the click count for a URL
-func (m *MockDatabase) IncrementClicks(shortCode string) error {
-	url, exists := m.urls[shortCode]
+// GetURLByDestHash retrieves the oldest URL with the given destination hash
+func (m *MockDatabase) GetURLByDestHash(ctx context.Context, destHash string) (*model.URL, error) {
+	var oldest *model.URL
+	for _, url := range m.urls {
+		if url.DestHash == destHash && (oldest == nil || url.ID < oldest.ID) {
+			oldest = url
+		}
+	}
+	return oldest, nil
+}
+
+// URLsWithoutDestHash returns the URLs without variants and destination hash
+func (m *MockDatabase) URLsWithoutDestHash(ctx context.Context) ([]*model.URL, error) {
+	var urls []*model.URL
+	for _, url := range m.urls {
+		if url.DestHash == "" && len(url.Variants) == 0 {
+			urls = append(urls, url)
+		}
+	}
+	return urls, nil
+}
+
+// SetDestHashes sets the destination hashes of URLs by their ID
+func (m *MockDatabase) SetDestHashes(ctx context.Context, hashes map[int64]string) error {
+	for _, url := range m.urls {
+		if hash, ok := hashes[url.ID]; ok {
+			url.DestHash = hash
+		}
+	}
+	return nil
+}
+
+// RecordClick increments the click counts of a URL and its variant
+func (m *MockDatabase) RecordClick(ctx context.Context, click model.Click) error {
+	url, exists := m.urls[click.ShortCode]
 	if !exists {
 		return os.ErrNotExist
 	}
+	if click.Bot {
+		m.clicks = append(m.clicks, click)
+		url.BotClicks++
+		return nil
+	}
+	if click.Visitor != "" && !slices.ContainsFunc(m.clicks, func(c model.Click) bool {
+		return c.ShortCode == click.ShortCode && c.Visitor == click.Visitor
+	}) {
+		url.Uniques++
+	}
+	m.clicks = append(m.clicks, click)
 	url.Clicks++
+	if variant := url.Variant(click.Variant); variant != nil {
+		variant.Clicks++
+	}
+	return nil
+}
+
+// ClickStats counts the recorded clicks of a URL per bucket, leaving out empty buckets
+func (m *MockDatabase) ClickStats(ctx context.Context, shortCode string, from, until time.Time, interval model.StatsInterval, limit int) (*model.ClickStats, error) {
+	stats := &model.ClickStats{ShortCode: shortCode, From: from, Until: until, Interval: interval}
+	for _, click := range m.clicks {
+		if click.ShortCode != shortCode || click.ClickedAt.Before(from) || !click.ClickedAt.Before(until) {
+			continue
+		}
+		if click.Bot {
+			stats.Bots++
+			continue
+		}
+		bucket := click.ClickedAt.UTC().Truncate(interval.Duration())
+		if n := len(stats.Series); n > 0 && stats.Series[n-1].Time.Equal(bucket) {
+			stats.Series[n-1].Clicks++
+		} else {
+			stats.Series = append(stats.Series, model.StatsPoint{Time: bucket, Clicks: 1})
+		}
+		stats.Total++
+	}
+	return stats, nil
+}
+
+// VisitorSalt returns a salt derived from the day, counting the loads
+func (m *MockDatabase) VisitorSalt(ctx context.Context, day time.Time) ([]byte, error) {
+	m.saltLoads++
+	return []byte(day.UTC().Format(time.DateOnly)), nil
+}
+
+// EraseVisitorData clears the IP addresses and User-Agents of the clicks before before
+func (m *MockDatabase) EraseVisitorData(ctx context.Context, before time.Time) (int64, error) {
+	var n int64
+	for i := range m.clicks {
+		if m.clicks[i].ClickedAt.Before(before) && (m.clicks[i].IP != "" || m.clicks[i].UserAgent != "") {
+			m.clicks[i].IP, m.clicks[i].UserAgent = "", ""
+			n++
+		}
+	}
+	return n, nil
+}
+
+// OldestClickDay returns the UTC day of the oldest click before before
+func (m *MockDatabase) OldestClickDay(ctx context.Context, before time.Time) (time.Time, error) {
+	var oldest time.Time
+	for _, click := range m.clicks {
+		if click.ClickedAt.Before(before) && (oldest.IsZero() || click.ClickedAt.Before(oldest)) {
+			oldest = click.ClickedAt
+		}
+	}
+	if oldest.IsZero() {
+		return oldest, nil
+	}
+	return oldest.UTC().Truncate(24 * time.Hour), nil
+}
+
+// RollUpClicks deletes the clicks of the UTC day starting at day, counting them as rolled up
+func (m *MockDatabase) RollUpClicks(ctx context.Context, day time.Time) (int64, error) {
+	var n int64
+	m.clicks = slices.DeleteFunc(m.clicks, func(click model.Click) bool {
+		if click.ClickedAt.UTC().Truncate(24 * time.Hour).Equal(day) {
+			n++
+			m.rolledUp = append(m.rolledUp, day)
+			return true
+		}
+		return false
+	})
+	return n, nil
+}
+
+// RebuildRollups does nothing, the mock database counts clicks from its click list
+func (m *MockDatabase) RebuildRollups(ctx context.Context) error {
+	return nil
+}
+
+// CompactRollups records the time before which hourly rollups are deleted
+func (m *MockDatabase) CompactRollups(ctx context.Context, before time.Time) (int64, error) {
+	m.compactedBefore = before
+	return 0, nil
+}
+
+// UpdateRules replaces the routing rules of a URL in the mock database
+func (m *MockDatabase) UpdateRules(ctx context.Context, shortCode string, rules []model.Rule) error {
+	url, exists := m.urls[shortCode]
+	if !exists {
+		return &model.ErrURLNotFound{Code: shortCode}
+	}
+	url.Rules = rules
+	return nil
+}
+
+// UpdateSchedule replaces the schedule of a URL in the mock database
+func (m *MockDatabase) UpdateSchedule(ctx context.Context, shortCode string, schedule model.Schedule) error {
+	url, exists := m.urls[shortCode]
+	if !exists {
+		return &model.ErrURLNotFound{Code: shortCode}
+	}
+	url.Schedule = schedule
 	return nil
 }
 
-// ListURLs returns all URLs in the mock database
-func (m *MockDatabase) ListURLs() ([]*model.URL, error) {
+// ListURLs returns the URLs in the mock database matching a filter
+func (m *MockDatabase) ListURLs(ctx context.Context, filter model.URLFilter) ([]*model.URL, error) {
 	urls := make([]*model.URL, 0, len(m.urls))
 	for _, url := range m.urls {
+		if filter.Campaign != "" && url.UTM.Campaign != filter.Campaign {
+			continue
+		}
 		urls = append(urls, url)
 	}
 	return urls, nil
 }
 
 // DeleteURL deletes a URL from the mock database
-func (m *MockDatabase) DeleteURL(shortCode string) error {
+func (m *MockDatabase) DeleteURL(ctx context.Context, shortCode string) error {
 	delete(m.urls, shortCode)
 	return nil
 }
 
+// NextSequence increments the named counter in the mock database
+func (m *MockDatabase) NextSequence(ctx context.Context, name string) (int64, error) {
+	m.sequences[name]++
+	return m.sequences[name], nil
+}
+
+// ListBlockedDomains returns the blocked domains in the mock database
+func (m *MockDatabase) ListBlockedDomains(ctx context.Context) ([]*model.BlockedDomain, error) {
+	domains := make([]*model.BlockedDomain, 0, len(m.blocked))
+	for _, domain := range m.blocked {
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// AddBlockedDomain blocks a domain in the mock database
+func (m *MockDatabase) AddBlockedDomain(ctx context.Context, domain string) error {
+	if _, exists := m.blocked[domain]; !exists {
+		m.blocked[domain] = &model.BlockedDomain{Domain: domain, CreatedAt: time.Now()}
+	}
+	return nil
+}
+
+// RemoveBlockedDomain unblocks a domain in the mock database
+func (m *MockDatabase) RemoveBlockedDomain(ctx context.Context, domain string) error {
+	delete(m.blocked, domain)
+	return nil
+}
+
+// CreateWebhook saves a webhook in the mock database
+func (m *MockDatabase) CreateWebhook(ctx context.Context, webhook *model.Webhook) error {
+	webhook.ID = int64(len(m.webhooks) + 1)
+	saved := *webhook
+	m.webhooks = append(m.webhooks, &saved)
+	return nil
+}
+
+// ListWebhooks returns copies of the webhooks in the mock database
+func (m *MockDatabase) ListWebhooks(ctx context.Context) ([]*model.Webhook, error) {
+	var webhooks []*model.Webhook
+	for _, webhook := range m.webhooks {
+		listed := *webhook
+		webhooks = append(webhooks, &listed)
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes a webhook and its deliveries from the mock database
+func (m *MockDatabase) DeleteWebhook(ctx context.Context, id int64) error {
+	n := len(m.webhooks)
+	m.webhooks = slices.DeleteFunc(m.webhooks, func(webhook *model.Webhook) bool { return webhook.ID == id })
+	if len(m.webhooks) == n {
+		return &model.ErrWebhookNotFound{ID: id}
+	}
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(delivery *model.WebhookDelivery) bool { return delivery.WebhookID == id })
+	return nil
+}
+
+// EnqueueWebhookEvent queues a delivery for every webhook subscribing to the event
+func (m *MockDatabase) EnqueueWebhookEvent(ctx context.Context, event model.WebhookEvent, payload []byte, at time.Time) (int64, error) {
+	var n int64
+	for _, webhook := range m.webhooks {
+		if !webhook.Subscribes(event) {
+			continue
+		}
+		next := at
+		m.deliveries = append(m.deliveries, &model.WebhookDelivery{
+			ID:            int64(len(m.deliveries) + 1),
+			WebhookID:     webhook.ID,
+			Event:         event,
+			Payload:       payload,
+			Status:        model.DeliveryPending,
+			NextAttemptAt: &next,
+			CreatedAt:     at,
+		})
+		n++
+	}
+	return n, nil
+}
+
+// ClaimWebhookDeliveries returns copies of the due deliveries, postponing them by lease
+func (m *MockDatabase) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*model.WebhookDelivery, error) {
+	var claimed []*model.WebhookDelivery
+	for _, delivery := range m.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		if delivery.Status != model.DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		leased := now.Add(lease)
+		delivery.NextAttemptAt = &leased
+
+		copied := *delivery
+		copied.Attempts = slices.Clone(delivery.Attempts)
+		for _, webhook := range m.webhooks {
+			if webhook.ID == delivery.WebhookID {
+				copied.URL, copied.Secret = webhook.URL, webhook.Secret
+			}
+		}
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+// RecordWebhookAttempt records an attempt of a delivery in the mock database
+func (m *MockDatabase) RecordWebhookAttempt(ctx context.Context, deliveryID int64, attempt model.WebhookAttempt, status model.WebhookDeliveryStatus, next time.Time) error {
+	for _, delivery := range m.deliveries {
+		if delivery.ID == deliveryID {
+			delivery.Attempts = append(delivery.Attempts, attempt)
+			delivery.Status = status
+			delivery.NextAttemptAt = nil
+			if status == model.DeliveryPending {
+				delivery.NextAttemptAt = &next
+			}
+		}
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a webhook, newest first
+func (m *MockDatabase) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*model.WebhookDelivery, error) {
+	if !slices.ContainsFunc(m.webhooks, func(webhook *model.Webhook) bool { return webhook.ID == webhookID }) {
+		return nil, &model.ErrWebhookNotFound{ID: webhookID}
+	}
+	deliveries := []*model.WebhookDelivery{}
+	for _, delivery := range slices.Backward(m.deliveries) {
+		if delivery.WebhookID == webhookID && len(deliveries) < limit {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+// DeleteWebhookDeliveries deletes the finished deliveries created before before
+func (m *MockDatabase) DeleteWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	n := len(m.deliveries)
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(delivery *model.WebhookDelivery) bool {
+		return delivery.Status != model.DeliveryPending && delivery.CreatedAt.Before(before)
+	})
+	return int64(n - len(m.deliveries)), nil
+}
+
+// Ready always succeeds for the mock database
+func (m *MockDatabase) Ready(ctx context.Context) error {
+	return nil
+}
+
 // Close is a no-op for the mock database
 func (m *MockDatabase) Close() error {
 	return nil
@@ -73,11 +401,12 @@ func (m *MockDatabase) Close() error {
 var _ database.DatabaseInterface = (*MockDatabase)(nil)
 
 func TestShortenURL(t *testing.T) {
+	ctx := context.Background()
 	mockDB := NewMockDatabase()
 	service := New(mockDB)
 
 	// Test with custom code
-	url, err := service.ShortenURL("https://example.com", "custom")
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "custom"})
 	if err != nil {
 		t.Fatalf("Failed to shorten URL: %v", err)
 	}
@@ -92,7 +421,7 @@ func TestShortenURL(t *testing.T) {
 	}
 
 	// Test without custom code
-	url, err = service.ShortenURL("https://example.org", "")
+	url, err = service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.org"})
 	if err != nil {
 		t.Fatalf("Failed to shorten URL: %v", err)
 	}
@@ -107,24 +436,25 @@ func TestShortenURL(t *testing.T) {
 	}
 
 	// Test duplicate custom code
-	_, err = service.ShortenURL("https://example.net", "custom")
+	_, err = service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.net", CustomCode: "custom"})
 	if err == nil {
 		t.Errorf("Expected error for duplicate custom code, got nil")
 	}
 }
 
 func TestGetURL(t *testing.T) {
+	ctx := context.Background()
 	mockDB := NewMockDatabase()
 	service := New(mockDB)
 
 	// Create a URL
-	_, err := service.ShortenURL("https://example.com", "test")
+	_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "test"})
 	if err != nil {
 		t.Fatalf("Failed to shorten URL: %v", err)
 	}
 
 	// Get the URL
-	url, err := service.GetURL("test")
+	url, err := service.GetURL(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -139,7 +469,7 @@ func TestGetURL(t *testing.T) {
 	}
 
 	// Get non-existent URL
-	url, err = service.GetURL("nonexistent")
+	url, err = service.GetURL(ctx, "nonexistent")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -149,47 +479,58 @@ func TestGetURL(t *testing.T) {
 }
 
 func TestListURLs(t *testing.T) {
+	ctx := context.Background()
 	mockDB := NewMockDatabase()
 	service := New(mockDB)
 
 	// Create some URLs
-	_, err := service.ShortenURL("https://example.com", "test1")
+	_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "test1"})
 	if err != nil {
 		t.Fatalf("Failed to shorten URL: %v", err)
 	}
-	_, err = service.ShortenURL("https://example.org", "test2")
+	_, err = service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.org", CustomCode: "test2", UTM: model.UTM{Source: "newsletter", Campaign: "spring"}})
 	if err != nil {
 		t.Fatalf("Failed to shorten URL: %v", err)
 	}
 
 	// List URLs
-	urls, err := service.ListURLs()
+	urls, err := service.ListURLs(ctx, model.URLFilter{})
 	if err != nil {
 		t.Fatalf("Failed to list URLs: %v", err)
 	}
 	if len(urls) != 2 {
 		t.Errorf("Expected 2 URLs, got %d", len(urls))
 	}
+
+	// List the URLs of a campaign, ignoring surrounding whitespace
+	urls, err = service.ListURLs(ctx, model.URLFilter{Campaign: " spring "})
+	if err != nil {
+		t.Fatalf("Failed to list URLs: %v", err)
+	}
+	if len(urls) != 1 || urls[0].ShortCode != "test2" {
+		t.Errorf("Expected only URL 'test2' of the campaign, got %+v", urls)
+	}
 }
 
 func TestDeleteURL(t *testing.T) {
+	ctx := context.Background()
 	mockDB := NewMockDatabase()
 	service := New(mockDB)
 
 	// Create a URL
-	_, err := service.ShortenURL("https://example.com", "test")
+	_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "test"})
 	if err != nil {
 		t.Fatalf("Failed to shorten URL: %v", err)
 	}
 
 	// Delete the URL
-	err = service.DeleteURL("test")
+	err = service.DeleteURL(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to delete URL: %v", err)
 	}
 
 	// Verify it's deleted
-	url, err := service.GetURL("test")
+	url, err := service.GetURL(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -199,23 +540,24 @@ func TestDeleteURL(t *testing.T) {
 }
 
 func TestRecordClick(t *testing.T) {
+	ctx := context.Background()
 	mockDB := NewMockDatabase()
 	service := New(mockDB)
 
 	// Create a URL
-	_, err := service.ShortenURL("https://example.com", "test")
+	_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "test"})
 	if err != nil {
 		t.Fatalf("Failed to shorten URL: %v", err)
 	}
 
 	// Record a click
-	err = service.RecordClick("test")
+	err = service.RecordClick(ctx, model.Click{ShortCode: "test"})
 	if err != nil {
 		t.Fatalf("Failed to record click: %v", err)
 	}
 
 	// Verify click was recorded
-	url, err := service.GetURL("test")
+	url, err := service.GetURL(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -224,13 +566,13 @@ func TestRecordClick(t *testing.T) {
 	}
 
 	// Record another click
-	err = service.RecordClick("test")
+	err = service.RecordClick(ctx, model.Click{ShortCode: "test"})
 	if err != nil {
 		t.Fatalf("Failed to record click: %v", err)
 	}
 
 	// Verify click was recorded
-	url, err = service.GetURL("test")
+	url, err = service.GetURL(ctx, "test")
 	if err != nil {
 		t.Fatalf("Failed to get URL: %v", err)
 	}
@@ -239,18 +581,19 @@ func TestRecordClick(t *testing.T) {
 	}
 
 	// Record click for non-existent URL
-	err = service.RecordClick("nonexistent")
+	err = service.RecordClick(ctx, model.Click{ShortCode: "nonexistent"})
 	if err == nil {
 		t.Errorf("Expected error when recording click for non-existent URL, got nil")
 	}
 }
 
 func TestGenerateQRCode(t *testing.T) {
+	ctx := context.Background()
 	mockDB := NewMockDatabase()
 	service := New(mockDB)
 
 	// Generate QR code
-	qrCode, err := service.GenerateQRCode("https://example.com")
+	qrCode, err := service.GenerateQRCode(ctx, "https://example.com")
 	if err != nil {
 		t.Fatalf("Failed to generate QR code: %v", err)
 	}
@@ -258,3 +601,1439 @@ func TestGenerateQRCode(t *testing.T) {
 		t.Errorf("Expected QR code to be generated, got empty byte slice")
 	}
 }
+
+func TestGetURLWithCache(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithCacheTTL(time.Minute))
+
+	// Create a URL
+	_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "test"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	// Populate the cache
+	if _, err := service.GetURL(ctx, "test"); err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+
+	// Change the stored URL behind the cache's back
+	mockDB.urls["test"].LongURL = "https://example.org"
+
+	// Verify the cached URL is returned
+	url, err := service.GetURL(ctx, "test")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if url.LongURL != "https://example.com" {
+		t.Errorf("Expected cached long URL 'https://example.com', got '%s'", url.LongURL)
+	}
+
+	// Verify deletion invalidates the cache
+	if err := service.DeleteURL(ctx, "test"); err != nil {
+		t.Fatalf("Failed to delete URL: %v", err)
+	}
+	url, err = service.GetURL(ctx, "test")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if url != nil {
+		t.Errorf("Expected URL to be nil after deletion, got %+v", url)
+	}
+}
+
+func TestClickQueue(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB)
+
+	// Create a URL
+	_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "test"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	// Queue some clicks and wait for them to be recorded
+	queue := NewClickQueue(service, 10, 1)
+	for i := 0; i < 3; i++ {
+		if !queue.Enqueue(ctx, model.Click{ShortCode: "test"}) {
+			t.Fatalf("Expected click to be queued")
+		}
+	}
+	queue.Close()
+
+	// Verify clicks were recorded
+	url, err := service.GetURL(ctx, "test")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if url.Clicks != 3 {
+		t.Errorf("Expected clicks to be 3, got %d", url.Clicks)
+	}
+	if queue.Len() != 0 {
+		t.Errorf("Expected empty queue, got %d pending clicks", queue.Len())
+	}
+}
+
+func TestShortenURLReservedCode(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	policy := shortcode.NewPolicy(shortcode.DefaultMinLength, shortcode.DefaultMaxLength)
+	policy.Reserve("healthz", "readyz")
+	service := New(mockDB, WithCodePolicy(policy))
+
+	// Reserved codes are rejected regardless of case
+	for _, code := range []string{"healthz", "ReadyZ"} {
+		_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: code})
+		var reserved *model.ErrReservedCode
+		if !errors.As(err, &reserved) {
+			t.Errorf("Expected reserved code error for '%s', got %v", code, err)
+		}
+	}
+
+	// Other codes are accepted
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "health"}); err != nil {
+		t.Errorf("Expected code 'health' to be accepted, got %v", err)
+	}
+}
+
+func TestShortenURLInvalidCode(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	policy := shortcode.NewPolicy(3, 10)
+	policy.Block("spam")
+	service := New(mockDB, WithCodePolicy(policy))
+
+	for _, code := range []string{"ab", "far-too-long-code", "has space", "a/b", "MySpamLink"} {
+		_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: code})
+		var invalid *model.ErrInvalidShortCode
+		if !errors.As(err, &invalid) {
+			t.Errorf("Expected invalid code error for '%s', got %v", code, err)
+		}
+	}
+}
+
+// fixedGenerator returns the given codes in order
+type fixedGenerator struct {
+	codes []string
+}
+
+// Generate returns the next fixed code
+func (g *fixedGenerator) Generate(ctx context.Context, length int) (string, error) {
+	code := g.codes[0]
+	g.codes = g.codes[1:]
+	return code, nil
+}
+
+func TestShortenURLCodeGenerator(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+
+	// Generated codes skip existing, reserved and blocked codes
+	policy := shortcode.NewPolicy(shortcode.DefaultMinLength, shortcode.DefaultMaxLength)
+	policy.Reserve("urls")
+	policy.Block("spam")
+	generator := &fixedGenerator{codes: []string{"taken", "urls", "spammy", "fresh"}}
+	service := New(mockDB, WithCodePolicy(policy), WithCodeGenerator(generator, 5))
+
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "taken"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.org"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if url.ShortCode != "fresh" {
+		t.Errorf("Expected short code 'fresh', got '%s'", url.ShortCode)
+	}
+
+	// Generation gives up when every attempt collides
+	generator.codes = []string{"taken", "taken", "taken", "taken", "taken", "taken", "taken", "taken", "taken", "taken"}
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.net"}); err == nil {
+		t.Errorf("Expected error when no unique code can be generated")
+	}
+}
+
+func TestShortenURLSequentialCodes(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	generator, err := shortcode.NewGenerator("sequential", shortcode.GeneratorConfig{
+		Next: func(ctx context.Context) (int64, error) {
+			return mockDB.NextSequence(ctx, "short_codes")
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	service := New(mockDB, WithCodeGenerator(generator, 6))
+
+	for _, expected := range []string{"b", "c", "d"} {
+		url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com"})
+		if err != nil {
+			t.Fatalf("Failed to shorten URL: %v", err)
+		}
+		if url.ShortCode != expected {
+			t.Errorf("Expected short code '%s', got '%s'", expected, url.ShortCode)
+		}
+	}
+}
+
+func TestShortenURLConcurrentCustomCode(t *testing.T) {
+	ctx := context.Background()
+
+	// Use a real database so uniqueness is enforced by SQLite
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	service := New(db)
+
+	// Hammer the same custom code from many goroutines
+	const workers = 50
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "contested"})
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	// Exactly one request wins, the others get a conflict instead of a raw database error
+	created := 0
+	for err := range errs {
+		var exists *model.ErrCustomCodeAlreadyExists
+		switch {
+		case err == nil:
+			created++
+		case !errors.As(err, &exists):
+			t.Errorf("Expected ErrCustomCodeAlreadyExists, got %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("Expected exactly 1 URL to be created, got %d", created)
+	}
+}
+
+func TestShortenURLDedupe(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithDedupe(true))
+
+	first, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://Example.com:443/page?b=2&a=1"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	// The same destination written differently returns the existing URL
+	second, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com/page?a=1&b=2"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if second.ShortCode != first.ShortCode {
+		t.Errorf("Expected existing short code '%s', got '%s'", first.ShortCode, second.ShortCode)
+	}
+
+	// Forcing a new URL or using a custom code creates a new one
+	forced, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com/page?a=1&b=2", ForceNew: true})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if forced.ShortCode == first.ShortCode {
+		t.Errorf("Expected a new short code with ForceNew, got '%s'", forced.ShortCode)
+	}
+	custom, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com/page?a=1&b=2", CustomCode: "custom"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if custom.ShortCode != "custom" {
+		t.Errorf("Expected custom short code, got '%s'", custom.ShortCode)
+	}
+
+	// Different destinations are not deduplicated
+	other, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com/other"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if other.ShortCode == first.ShortCode {
+		t.Errorf("Expected a new short code for a different destination")
+	}
+
+	// Without dedupe every request creates a new URL
+	service = New(mockDB)
+	again, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com/page?a=1&b=2"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if again.ShortCode == first.ShortCode {
+		t.Errorf("Expected a new short code without dedupe")
+	}
+}
+
+func TestHashDestinations(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithDedupe(true))
+
+	// A URL saved before destinations were hashed
+	old := model.NewURL("old", "https://example.com/page?b=2&a=1")
+	if err := mockDB.SaveURL(ctx, old); err != nil {
+		t.Fatalf("Failed to save URL: %v", err)
+	}
+
+	n, err := service.HashDestinations(ctx)
+	if err != nil {
+		t.Fatalf("Failed to hash destinations: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 hashed destination, got %d", n)
+	}
+
+	// Deduplication finds the hashed URL
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com/page?a=1&b=2"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if url.ShortCode != "old" {
+		t.Errorf("Expected existing short code 'old', got '%s'", url.ShortCode)
+	}
+
+	// Hashed URLs are not hashed again
+	if n, err := service.HashDestinations(ctx); err != nil || n != 0 {
+		t.Errorf("Expected no destinations to hash, got %d (%v)", n, err)
+	}
+}
+
+func TestShortenURLNormalizes(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB)
+
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{
+		LongURL:       "Example.COM:443/page?utm_source=news&id=7",
+		StripTracking: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if url.LongURL != "https://example.com/page?id=7" {
+		t.Errorf("Expected normalized URL, got '%s'", url.LongURL)
+	}
+
+	for _, longURL := range []string{"javascript:alert(1)", "ftp://example.com/", "http://localhost/", "http://10.0.0.1/"} {
+		_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: longURL})
+		var invalid *model.ErrInvalidURL
+		if !errors.As(err, &invalid) {
+			t.Errorf("Expected ErrInvalidURL for '%s', got %v", longURL, err)
+		}
+	}
+	if len(mockDB.urls) != 1 {
+		t.Errorf("Expected rejected URLs not to be saved, got %d URLs", len(mockDB.urls))
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	mockDB.AddBlockedDomain(ctx, "stored.example")
+
+	list := blocklist.New()
+	service := New(mockDB, WithBlocklist(list))
+	if err := service.LoadBlockedDomains(ctx); err != nil {
+		t.Fatalf("Failed to load blocked domains: %v", err)
+	}
+
+	// Domains stored by an administrator are blocked after loading
+	_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://www.stored.example/"})
+	var blocked *model.ErrBlockedURL
+	if !errors.As(err, &blocked) {
+		t.Errorf("Expected ErrBlockedURL, got %v", err)
+	}
+
+	// A link created before its destination is blocked is disabled afterwards
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://Phish.Example/login", CustomCode: "phish"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if err := service.CheckDestination(ctx, url.LongURL); err != nil {
+		t.Errorf("Expected destination to be allowed, got %v", err)
+	}
+	domain, err := service.BlockDomain(ctx, "PHISH.example.")
+	if err != nil {
+		t.Fatalf("Failed to block domain: %v", err)
+	}
+	if domain != "phish.example" {
+		t.Errorf("Expected normalized domain 'phish.example', got '%s'", domain)
+	}
+	if err := service.CheckDestination(ctx, url.LongURL); !errors.As(err, &blocked) {
+		t.Errorf("Expected ErrBlockedURL, got %v", err)
+	}
+	if _, exists := mockDB.blocked["phish.example"]; !exists {
+		t.Errorf("Expected blocked domain to be stored")
+	}
+
+	// Unblocking restores the link
+	if err := service.UnblockDomain(ctx, "phish.example"); err != nil {
+		t.Fatalf("Failed to unblock domain: %v", err)
+	}
+	if err := service.CheckDestination(ctx, url.LongURL); err != nil {
+		t.Errorf("Expected destination to be allowed after unblocking, got %v", err)
+	}
+
+	var invalid *model.ErrInvalidDomain
+	if _, err := service.BlockDomain(ctx, "not a domain"); !errors.As(err, &invalid) {
+		t.Errorf("Expected ErrInvalidDomain, got %v", err)
+	}
+}
+
+func TestShortenURLRedirectType(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithDedupe(true))
+
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", RedirectType: model.RedirectMeta})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if url.RedirectType != model.RedirectMeta {
+		t.Errorf("Expected redirect type 'meta', got '%s'", url.RedirectType)
+	}
+
+	// Deduplication only returns a URL redirecting the same way
+	other, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if other.ShortCode == url.ShortCode {
+		t.Errorf("Expected a new short code for a different redirect type")
+	}
+	same, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", RedirectType: model.RedirectMeta})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if same.ShortCode != url.ShortCode {
+		t.Errorf("Expected existing short code '%s', got '%s'", url.ShortCode, same.ShortCode)
+	}
+
+	_, err = service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", RedirectType: "303"})
+	var invalid *model.ErrInvalidRedirectType
+	if !errors.As(err, &invalid) {
+		t.Errorf("Expected ErrInvalidRedirectType, got %v", err)
+	}
+}
+
+func TestShortenURLPassthrough(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithDedupe(true))
+
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{
+		LongURL:          "https://example.com/docs",
+		PathPassthrough:  true,
+		QueryPassthrough: model.QueryPassthroughOverride,
+	})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if !url.PathPassthrough || url.QueryPassthrough != model.QueryPassthroughOverride {
+		t.Errorf("Expected passthrough options to be set, got %v and '%s'", url.PathPassthrough, url.QueryPassthrough)
+	}
+
+	// Deduplication only returns a URL with the same passthrough options
+	other, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com/docs"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if other.ShortCode == url.ShortCode {
+		t.Errorf("Expected a new short code for different passthrough options")
+	}
+
+	_, err = service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", QueryPassthrough: "merge"})
+	var invalid *model.ErrInvalidQueryPassthrough
+	if !errors.As(err, &invalid) {
+		t.Errorf("Expected ErrInvalidQueryPassthrough, got %v", err)
+	}
+}
+
+func TestShortenURLWithUTM(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB)
+
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{
+		LongURL: "https://example.com/sale?utm_source=old&ref=1",
+		UTM:     model.UTM{Source: " newsletter ", Medium: "email", Campaign: "spring sale"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	expected := "https://example.com/sale?ref=1&utm_campaign=spring+sale&utm_medium=email&utm_source=newsletter"
+	if url.LongURL != expected {
+		t.Errorf("Expected long URL '%s', got '%s'", expected, url.LongURL)
+	}
+	if url.UTM != (model.UTM{Source: "newsletter", Medium: "email", Campaign: "spring sale"}) {
+		t.Errorf("Expected campaign parameters to be stored, got %+v", url.UTM)
+	}
+
+	tests := []struct {
+		name  string
+		utm   model.UTM
+		param string
+	}{
+		{"MissingSource", model.UTM{Campaign: "spring"}, "utm_source"},
+		{"TooLong", model.UTM{Source: strings.Repeat("a", maxUTMLength+1)}, "utm_source"},
+		{"ControlCharacter", model.UTM{Source: "news", Term: "a\nb"}, "utm_term"},
+		{"FirstInvalid", model.UTM{Source: "news", Medium: "a\nb", Term: "c\nd", Content: "e\nf"}, "utm_medium"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", UTM: tt.utm})
+			var invalid *model.ErrInvalidUTM
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Expected ErrInvalidUTM, got %v", err)
+			}
+			if invalid.Param != tt.param {
+				t.Errorf("Expected invalid parameter '%s', got '%s'", tt.param, invalid.Param)
+			}
+		})
+	}
+}
+
+func TestShortenURLVariants(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithDedupe(true))
+
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{
+		Variants: []model.Variant{
+			{LongURL: "example.com/a", Weight: 3},
+			{LongURL: "https://example.com/b"},
+		},
+		StickyVariants: true,
+		UTM:            model.UTM{Source: "test"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if len(url.Variants) != 2 || !url.StickyVariants {
+		t.Fatalf("Expected 2 sticky variants, got %+v", url)
+	}
+	a, b := url.Variants[0], url.Variants[1]
+	if a.Name != "a" || a.LongURL != "https://example.com/a?utm_source=test" || a.Weight != 3 {
+		t.Errorf("Expected normalized variant a, got %+v", a)
+	}
+	if b.Name != "b" || b.Weight != 1 {
+		t.Errorf("Expected variant b with default weight, got %+v", b)
+	}
+	if url.LongURL != a.LongURL || url.DestHash != "" {
+		t.Errorf("Expected the first variant as destination and no destination hash, got '%s' and '%s'", url.LongURL, url.DestHash)
+	}
+
+	// Default names skip the names given explicitly
+	url, err = service.ShortenURL(ctx, model.ShortenRequest{
+		Variants: []model.Variant{
+			{LongURL: "https://example.com/a"},
+			{Name: "a", LongURL: "https://example.com/b"},
+			{LongURL: "https://example.com/c"},
+			{Name: "c", LongURL: "https://example.com/d"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL with named variants: %v", err)
+	}
+	var names []string
+	for _, variant := range url.Variants {
+		names = append(names, variant.Name)
+	}
+	if want := []string{"b", "a", "d", "c"}; !slices.Equal(names, want) {
+		t.Errorf("Expected variant names %v, got %v", want, names)
+	}
+
+	tests := []struct {
+		name string
+		req  model.ShortenRequest
+	}{
+		{"SingleVariant", model.ShortenRequest{Variants: []model.Variant{{LongURL: "https://example.com"}}}},
+		{"WithLongURL", model.ShortenRequest{LongURL: "https://example.com", Variants: []model.Variant{{LongURL: "https://example.com/a"}, {LongURL: "https://example.com/b"}}}},
+		{"DuplicateName", model.ShortenRequest{Variants: []model.Variant{{Name: "x", LongURL: "https://example.com/a"}, {Name: "x", LongURL: "https://example.com/b"}}}},
+		{"InvalidName", model.ShortenRequest{Variants: []model.Variant{{Name: "a b", LongURL: "https://example.com/a"}, {LongURL: "https://example.com/b"}}}},
+		{"NegativeWeight", model.ShortenRequest{Variants: []model.Variant{{LongURL: "https://example.com/a", Weight: -1}, {LongURL: "https://example.com/b"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.ShortenURL(ctx, tt.req)
+			var invalid *model.ErrInvalidVariants
+			if !errors.As(err, &invalid) {
+				t.Errorf("Expected ErrInvalidVariants, got %v", err)
+			}
+		})
+	}
+
+	// Variant destinations are validated like any destination
+	_, err = service.ShortenURL(ctx, model.ShortenRequest{Variants: []model.Variant{{LongURL: "https://example.com/a"}, {LongURL: "javascript:alert(1)"}}})
+	var invalidURL *model.ErrInvalidURL
+	if !errors.As(err, &invalidURL) {
+		t.Errorf("Expected ErrInvalidURL, got %v", err)
+	}
+}
+
+func TestChooseVariant(t *testing.T) {
+	url := &model.URL{
+		Variants: []model.Variant{
+			{Name: "a", Weight: 3},
+			{Name: "b", Weight: 1},
+			{Name: "off", Weight: 0},
+		},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[ChooseVariant(url, "b").Name]++
+	}
+	if counts["off"] != 0 {
+		t.Errorf("Expected variant without weight to never be chosen, got %d", counts["off"])
+	}
+	if counts["a"] < 2700 || counts["a"] > 3300 {
+		t.Errorf("Expected variant a about 3000 times, got %d", counts["a"])
+	}
+
+	// Sticky variants serve the previous variant when it still exists
+	url.StickyVariants = true
+	if variant := ChooseVariant(url, "b"); variant.Name != "b" {
+		t.Errorf("Expected previous variant 'b', got '%s'", variant.Name)
+	}
+	if variant := ChooseVariant(url, "gone"); variant == nil || variant.Name == "gone" {
+		t.Errorf("Expected a weighted variant for an unknown previous variant, got %+v", variant)
+	}
+
+	if variant := ChooseVariant(&model.URL{}, ""); variant != nil {
+		t.Errorf("Expected no variant for a URL without variants, got %+v", variant)
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithCacheTTL(time.Minute))
+
+	_, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "app"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	// Fill the cache
+	if _, err := service.GetURL(ctx, "app"); err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+
+	url, err := service.SetRules(ctx, "app", []model.Rule{
+		{LongURL: "apps.apple.com/app/id1", OS: []string{" iOS "}, Languages: []string{"pt-BR"}, Countries: []string{" br "}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set rules: %v", err)
+	}
+	rule := url.Rules[0]
+	if rule.LongURL != "https://apps.apple.com/app/id1" || rule.OS[0] != "ios" || rule.Languages[0] != "pt-br" || rule.Countries[0] != "BR" {
+		t.Errorf("Expected a normalized rule, got %+v", rule)
+	}
+
+	cached, err := service.GetURL(ctx, "app")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if len(cached.Rules) != 1 {
+		t.Errorf("Expected the cache to be invalidated, got %+v", cached.Rules)
+	}
+
+	tests := []struct {
+		name string
+		rule model.Rule
+	}{
+		{"NoCondition", model.Rule{LongURL: "https://example.com"}},
+		{"UnknownOS", model.Rule{LongURL: "https://example.com", OS: []string{"beos"}}},
+		{"UnknownDevice", model.Rule{LongURL: "https://example.com", Devices: []string{"watch"}}},
+		{"InvalidLanguage", model.Rule{LongURL: "https://example.com", Languages: []string{"english!"}}},
+		{"InvalidCountry", model.Rule{LongURL: "https://example.com", Countries: []string{"DEU"}}},
+		{"InvalidTime", model.Rule{LongURL: "https://example.com", After: "25:00"}},
+		{"UnknownTimeZone", model.Rule{LongURL: "https://example.com", After: "09:00", TimeZone: "Mars/Olympus"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.SetRules(ctx, "app", []model.Rule{tt.rule})
+			var invalid *model.ErrInvalidRule
+			if !errors.As(err, &invalid) || invalid.Index != 1 {
+				t.Errorf("Expected ErrInvalidRule for rule 1, got %v", err)
+			}
+		})
+	}
+
+	var notFound *model.ErrURLNotFound
+	if _, err := service.SetRules(ctx, "nonexistent", nil); !errors.As(err, &notFound) {
+		t.Errorf("Expected ErrURLNotFound, got %v", err)
+	}
+
+	// Rules given on creation and set later strip tracking parameters like the destination
+	rules := []model.Rule{{LongURL: "https://example.com/ios?fbclid=abc&id=1", OS: []string{"ios"}}}
+	for _, strip := range []bool{false, true} {
+		want := rules[0].LongURL
+		if strip {
+			want = "https://example.com/ios?id=1"
+		}
+
+		url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", StripTracking: strip, Rules: rules})
+		if err != nil {
+			t.Fatalf("Failed to shorten URL: %v", err)
+		}
+		if got := url.Rules[0].LongURL; got != want {
+			t.Errorf("Expected the rule destination '%s' on creation with strip tracking %v, got '%s'", want, strip, got)
+		}
+
+		url, err = service.SetRules(ctx, url.ShortCode, rules)
+		if err != nil {
+			t.Fatalf("Failed to set rules: %v", err)
+		}
+		if got := url.Rules[0].LongURL; got != want {
+			t.Errorf("Expected the rule destination '%s' with strip tracking %v, got '%s'", want, strip, got)
+		}
+	}
+}
+
+func TestSetSchedule(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithCacheTTL(time.Minute))
+
+	launch := time.Date(2026, 9, 1, 9, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	end := launch.Add(30 * 24 * time.Hour)
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{
+		LongURL:    "https://example.com/teaser",
+		CustomCode: "launch",
+		Schedule:   model.Schedule{ActiveFrom: &launch},
+	})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if !url.Schedule.ActiveFrom.Equal(launch) || url.Schedule.ActiveFrom.Location() != time.UTC {
+		t.Errorf("Expected the activation time in UTC, got %v", url.Schedule.ActiveFrom)
+	}
+	// Fill the cache
+	if _, err := service.GetURL(ctx, "launch"); err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+
+	url, err = service.SetSchedule(ctx, "launch", model.Schedule{
+		ActiveFrom:  &launch,
+		ActiveUntil: &end,
+		Changes: []model.ScheduledChange{
+			{At: launch.Add(48 * time.Hour), LongURL: "example.com/sale"},
+			{At: launch, LongURL: "example.com/product"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set schedule: %v", err)
+	}
+	changes := url.Schedule.Changes
+	if len(changes) != 2 || changes[0].LongURL != "https://example.com/product" || changes[1].LongURL != "https://example.com/sale" {
+		t.Errorf("Expected normalized changes ordered by time, got %+v", changes)
+	}
+
+	cached, err := service.GetURL(ctx, "launch")
+	if err != nil {
+		t.Fatalf("Failed to get URL: %v", err)
+	}
+	if len(cached.Schedule.Changes) != 2 {
+		t.Errorf("Expected the cache to be invalidated, got %+v", cached.Schedule)
+	}
+
+	// The destination follows the changes that took effect
+	for _, tt := range []struct {
+		at   time.Time
+		want string
+	}{
+		{launch.Add(-time.Minute), "https://example.com/teaser"},
+		{launch, "https://example.com/product"},
+		{launch.Add(72 * time.Hour), "https://example.com/sale"},
+	} {
+		if got := cached.DestinationAt(tt.at); got != tt.want {
+			t.Errorf("Expected destination '%s' at %v, got '%s'", tt.want, tt.at, got)
+		}
+	}
+	if !cached.Schedule.Pending(launch.Add(-time.Second)) || cached.Schedule.Pending(launch) {
+		t.Errorf("Expected the URL to be pending only before %v", launch)
+	}
+	if cached.Schedule.Ended(end.Add(-time.Second)) || !cached.Schedule.Ended(end) {
+		t.Errorf("Expected the URL to end at %v", end)
+	}
+
+	// Changes set later strip tracking parameters like those set on creation
+	schedule := model.Schedule{Changes: []model.ScheduledChange{{At: launch, LongURL: "https://example.com/sale?fbclid=abc&id=1"}}}
+	for _, strip := range []bool{false, true} {
+		url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", StripTracking: strip, Schedule: schedule})
+		if err != nil {
+			t.Fatalf("Failed to shorten URL: %v", err)
+		}
+		url, err = service.SetSchedule(ctx, url.ShortCode, schedule)
+		if err != nil {
+			t.Fatalf("Failed to set schedule: %v", err)
+		}
+		want := schedule.Changes[0].LongURL
+		if strip {
+			want = "https://example.com/sale?id=1"
+		}
+		if got := url.Schedule.Changes[0].LongURL; got != want {
+			t.Errorf("Expected the scheduled destination '%s' with strip tracking %v, got '%s'", want, strip, got)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		schedule model.Schedule
+	}{
+		{"UntilBeforeFrom", model.Schedule{ActiveFrom: &end, ActiveUntil: &launch}},
+		{"NoTime", model.Schedule{Changes: []model.ScheduledChange{{LongURL: "https://example.com"}}}},
+		{"SameTime", model.Schedule{Changes: []model.ScheduledChange{
+			{At: launch, LongURL: "https://example.com/a"},
+			{At: launch.UTC(), LongURL: "https://example.com/b"},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.SetSchedule(ctx, "launch", tt.schedule)
+			var invalid *model.ErrInvalidSchedule
+			if !errors.As(err, &invalid) {
+				t.Errorf("Expected ErrInvalidSchedule, got %v", err)
+			}
+		})
+	}
+
+	var invalidURL *model.ErrInvalidURL
+	_, err = service.SetSchedule(ctx, "launch", model.Schedule{Changes: []model.ScheduledChange{{At: launch, LongURL: "ftp://example.com"}}})
+	if !errors.As(err, &invalidURL) {
+		t.Errorf("Expected ErrInvalidURL for an invalid destination, got %v", err)
+	}
+
+	var notFound *model.ErrURLNotFound
+	if _, err := service.SetSchedule(ctx, "nonexistent", model.Schedule{}); !errors.As(err, &notFound) {
+		t.Errorf("Expected ErrURLNotFound, got %v", err)
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	url := &model.URL{
+		Rules: []model.Rule{
+			{LongURL: "ios", OS: []string{"ios"}},
+			{LongURL: "android", OS: []string{"android"}},
+			{LongURL: "german", Languages: []string{"de"}, Devices: []string{"desktop"}},
+			{LongURL: "dach", Countries: []string{"DE", "AT", "CH"}},
+			{LongURL: "night", After: "22:00", Before: "06:00", TimeZone: "Europe/Berlin"},
+		},
+	}
+	noon := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	// 23:30 in Berlin during summer time
+	night := time.Date(2026, 6, 1, 21, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		visitor Visitor
+		want    string
+	}{
+		{"IOS", Visitor{Agent: useragent.Agent{OS: "ios", Device: "mobile"}, Time: noon}, "ios"},
+		{"Android", Visitor{Agent: useragent.Agent{OS: "android", Device: "tablet"}, Time: night}, "android"},
+		{"GermanDesktop", Visitor{Agent: useragent.Agent{OS: "windows", Device: "desktop"}, Language: "de-AT", Time: noon}, "german"},
+		{"GermanLanguageOnly", Visitor{Agent: useragent.Agent{OS: "linux"}, Language: "de", Time: noon}, ""},
+		{"NotGerman", Visitor{Agent: useragent.Agent{OS: "windows", Device: "desktop"}, Language: "den", Time: noon}, ""},
+		{"Austria", Visitor{Agent: useragent.Agent{OS: "macos", Device: "desktop"}, Country: "AT", Time: night}, "dach"},
+		{"Night", Visitor{Agent: useragent.Agent{OS: "macos", Device: "desktop"}, Country: "FR", Time: night}, "night"},
+		{"Fallback", Visitor{Time: noon}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if rule := MatchRule(url, tt.visitor); rule != nil {
+				got = rule.LongURL
+			}
+			if got != tt.want {
+				t.Errorf("Expected rule '%s', got '%s'", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB)
+
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "stats"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	day := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	for _, at := range []time.Duration{2 * time.Hour, 2*time.Hour + 30*time.Minute, 26 * time.Hour} {
+		if err := service.RecordClick(ctx, model.Click{ShortCode: "stats", ClickedAt: day.Add(at)}); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	// Two days default to an hourly series with empty buckets filled in
+	stats, err := service.GetStats(ctx, "stats", day.Add(30*time.Minute), day.Add(48*time.Hour), "")
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Interval != model.StatsHourly || len(stats.Series) != 48 || !stats.Series[0].Time.Equal(day) {
+		t.Fatalf("Expected 48 hourly buckets from %v, got %s with %d buckets", day, stats.Interval, len(stats.Series))
+	}
+	if stats.Series[2].Clicks != 2 || stats.Series[26].Clicks != 1 || stats.Series[3].Clicks != 0 || stats.Total != 3 {
+		t.Errorf("Expected clicks in the buckets of hours 2 and 26, got %+v", stats.Series)
+	}
+
+	stats, err = service.GetStats(ctx, "stats", day, day.Add(7*24*time.Hour), "")
+	if err != nil {
+		t.Fatalf("Failed to get stats: %v", err)
+	}
+	if stats.Interval != model.StatsDaily || len(stats.Series) != 7 || stats.Series[0].Clicks != 2 || stats.Series[1].Clicks != 1 {
+		t.Errorf("Expected 7 daily buckets, got %s %+v", stats.Interval, stats.Series)
+	}
+
+	tests := []struct {
+		name     string
+		from     time.Time
+		until    time.Time
+		interval model.StatsInterval
+	}{
+		{"Reversed", day, day.Add(-time.Hour), ""},
+		{"TooLong", day, day.Add(400 * 24 * time.Hour), model.StatsDaily},
+		{"HourlyTooLong", day, day.Add(40 * 24 * time.Hour), model.StatsHourly},
+		{"UnknownInterval", day, day.Add(time.Hour), "minute"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.GetStats(ctx, "stats", tt.from, tt.until, tt.interval)
+			var invalid *model.ErrInvalidStatsRange
+			if !errors.As(err, &invalid) {
+				t.Errorf("Expected ErrInvalidStatsRange, got %v", err)
+			}
+		})
+	}
+
+	var notFound *model.ErrURLNotFound
+	if _, err := service.GetStats(ctx, "missing", day, day.Add(time.Hour), ""); !errors.As(err, &notFound) {
+		t.Errorf("Expected ErrURLNotFound, got %v", err)
+	}
+}
+
+func TestRecordClickUniques(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB)
+
+	for _, code := range []string{"uniques", "other"} {
+		if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: code}); err != nil {
+			t.Fatalf("Failed to shorten URL: %v", err)
+		}
+	}
+
+	day := time.Date(2026, 5, 4, 10, 0, 0, 0, time.UTC)
+	clicks := []model.Click{
+		{ShortCode: "uniques", IP: "192.0.2.1", UserAgent: "Firefox", ClickedAt: day},
+		{ShortCode: "uniques", IP: "192.0.2.1", UserAgent: "Firefox", ClickedAt: day.Add(time.Hour)},
+		// Another browser on the same address is another visitor
+		{ShortCode: "uniques", IP: "192.0.2.1", UserAgent: "Chrome", ClickedAt: day.Add(2 * time.Hour)},
+		// Bots are no visitors
+		{ShortCode: "uniques", IP: "192.0.2.2", UserAgent: "curl/8.5.0", Bot: true, ClickedAt: day},
+		// The same visitor counts again on the next day and on other URLs
+		{ShortCode: "uniques", IP: "192.0.2.1", UserAgent: "Firefox", ClickedAt: day.Add(24 * time.Hour)},
+		{ShortCode: "other", IP: "192.0.2.1", UserAgent: "Firefox", ClickedAt: day},
+	}
+	for _, click := range clicks {
+		if err := service.RecordClick(ctx, click); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	if url := mockDB.urls["uniques"]; url.Clicks != 4 || url.Uniques != 3 {
+		t.Errorf("Expected 4 clicks by 3 unique visitors, got %d by %d", url.Clicks, url.Uniques)
+	}
+	if url := mockDB.urls["other"]; url.Uniques != 1 {
+		t.Errorf("Expected 1 unique visitor of the other URL, got %d", url.Uniques)
+	}
+
+	for _, click := range mockDB.clicks {
+		if click.IP != "" {
+			t.Errorf("Expected the IP address not to be stored by default, got %+v", click)
+		}
+		if (click.Visitor == "") != click.Bot {
+			t.Errorf("Expected a visitor hash for every click by a person, got %+v", click)
+		}
+	}
+	if first, other := mockDB.clicks[0].Visitor, mockDB.clicks[5].Visitor; first == other || len(first) != 32 {
+		t.Errorf("Expected different 32 character hashes on different URLs, got %q and %q", first, other)
+	}
+
+	// The salt is loaded once per day
+	if mockDB.saltLoads != 3 {
+		t.Errorf("Expected 3 salt loads for the days of the clicks, got %d", mockDB.saltLoads)
+	}
+}
+
+func TestRecordClickPrivacy(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		opts       []Option
+		ip         string
+		doNotTrack bool
+		wantIP     string
+		wantAgent  string
+		wantHash   bool
+	}{
+		{"Default", nil, "192.0.2.17", false, "", "Firefox", true},
+		{"Anonymize", []Option{WithIPMode(IPModeAnonymize)}, "192.0.2.17", false, "192.0.2.0", "Firefox", true},
+		{"AnonymizeIPv6", []Option{WithIPMode(IPModeAnonymize)}, "2001:db8:1234:5678::1", false, "2001:db8:1234::", "Firefox", true},
+		{"AnonymizeMapped", []Option{WithIPMode(IPModeAnonymize)}, "::ffff:192.0.2.17", false, "192.0.2.0", "Firefox", true},
+		{"Full", []Option{WithIPMode(IPModeFull)}, "192.0.2.17", false, "192.0.2.17", "Firefox", true},
+		{"DoNotTrack", []Option{WithIPMode(IPModeFull)}, "192.0.2.17", true, "", "", false},
+		{"DoNotTrackIgnored", []Option{WithIPMode(IPModeFull), WithDoNotTrack(false)}, "192.0.2.17", true, "192.0.2.17", "Firefox", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := NewMockDatabase()
+			service := New(mockDB, tt.opts...)
+			if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "privacy"}); err != nil {
+				t.Fatalf("Failed to shorten URL: %v", err)
+			}
+
+			click := model.Click{ShortCode: "privacy", IP: tt.ip, UserAgent: "Firefox", DoNotTrack: tt.doNotTrack, ClickedAt: now}
+			if err := service.RecordClick(ctx, click); err != nil {
+				t.Fatalf("Failed to record click: %v", err)
+			}
+
+			stored := mockDB.clicks[0]
+			if stored.IP != tt.wantIP || stored.UserAgent != tt.wantAgent || (stored.Visitor != "") != tt.wantHash {
+				t.Errorf("Expected IP %q, User-Agent %q and a visitor hash %v, got %+v", tt.wantIP, tt.wantAgent, tt.wantHash, stored)
+			}
+			if url := mockDB.urls["privacy"]; url.Clicks != 1 {
+				t.Errorf("Expected the click to be counted, got %d clicks", url.Clicks)
+			}
+		})
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithIPMode(IPModeFull), WithRetention(Retention{
+		VisitorData: 7 * 24 * time.Hour,
+		Clicks:      30 * 24 * time.Hour,
+	}))
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "retention"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	now := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+	for _, age := range []time.Duration{
+		time.Hour,                 // kept
+		10 * 24 * time.Hour,       // visitor data erased
+		30*24*time.Hour + 1,       // day not yet over the retention period
+		31 * 24 * time.Hour,       // rolled up
+		45*24*time.Hour + 1,       // rolled up
+		45*24*time.Hour + 2*60*60, // rolled up on the same day
+	} {
+		click := model.Click{ShortCode: "retention", IP: "192.0.2.1", UserAgent: "Firefox", ClickedAt: now.Add(-age)}
+		if err := service.RecordClick(ctx, click); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+
+	if err := service.ApplyRetention(ctx, now, slog.New(slog.DiscardHandler)); err != nil {
+		t.Fatalf("Failed to apply retention: %v", err)
+	}
+
+	if len(mockDB.clicks) != 3 {
+		t.Fatalf("Expected 3 clicks to be kept, got %d", len(mockDB.clicks))
+	}
+	if got := mockDB.clicks[0]; got.IP != "192.0.2.1" || got.UserAgent != "Firefox" {
+		t.Errorf("Expected the visitor data of the recent click to be kept, got %+v", got)
+	}
+	for _, got := range mockDB.clicks[1:] {
+		if got.IP != "" || got.UserAgent != "" {
+			t.Errorf("Expected the visitor data of older clicks to be erased, got %+v", got)
+		}
+	}
+	want := []time.Time{
+		time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2026, 5, 15, 0, 0, 0, 0, time.UTC),
+	}
+	if !slices.EqualFunc(mockDB.rolledUp, want, time.Time.Equal) {
+		t.Errorf("Expected clicks rolled up on %v, got %v", want, mockDB.rolledUp)
+	}
+
+	// Without retention periods nothing is changed
+	if err := New(mockDB).ApplyRetention(ctx, now.AddDate(1, 0, 0), slog.New(slog.DiscardHandler)); err != nil {
+		t.Fatalf("Failed to apply retention: %v", err)
+	}
+	if len(mockDB.clicks) != 3 {
+		t.Errorf("Expected no clicks to be rolled up, got %d left", len(mockDB.clicks))
+	}
+}
+
+func TestCompactRollups(t *testing.T) {
+	ctx := context.Background()
+	mockDB := NewMockDatabase()
+	service := New(mockDB)
+
+	// Hourly rollups are kept for whole days
+	now := time.Date(2026, 5, 4, 15, 30, 0, 0, time.UTC)
+	if _, err := service.CompactRollups(ctx, now, 48*time.Hour); err != nil {
+		t.Fatalf("Failed to compact rollups: %v", err)
+	}
+	if want := time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC); !mockDB.compactedBefore.Equal(want) {
+		t.Errorf("Expected hourly rollups before %v to be deleted, got %v", want, mockDB.compactedBefore)
+	}
+
+	if _, err := service.CompactRollups(ctx, now, -time.Hour); err == nil {
+		t.Error("Expected an error for a negative period")
+	}
+}
+
+// webhookReceiver records the webhook requests it receives and answers them
+// with the given status codes in turn, repeating the last one
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+// ServeHTTP records a request and answers it with the next status code
+func (rr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	rr.requests = append(rr.requests, r)
+	rr.bodies = append(rr.bodies, body)
+	status := rr.statuses[min(len(rr.requests), len(rr.statuses))-1]
+	w.WriteHeader(status)
+}
+
+// withPrivateTargets lets webhooks reach the receivers of the tests on the loopback address
+func withPrivateTargets() Option {
+	return WithURLNormalizer(urlnorm.New(urlnorm.Config{AllowPrivate: true}))
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	receiver := &webhookReceiver{statuses: []int{http.StatusInternalServerError, http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithWebhookRetry(WebhookRetry{Attempts: 3, Backoff: time.Minute}), withPrivateTargets())
+
+	// Webhooks need an http URL and known events
+	invalid := []struct {
+		url    string
+		events []model.WebhookEvent
+	}{
+		{"ftp://example.com/hook", []model.WebhookEvent{model.EventLinkCreated}},
+		{"/hook", []model.WebhookEvent{model.EventLinkCreated}},
+		{server.URL, nil},
+		{server.URL, []model.WebhookEvent{"link.updated"}},
+	}
+	for _, tc := range invalid {
+		var invalidErr *model.ErrInvalidWebhook
+		if _, err := service.CreateWebhook(ctx, tc.url, tc.events, ""); !errors.As(err, &invalidErr) {
+			t.Errorf("Expected ErrInvalidWebhook for %s %v, got %v", tc.url, tc.events, err)
+		}
+	}
+
+	created, err := service.CreateWebhook(ctx, server.URL+"/crm", []model.WebhookEvent{model.EventLinkCreated, model.EventLinkClicked, model.EventLinkCreated}, "s3cret")
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if len(created.Events) != 2 || created.Secret != "s3cret" {
+		t.Errorf("Expected 2 events and the given secret, got %+v", created)
+	}
+	deleted, err := service.CreateWebhook(ctx, server.URL+"/archive", []model.WebhookEvent{model.EventLinkDeleted}, "")
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if len(deleted.Secret) != 64 {
+		t.Errorf("Expected a generated secret, got '%s'", deleted.Secret)
+	}
+
+	// Secrets are only shown on creation
+	webhooks, err := service.ListWebhooks(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list webhooks: %v", err)
+	}
+	if len(webhooks) != 2 || webhooks[0].Secret != "" || webhooks[1].Secret != "" {
+		t.Errorf("Expected 2 webhooks without secrets, got %+v", webhooks)
+	}
+
+	// Link events are queued for the subscribed webhooks, bot visits are not sent
+	url, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com", CustomCode: "hooked"})
+	if err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	clicks := []model.Click{
+		{ShortCode: url.ShortCode, Bot: true},
+		{ShortCode: url.ShortCode, Browser: "firefox", IP: "192.0.2.1", UserAgent: "Firefox"},
+	}
+	for _, click := range clicks {
+		if err := service.RecordClick(ctx, click); err != nil {
+			t.Fatalf("Failed to record click: %v", err)
+		}
+	}
+	if err := service.DeleteURL(ctx, url.ShortCode); err != nil {
+		t.Fatalf("Failed to delete URL: %v", err)
+	}
+	if len(mockDB.deliveries) != 3 {
+		t.Fatalf("Expected 3 deliveries, got %d", len(mockDB.deliveries))
+	}
+
+	n, err := service.DeliverWebhooks(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to deliver webhooks: %v", err)
+	}
+	if n != 3 || len(receiver.requests) != 3 {
+		t.Fatalf("Expected 3 deliveries to be attempted, got %d and %d requests", n, len(receiver.requests))
+	}
+
+	// Requests are signed with the secret of their webhook
+	events := []model.WebhookEvent{model.EventLinkCreated, model.EventLinkClicked, model.EventLinkDeleted}
+	secrets := []string{"s3cret", "s3cret", deleted.Secret}
+	for i, r := range receiver.requests {
+		timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+		if err != nil {
+			t.Fatalf("Expected a timestamp, got '%s'", r.Header.Get(WebhookTimestampHeader))
+		}
+		if got := r.Header.Get(WebhookSignatureHeader); got != WebhookSignature(secrets[i], timestamp, receiver.bodies[i]) {
+			t.Errorf("Expected a valid signature of request %d, got '%s'", i, got)
+		}
+		if got := r.Header.Get(WebhookEventHeader); got != string(events[i]) {
+			t.Errorf("Expected the event %s, got %s", events[i], got)
+		}
+
+		var payload model.WebhookPayload
+		if err := json.Unmarshal(receiver.bodies[i], &payload); err != nil {
+			t.Fatalf("Failed to decode payload: %v", err)
+		}
+		if payload.Event != events[i] {
+			t.Errorf("Expected the payload of %s, got %s", events[i], payload.Event)
+		}
+	}
+	if strings.Contains(string(receiver.bodies[1]), "192.0.2.1") || !strings.Contains(string(receiver.bodies[1]), `"browser":"firefox"`) {
+		t.Errorf("Expected the click without visitor data, got %s", receiver.bodies[1])
+	}
+
+	// The first request failed and is retried after the backoff
+	log, err := service.ListWebhookDeliveries(ctx, created.ID, 0)
+	if err != nil {
+		t.Fatalf("Failed to list deliveries: %v", err)
+	}
+	if len(log) != 2 || log[1].Status != model.DeliveryPending || log[0].Status != model.DeliveryDelivered {
+		t.Fatalf("Expected a pending and a delivered delivery, got %+v", log)
+	}
+	retry := log[1]
+	if len(retry.Attempts) != 1 || retry.Attempts[0].StatusCode != http.StatusInternalServerError || retry.Attempts[0].Error == "" {
+		t.Errorf("Expected a failed attempt, got %+v", retry.Attempts)
+	}
+	if retry.NextAttemptAt == nil || retry.NextAttemptAt.Before(retry.Attempts[0].AttemptedAt.Add(time.Minute)) {
+		t.Errorf("Expected a retry after a minute, got %v", retry.NextAttemptAt)
+	}
+
+	if n, err := service.DeliverWebhooks(ctx, time.Now()); err != nil || n != 0 {
+		t.Errorf("Expected no deliveries before the retry is due, got %d, %v", n, err)
+	}
+	if n, err := service.DeliverWebhooks(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("Expected the retry to be attempted, got %d, %v", n, err)
+	}
+	if retry.Status != model.DeliveryDelivered || len(retry.Attempts) != 2 || retry.NextAttemptAt != nil {
+		t.Errorf("Expected the retry to be delivered, got %+v", retry)
+	}
+
+	// Deleting a webhook stops its deliveries
+	if err := service.DeleteWebhook(ctx, deleted.ID); err != nil {
+		t.Fatalf("Failed to delete webhook: %v", err)
+	}
+	var notFound *model.ErrWebhookNotFound
+	if _, err := service.ListWebhookDeliveries(ctx, deleted.ID, 0); !errors.As(err, &notFound) {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	ctx := context.Background()
+	receiver := &webhookReceiver{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithWebhookRetry(WebhookRetry{Attempts: 3, Backoff: time.Minute}), withPrivateTargets())
+
+	webhook, err := service.CreateWebhook(ctx, server.URL, []model.WebhookEvent{model.EventLinkCreated}, "")
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	// Deliveries fail after their last attempt
+	now := time.Now()
+	for range 5 {
+		if _, err := service.DeliverWebhooks(ctx, now); err != nil {
+			t.Fatalf("Failed to deliver webhooks: %v", err)
+		}
+		now = now.Add(time.Hour)
+	}
+	log, err := service.ListWebhookDeliveries(ctx, webhook.ID, 10)
+	if err != nil {
+		t.Fatalf("Failed to list deliveries: %v", err)
+	}
+	if len(log) != 1 || log[0].Status != model.DeliveryFailed || len(log[0].Attempts) != 3 {
+		t.Errorf("Expected a delivery failed after 3 attempts, got %+v", log)
+	}
+	if len(receiver.requests) != 3 {
+		t.Errorf("Expected 3 requests, got %d", len(receiver.requests))
+	}
+
+	// The backoff doubles up to six hours
+	backoffs := map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 3: 4 * time.Minute, 20: 6 * time.Hour}
+	for attempts, want := range backoffs {
+		if got := service.webhookBackoff(attempts); got != want {
+			t.Errorf("Expected a backoff of %v after %d attempts, got %v", want, attempts, got)
+		}
+	}
+}
+
+func TestWebhookPrivateTargets(t *testing.T) {
+	ctx := context.Background()
+	receiver := &webhookReceiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithWebhookRetry(WebhookRetry{Attempts: 1}))
+
+	// Webhooks cannot target the local network
+	for _, target := range []string{server.URL, "http://localhost/hook", "http://10.0.0.1/hook", "http://[::1]/hook"} {
+		var invalidErr *model.ErrInvalidWebhook
+		if _, err := service.CreateWebhook(ctx, target, []model.WebhookEvent{model.EventLinkCreated}, ""); !errors.As(err, &invalidErr) {
+			t.Errorf("Expected ErrInvalidWebhook for %s, got %v", target, err)
+		}
+	}
+
+	// Names resolving to a private address by the time of delivery are not
+	// connected to
+	webhook := &model.Webhook{URL: server.URL, Events: []model.WebhookEvent{model.EventLinkCreated}}
+	if err := mockDB.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if _, err := service.DeliverWebhooks(ctx, time.Now()); err != nil {
+		t.Fatalf("Failed to deliver webhooks: %v", err)
+	}
+	log, err := service.ListWebhookDeliveries(ctx, webhook.ID, 10)
+	if err != nil {
+		t.Fatalf("Failed to list deliveries: %v", err)
+	}
+	if len(log) != 1 || len(log[0].Attempts) != 1 || !strings.Contains(log[0].Attempts[0].Error, "private address") {
+		t.Errorf("Expected an attempt refused for its private address, got %+v", log)
+	}
+	if len(receiver.requests) != 0 {
+		t.Errorf("Expected no requests, got %d", len(receiver.requests))
+	}
+}
+
+func TestWebhookRedirects(t *testing.T) {
+	ctx := context.Background()
+	var redirected bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			redirected = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusFound)
+	}))
+	defer server.Close()
+
+	mockDB := NewMockDatabase()
+	service := New(mockDB, WithWebhookRetry(WebhookRetry{Attempts: 1}), withPrivateTargets())
+	webhook, err := service.CreateWebhook(ctx, server.URL, []model.WebhookEvent{model.EventLinkCreated}, "")
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+	if _, err := service.DeliverWebhooks(ctx, time.Now()); err != nil {
+		t.Fatalf("Failed to deliver webhooks: %v", err)
+	}
+
+	// Redirects are not followed and fail the attempt
+	log, err := service.ListWebhookDeliveries(ctx, webhook.ID, 10)
+	if err != nil {
+		t.Fatalf("Failed to list deliveries: %v", err)
+	}
+	if len(log) != 1 || log[0].Status != model.DeliveryFailed || log[0].Attempts[0].StatusCode != http.StatusFound {
+		t.Errorf("Expected a delivery failed with status 302, got %+v", log)
+	}
+	if redirected {
+		t.Errorf("Expected the redirect not to be followed")
+	}
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	ctx := context.Background()
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(WebhookEventHeader)
+	}))
+	defer server.Close()
+
+	mockDB := NewMockDatabase()
+	service := New(mockDB, withPrivateTargets())
+	if _, err := service.CreateWebhook(ctx, server.URL, []model.WebhookEvent{model.EventLinkCreated}, ""); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if _, err := service.ShortenURL(ctx, model.ShortenRequest{LongURL: "https://example.com"}); err != nil {
+		t.Fatalf("Failed to shorten URL: %v", err)
+	}
+
+	// Queued events are delivered once the dispatcher starts
+	dispatcher := NewWebhookDispatcher(service, time.Hour)
+	dispatcher.Start(ctx)
+	select {
+	case event := <-received:
+		if event != string(model.EventLinkCreated) {
+			t.Errorf("Expected %s, got %s", model.EventLinkCreated, event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the queued event to be delivered")
+	}
+	dispatcher.Stop()
+
+	if mockDB.deliveries[0].Status != model.DeliveryDelivered {
+		t.Errorf("Expected the delivery to be delivered, got %s", mockDB.deliveries[0].Status)
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Click statistics limits
+const (
+	// maxStatsRange is the longest time range of click statistics
+	maxStatsRange = 366 * 24 * time.Hour
+
+	// maxHourlyRange is the longest time range of an hourly series
+	maxHourlyRange = 31 * 24 * time.Hour
+
+	// hourlyThreshold is the longest time range defaulting to an hourly series
+	hourlyThreshold = 48 * time.Hour
+
+	// statsTopValues is the number of values of the top lists
+	statsTopValues = 10
+)
+
+// GetStats aggregates the clicks of a URL from from until until. Without an
+// interval, ranges up to two days are counted per hour and longer ones per day.
+// Buckets start at full hours and days in UTC, so from is truncated to the
+// start of its bucket.
+func (s *URLService) GetStats(ctx context.Context, shortCode string, from, until time.Time, interval model.StatsInterval) (_ *model.ClickStats, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.GetStats",
+		attribute.String("url.short_code", shortCode),
+		attribute.String("stats.interval", string(interval)),
+	)
+	defer end(&err)
+
+	if interval == "" {
+		interval = model.StatsDaily
+		if until.Sub(from) <= hourlyThreshold {
+			interval = model.StatsHourly
+		}
+	}
+	if !interval.Valid() {
+		return nil, &model.ErrInvalidStatsRange{Reason: fmt.Sprintf("unknown interval '%s', expected hour or day", interval)}
+	}
+
+	from = from.UTC().Truncate(interval.Duration())
+	until = until.UTC()
+	switch {
+	case !until.After(from):
+		return nil, &model.ErrInvalidStatsRange{Reason: "the end must be after the start"}
+	case until.Sub(from) > maxStatsRange:
+		return nil, &model.ErrInvalidStatsRange{Reason: "the range must not exceed 366 days"}
+	case interval == model.StatsHourly && until.Sub(from) > maxHourlyRange:
+		return nil, &model.ErrInvalidStatsRange{Reason: "hourly ranges must not exceed 31 days"}
+	}
+
+	url, err := s.db.GetURLByShortCode(ctx, shortCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get URL: %w", err)
+	}
+	if url == nil {
+		return nil, &model.ErrURLNotFound{Code: shortCode}
+	}
+
+	stats, err := s.db.ClickStats(ctx, shortCode, from, until, interval, statsTopValues)
+	if err != nil {
+		return nil, err
+	}
+	stats.Series = fillSeries(stats.Series, from, until, interval.Duration())
+	return stats, nil
+}
+
+// fillSeries returns a series with a point for every bucket from from until
+// until, taking the clicks and unique visitors of the given sparse series
+func fillSeries(sparse []model.StatsPoint, from, until time.Time, step time.Duration) []model.StatsPoint {
+	series := make([]model.StatsPoint, 0, int(until.Sub(from)/step)+1)
+	i := 0
+	for t := from; t.Before(until); t = t.Add(step) {
+		point := model.StatsPoint{Time: t}
+		if i < len(sparse) && sparse[i].Time.Equal(t) {
+			point.Clicks, point.Uniques = sparse[i].Clicks, sparse[i].Uniques
+			i++
+		}
+		series = append(series, point)
+	}
+	return series
+}
+
+// RebuildRollups recomputes the hourly and daily click rollups of all URLs
+// from the stored click events and daily aggregates, keeping the hourly
+// rollups of rolled up days
+func (s *URLService) RebuildRollups(ctx context.Context) (err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.RebuildRollups")
+	defer end(&err)
+
+	return s.db.RebuildRollups(ctx)
+}
+
+// CompactRollups deletes the hourly click rollups of days older than keep
+// before now, keeping their daily rollups, and returns the number of hourly
+// rollups deleted. Hourly series of those days are no longer available.
+func (s *URLService) CompactRollups(ctx context.Context, now time.Time, keep time.Duration) (_ int64, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.CompactRollups")
+	defer end(&err)
+
+	if keep < 0 {
+		return 0, fmt.Errorf("the hourly rollups to keep must not be negative")
+	}
+	return s.db.CompactRollups(ctx, now.Add(-keep).UTC().Truncate(24*time.Hour))
+}
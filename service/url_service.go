@@ -1,102 +1,333 @@
 package service
 
 import (
-	"crypto/rand"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/mstgnz/self-hosted-url-shortener/database"
 	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/blocklist"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/metrics"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/shortcode"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/tracing"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/urlnorm"
 	"github.com/skip2/go-qrcode"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// Short code generation defaults
+const (
+	// defaultCodeLength is the initial length of generated short codes
+	defaultCodeLength = 6
+
+	// MaxCodeLength is the length generated short codes never grow beyond,
+	// and the longest initial length
+	MaxCodeLength = 16
+
+	// maxGenerateAttempts bounds the attempts to generate an unused short code
+	maxGenerateAttempts = 10
+)
+
+// tracer creates spans for URL service operations
+var tracer = otel.Tracer("github.com/mstgnz/self-hosted-url-shortener/service")
+
 // URLService handles the business logic for URL shortening
 type URLService struct {
-	db database.DatabaseInterface
+	db         database.DatabaseInterface
+	cache      *urlCache
+	policy     *shortcode.Policy
+	generator  shortcode.Generator
+	codeLength *shortcode.LengthTuner
+	dedupe     bool
+	normalizer *urlnorm.Normalizer
+	blocklist  *blocklist.List
+	salts      visitorSalts
+
+	ipMode            IPMode
+	respectDoNotTrack bool
+	retention         Retention
+
+	webhookClient  *http.Client
+	webhookRetry   WebhookRetry
+	webhooksQueued chan struct{}
+}
+
+// Option configures optional URL service behaviour
+type Option func(*URLService)
+
+// WithCacheTTL caches URL lookups for the given duration, a zero TTL disables caching
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(s *URLService) {
+		if ttl > 0 {
+			s.cache = newURLCache(ttl)
+		}
+	}
+}
+
+// WithCodePolicy validates custom codes and filters generated codes with the given policy
+func WithCodePolicy(policy *shortcode.Policy) Option {
+	return func(s *URLService) {
+		s.policy = policy
+	}
+}
+
+// WithCodeGenerator generates short codes with the given generator, starting at length characters
+func WithCodeGenerator(generator shortcode.Generator, length int) Option {
+	return func(s *URLService) {
+		s.generator = generator
+		s.codeLength = shortcode.NewLengthTuner(length, MaxCodeLength)
+	}
+}
+
+// WithDedupe returns the existing short URL when a destination is shortened again without a custom code
+func WithDedupe(enabled bool) Option {
+	return func(s *URLService) {
+		s.dedupe = enabled
+	}
+}
+
+// WithURLNormalizer validates and normalizes destinations with the given normalizer
+func WithURLNormalizer(normalizer *urlnorm.Normalizer) Option {
+	return func(s *URLService) {
+		s.normalizer = normalizer
+	}
+}
+
+// WithBlocklist rejects and disables destinations on the given blocklist
+func WithBlocklist(list *blocklist.List) Option {
+	return func(s *URLService) {
+		s.blocklist = list
+	}
 }
 
 // New creates a new URL service
-func New(db database.DatabaseInterface) *URLService {
-	return &URLService{db: db}
+func New(db database.DatabaseInterface, opts ...Option) *URLService {
+	generator, _ := shortcode.NewRandomGenerator(shortcode.Base62Alphabet)
+	s := &URLService{
+		db:         db,
+		policy:     shortcode.NewPolicy(shortcode.DefaultMinLength, shortcode.DefaultMaxLength),
+		generator:  generator,
+		codeLength: shortcode.NewLengthTuner(defaultCodeLength, MaxCodeLength),
+		normalizer: urlnorm.New(urlnorm.Config{}),
+		blocklist:  blocklist.New(),
+
+		ipMode:            IPModeOff,
+		respectDoNotTrack: true,
+
+		webhookRetry:   WebhookRetry{Attempts: defaultWebhookAttempts, Backoff: defaultWebhookBackoff},
+		webhooksQueued: make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.webhookClient == nil {
+		s.webhookClient = newWebhookClient(s.normalizer)
+	}
+	return s
 }
 
-// ShortenURL creates a shortened URL
-func (s *URLService) ShortenURL(longURL, customCode string) (*model.URL, error) {
-	// Validate the URL
-	if !strings.HasPrefix(longURL, "http://") && !strings.HasPrefix(longURL, "https://") {
-		longURL = "https://" + longURL
+// ShortenURL creates a shortened URL. With deduplication enabled, shortening a
+// destination without a custom code returns the oldest short URL redirecting
+// the same way to it instead, whether its code was generated or custom.
+func (s *URLService) ShortenURL(ctx context.Context, req model.ShortenRequest) (_ *model.URL, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.ShortenURL", attribute.String("url.custom_code", req.CustomCode))
+	defer end(&err)
+
+	if !req.RedirectType.Valid() {
+		return nil, &model.ErrInvalidRedirectType{Type: string(req.RedirectType)}
+	}
+	if !req.QueryPassthrough.Valid() {
+		return nil, &model.ErrInvalidQueryPassthrough{Mode: string(req.QueryPassthrough)}
+	}
+	utm, err := normalizeUTM(req.UTM)
+	if err != nil {
+		return nil, err
 	}
 
-	var shortCode string
-	if customCode != "" {
-		// Check if the custom code is already in use
-		existingURL, err := s.db.GetURLByShortCode(customCode)
-		if err != nil {
-			return nil, fmt.Errorf("error checking custom code: %w", err)
+	// Validate and normalize the destinations
+	var longURL string
+	var variants []model.Variant
+	if len(req.Variants) > 0 {
+		if req.LongURL != "" {
+			return nil, &model.ErrInvalidVariants{Reason: "a URL with variants cannot have its own destination"}
 		}
-		if existingURL != nil {
-			return nil, fmt.Errorf("custom code '%s' is already in use", customCode)
+		if variants, err = s.normalizeVariants(ctx, req.Variants, req.StripTracking, utm); err != nil {
+			return nil, err
 		}
-		shortCode = customCode
-	} else {
-		// Generate a random short code
-		var err error
-		shortCode, err = generateShortCode(6)
+		longURL = variants[0].LongURL
+	} else if longURL, err = s.normalizeDestination(ctx, req.LongURL, req.StripTracking, utm); err != nil {
+		return nil, err
+	}
+
+	rules, err := s.normalizeRules(ctx, req.Rules, req.StripTracking, utm)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := s.normalizeSchedule(ctx, req.Schedule, len(variants) > 0, req.StripTracking, utm)
+	if err != nil {
+		return nil, err
+	}
+
+	url := model.NewURL(req.CustomCode, longURL)
+	url.RedirectType = req.RedirectType
+	url.PathPassthrough = req.PathPassthrough
+	url.QueryPassthrough = req.QueryPassthrough
+	url.UTM = utm
+	url.StripTracking = req.StripTracking
+	url.Variants = variants
+	url.StickyVariants = req.StickyVariants && len(variants) > 0
+	url.Rules = rules
+	url.Schedule = schedule
+	if len(variants) == 0 {
+		// URLs with variants are never deduplicated
+		url.DestHash = hashDestination(longURL)
+	}
+
+	// Return the existing short URL for the same destination
+	if s.dedupe && url.DestHash != "" && req.CustomCode == "" && !req.ForceNew {
+		existingURL, err := s.db.GetURLByDestHash(ctx, url.DestHash)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate short code: %w", err)
+			return nil, fmt.Errorf("error checking destination: %w", err)
 		}
-
-		// Ensure the generated code is unique
-		for {
-			existingURL, err := s.db.GetURLByShortCode(shortCode)
-			if err != nil {
-				return nil, fmt.Errorf("error checking short code: %w", err)
-			}
-			if existingURL == nil {
-				break
-			}
-			shortCode, err = generateShortCode(6)
-			if err != nil {
-				return nil, fmt.Errorf("failed to generate short code: %w", err)
-			}
+		if existingURL != nil && sameOptions(existingURL, url) {
+			return existingURL, nil
 		}
 	}
 
-	// Create and save the URL
-	url := model.NewURL(shortCode, longURL)
-	if err := s.db.SaveURL(url); err != nil {
-		return nil, fmt.Errorf("failed to save URL: %w", err)
+	if req.CustomCode != "" {
+		// Check the custom code against the code policy
+		if err := s.policy.Validate(req.CustomCode); err != nil {
+			return nil, err
+		}
+
+		// Save the URL, the database rejects custom codes that are already in use
+		if err := s.saveURL(ctx, url); err != nil {
+			return nil, err
+		}
+	} else {
+		// Save the URL under a generated short code
+		if err := s.saveWithGeneratedCode(ctx, url); err != nil {
+			return nil, err
+		}
 	}
 
+	metrics.URLsShortened.Inc()
+	s.emit(ctx, model.WebhookPayload{Event: model.EventLinkCreated, Time: url.CreatedAt, URL: url})
 	return url, nil
 }
 
+// saveURL saves a URL, passing through the error for a taken short code
+func (s *URLService) saveURL(ctx context.Context, url *model.URL) error {
+	err := s.db.SaveURL(ctx, url)
+	var exists *model.ErrCustomCodeAlreadyExists
+	if errors.As(err, &exists) {
+		return exists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save URL: %w", err)
+	}
+	return nil
+}
+
 // GetURL retrieves a URL by its short code
-func (s *URLService) GetURL(shortCode string) (*model.URL, error) {
-	url, err := s.db.GetURLByShortCode(shortCode)
+func (s *URLService) GetURL(ctx context.Context, shortCode string) (_ *model.URL, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.GetURL", attribute.String("url.short_code", shortCode))
+	defer end(&err)
+
+	if s.cache != nil {
+		if url, ok := s.cache.get(shortCode); ok {
+			return url, nil
+		}
+	}
+
+	url, err := s.db.GetURLByShortCode(ctx, shortCode)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get URL: %w", err)
 	}
+
+	if s.cache != nil && url != nil {
+		s.cache.set(url)
+	}
 	return url, nil
 }
 
-// RecordClick records a click on a URL
-func (s *URLService) RecordClick(shortCode string) error {
-	return s.db.IncrementClicks(shortCode)
+// RecordClick records a click on a URL with a hash of the visitor counting
+// unique visitors. Visits asking not to be tracked have no visitor, and the IP
+// address is stored as configured by the IP mode.
+func (s *URLService) RecordClick(ctx context.Context, click model.Click) (err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.RecordClick",
+		attribute.String("url.short_code", click.ShortCode),
+		attribute.String("url.variant", click.Variant),
+	)
+	defer end(&err)
+
+	if click.ClickedAt.IsZero() {
+		click.ClickedAt = time.Now()
+	}
+	if !click.Bot && !(click.DoNotTrack && s.respectDoNotTrack) {
+		if click.Visitor, err = s.visitorHash(ctx, click); err != nil {
+			return err
+		}
+	}
+	s.privatize(&click)
+	if err := s.db.RecordClick(ctx, click); err != nil {
+		return err
+	}
+
+	if !click.Bot {
+		s.emit(ctx, clickPayload(click))
+	}
+	return nil
 }
 
-// ListURLs retrieves all URLs
-func (s *URLService) ListURLs() ([]*model.URL, error) {
-	return s.db.ListURLs()
+// ListURLs retrieves the URLs matching filter, newest first. Campaigns are
+// matched like they are stored, without surrounding whitespace.
+func (s *URLService) ListURLs(ctx context.Context, filter model.URLFilter) (_ []*model.URL, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.ListURLs")
+	defer end(&err)
+
+	filter.Campaign = strings.TrimSpace(filter.Campaign)
+	return s.db.ListURLs(ctx, filter)
 }
 
 // DeleteURL deletes a URL by its short code
-func (s *URLService) DeleteURL(shortCode string) error {
-	return s.db.DeleteURL(shortCode)
+func (s *URLService) DeleteURL(ctx context.Context, shortCode string) (err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.DeleteURL", attribute.String("url.short_code", shortCode))
+	defer end(&err)
+
+	// The deleted URL is sent to webhooks
+	url, err := s.db.GetURLByShortCode(ctx, shortCode)
+	if err != nil {
+		return err
+	}
+	if err := s.db.DeleteURL(ctx, shortCode); err != nil {
+		return err
+	}
+
+	if s.cache != nil {
+		s.cache.delete(shortCode)
+	}
+	metrics.URLsDeleted.Inc()
+	if url != nil {
+		s.emit(ctx, model.WebhookPayload{Event: model.EventLinkDeleted, Time: time.Now(), URL: url})
+	}
+	return nil
 }
 
 // GenerateQRCode generates a QR code for a URL
-func (s *URLService) GenerateQRCode(shortURL string) ([]byte, error) {
+func (s *URLService) GenerateQRCode(ctx context.Context, shortURL string) (_ []byte, err error) {
+	_, end := tracing.Start(ctx, tracer, "URLService.GenerateQRCode")
+	defer end(&err)
+
 	qr, err := qrcode.Encode(shortURL, qrcode.Medium, 256)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate QR code: %w", err)
@@ -104,18 +335,175 @@ func (s *URLService) GenerateQRCode(shortURL string) ([]byte, error) {
 	return qr, nil
 }
 
-// generateShortCode generates a random short code of the specified length
-func generateShortCode(length int) (string, error) {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	randomBytes := make([]byte, length)
-	_, err := rand.Read(randomBytes)
+// CheckDestination returns ErrBlockedURL when the destination of a URL is blocked
+func (s *URLService) CheckDestination(ctx context.Context, longURL string) error {
+	return s.checkBlocked(longURL, "redirect")
+}
+
+// normalizeDestination validates and normalizes a destination, checks it
+// against the blocklist and appends the campaign parameters
+func (s *URLService) normalizeDestination(ctx context.Context, rawURL string, stripTracking bool, utm model.UTM) (string, error) {
+	longURL, err := s.normalizer.Normalize(ctx, rawURL, stripTracking)
 	if err != nil {
 		return "", err
 	}
+	if err := s.checkBlocked(longURL, "create"); err != nil {
+		return "", err
+	}
+	return appendUTM(longURL, utm)
+}
+
+// checkBlocked checks a destination against the blocklist, counting blocked
+// destinations for the given stage
+func (s *URLService) checkBlocked(longURL, stage string) error {
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return &model.ErrInvalidURL{URL: longURL, Reason: "URL cannot be parsed"}
+	}
+
+	if domain, blocked := s.blocklist.Blocked(u.Hostname()); blocked {
+		metrics.BlockedDestinations.WithLabelValues(stage).Inc()
+		return &model.ErrBlockedURL{URL: longURL, Domain: domain}
+	}
+	return nil
+}
+
+// LoadBlockedDomains adds the domains blocked by an administrator to the blocklist
+func (s *URLService) LoadBlockedDomains(ctx context.Context) error {
+	domains, err := s.db.ListBlockedDomains(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load blocked domains: %w", err)
+	}
+
+	for _, domain := range domains {
+		if err := s.blocklist.Add(domain.Domain); err != nil {
+			return fmt.Errorf("failed to load blocked domains: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListBlockedDomains returns the domains blocked by an administrator
+func (s *URLService) ListBlockedDomains(ctx context.Context) (_ []*model.BlockedDomain, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.ListBlockedDomains")
+	defer end(&err)
+
+	return s.db.ListBlockedDomains(ctx)
+}
+
+// BlockDomain blocks a destination domain and its subdomains, returning the normalized domain
+func (s *URLService) BlockDomain(ctx context.Context, domain string) (_ string, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.BlockDomain", attribute.String("blocklist.domain", domain))
+	defer end(&err)
+
+	normalized, err := blocklist.Normalize(domain)
+	if err != nil {
+		return "", &model.ErrInvalidDomain{Domain: domain}
+	}
 
-	for i, b := range randomBytes {
-		randomBytes[i] = charset[b%byte(len(charset))]
+	if err := s.db.AddBlockedDomain(ctx, normalized); err != nil {
+		return "", err
 	}
+	if err := s.blocklist.Add(normalized); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
+// UnblockDomain removes a domain blocked by an administrator
+func (s *URLService) UnblockDomain(ctx context.Context, domain string) (err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.UnblockDomain", attribute.String("blocklist.domain", domain))
+	defer end(&err)
+
+	normalized, err := blocklist.Normalize(domain)
+	if err != nil {
+		return &model.ErrInvalidDomain{Domain: domain}
+	}
+
+	if err := s.db.RemoveBlockedDomain(ctx, normalized); err != nil {
+		return err
+	}
+	s.blocklist.Remove(normalized)
+	return nil
+}
+
+// Ready checks that the database is reachable and up to date
+func (s *URLService) Ready(ctx context.Context) error {
+	return s.db.Ready(ctx)
+}
+
+// saveWithGeneratedCode saves a URL under a generated short code that is
+// allowed by the code policy, retrying with a new code when it is taken
+func (s *URLService) saveWithGeneratedCode(ctx context.Context, url *model.URL) error {
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		shortCode, err := s.generator.Generate(ctx, s.codeLength.Length())
+		if err != nil {
+			return fmt.Errorf("failed to generate short code: %w", err)
+		}
+
+		if !s.policy.Allowed(shortCode) {
+			continue
+		}
+
+		url.ShortCode = shortCode
+		err = s.saveURL(ctx, url)
+		var exists *model.ErrCustomCodeAlreadyExists
+		collided := errors.As(err, &exists)
+		s.codeLength.Record(collided)
+		if collided {
+			continue
+		}
+		return err
+	}
+
+	return fmt.Errorf("failed to generate a unique short code after %d attempts", maxGenerateAttempts)
+}
+
+// HashDestinations hashes the destinations of URLs saved before destinations
+// were hashed, so deduplication finds them, and returns the number hashed
+func (s *URLService) HashDestinations(ctx context.Context) (_ int, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.HashDestinations")
+	defer end(&err)
+
+	urls, err := s.db.URLsWithoutDestHash(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(urls) == 0 {
+		return 0, nil
+	}
+
+	hashes := make(map[int64]string, len(urls))
+	for _, url := range urls {
+		hashes[url.ID] = hashDestination(url.LongURL)
+	}
+	if err := s.db.SetDestHashes(ctx, hashes); err != nil {
+		return 0, err
+	}
+	return len(hashes), nil
+}
+
+// hashDestination returns a hash identifying a normalized destination
+// regardless of an empty path or the order of its query parameters
+func hashDestination(longURL string) string {
+	normalized := longURL
+	if u, err := url.Parse(longURL); err == nil {
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		u.RawQuery = u.Query().Encode()
+		normalized = u.String()
+	}
+
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
 
-	return string(randomBytes), nil
+// sameOptions reports whether two URLs to the same destination redirect the same way
+func sameOptions(a, b *model.URL) bool {
+	return a.RedirectType == b.RedirectType &&
+		a.PathPassthrough == b.PathPassthrough &&
+		a.QueryPassthrough == b.QueryPassthrough &&
+		len(a.Rules) == 0 && len(b.Rules) == 0 &&
+		a.Schedule.IsZero() && b.Schedule.IsZero()
 }
@@ -1,24 +1,65 @@
 package service
 
-import "github.com/mstgnz/self-hosted-url-shortener/model"
+import (
+	"context"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
 
 // URLServiceInterface defines the interface for URL service operations
 type URLServiceInterface interface {
 	// ShortenURL creates a shortened URL
-	ShortenURL(longURL, customCode string) (*model.URL, error)
+	ShortenURL(ctx context.Context, req model.ShortenRequest) (*model.URL, error)
 
 	// GetURL retrieves a URL by its short code
-	GetURL(shortCode string) (*model.URL, error)
+	GetURL(ctx context.Context, shortCode string) (*model.URL, error)
+
+	// SetRules replaces the routing rules of a URL
+	SetRules(ctx context.Context, shortCode string, rules []model.Rule) (*model.URL, error)
+
+	// SetSchedule replaces the activation window and scheduled changes of a URL
+	SetSchedule(ctx context.Context, shortCode string, schedule model.Schedule) (*model.URL, error)
+
+	// GetStats aggregates the clicks of a URL within a time range
+	GetStats(ctx context.Context, shortCode string, from, until time.Time, interval model.StatsInterval) (*model.ClickStats, error)
 
 	// RecordClick records a click for a URL
-	RecordClick(shortCode string) error
+	RecordClick(ctx context.Context, click model.Click) error
 
-	// ListURLs returns all URLs
-	ListURLs() ([]*model.URL, error)
+	// ListURLs returns the URLs matching a filter
+	ListURLs(ctx context.Context, filter model.URLFilter) ([]*model.URL, error)
 
 	// DeleteURL deletes a URL
-	DeleteURL(shortCode string) error
+	DeleteURL(ctx context.Context, shortCode string) error
 
 	// GenerateQRCode generates a QR code for a URL
-	GenerateQRCode(shortURL string) ([]byte, error)
+	GenerateQRCode(ctx context.Context, shortURL string) ([]byte, error)
+
+	// CheckDestination returns ErrBlockedURL when the destination of a URL is blocked
+	CheckDestination(ctx context.Context, longURL string) error
+
+	// ListBlockedDomains returns the domains blocked by an administrator
+	ListBlockedDomains(ctx context.Context) ([]*model.BlockedDomain, error)
+
+	// BlockDomain blocks a destination domain and its subdomains, returning the normalized domain
+	BlockDomain(ctx context.Context, domain string) (string, error)
+
+	// UnblockDomain removes a domain blocked by an administrator
+	UnblockDomain(ctx context.Context, domain string) error
+
+	// CreateWebhook subscribes a URL to events, generating a secret when none is given
+	CreateWebhook(ctx context.Context, url string, events []model.WebhookEvent, secret string) (*model.Webhook, error)
+
+	// ListWebhooks returns all webhooks without their secrets
+	ListWebhooks(ctx context.Context) ([]*model.Webhook, error)
+
+	// DeleteWebhook deletes a webhook and its deliveries
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// ListWebhookDeliveries returns the latest deliveries of a webhook with their attempts
+	ListWebhookDeliveries(ctx context.Context, id int64, limit int) ([]*model.WebhookDelivery, error)
+
+	// Ready checks that the service can serve requests
+	Ready(ctx context.Context) error
 }
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/urlnorm"
+)
+
+// maxUTMLength is the maximum length of a campaign parameter in characters
+const maxUTMLength = 200
+
+// normalizeUTM trims and validates campaign parameters. Analytics tools
+// attribute visits by their source, so it is required with any other parameter.
+func normalizeUTM(utm model.UTM) (model.UTM, error) {
+	for name, value := range utm.Fields() {
+		*value = strings.TrimSpace(*value)
+		if utf8.RuneCountInString(*value) > maxUTMLength {
+			return model.UTM{}, &model.ErrInvalidUTM{Param: name, Reason: fmt.Sprintf("longer than %d characters", maxUTMLength)}
+		}
+		if strings.ContainsFunc(*value, unicode.IsControl) {
+			return model.UTM{}, &model.ErrInvalidUTM{Param: name, Reason: "contains control characters"}
+		}
+	}
+
+	if !utm.IsZero() && utm.Source == "" {
+		return model.UTM{}, &model.ErrInvalidUTM{Param: "utm_source", Reason: "required with other campaign parameters"}
+	}
+	return utm, nil
+}
+
+// appendUTM sets the campaign parameters on a destination, replacing any it already has
+func appendUTM(longURL string, utm model.UTM) (string, error) {
+	if utm.IsZero() {
+		return longURL, nil
+	}
+
+	u, err := url.Parse(longURL)
+	if err != nil {
+		return "", &model.ErrInvalidURL{URL: longURL, Reason: err.Error()}
+	}
+	query := u.Query()
+	for name, values := range utm.Values() {
+		query[name] = values
+	}
+	u.RawQuery = query.Encode()
+
+	tagged := u.String()
+	if len(tagged) > urlnorm.MaxLength {
+		return "", &model.ErrInvalidURL{URL: longURL, Reason: fmt.Sprintf("URL with campaign parameters is longer than %d characters", urlnorm.MaxLength)}
+	}
+	return tagged, nil
+}
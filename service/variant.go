@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// Variant limits
+const (
+	// maxVariants is the maximum number of variants of a URL
+	maxVariants = 10
+
+	// maxVariantWeight is the maximum weight of a variant
+	maxVariantWeight = 1000
+)
+
+// variantNamePattern matches valid variant names
+var variantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// normalizeVariants validates the variants of a URL and normalizes their
+// destinations. Variants without a name get the first of a, b, c and so on
+// not named explicitly, and variants without a weight get a weight of 1.
+func (s *URLService) normalizeVariants(ctx context.Context, variants []model.Variant, stripTracking bool, utm model.UTM) ([]model.Variant, error) {
+	if len(variants) < 2 {
+		return nil, &model.ErrInvalidVariants{Reason: "at least 2 variants are required"}
+	}
+	if len(variants) > maxVariants {
+		return nil, &model.ErrInvalidVariants{Reason: fmt.Sprintf("at most %d variants are allowed", maxVariants)}
+	}
+
+	explicit := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		explicit[variant.Name] = true
+	}
+
+	normalized := make([]model.Variant, len(variants))
+	seen := make(map[string]bool, len(variants))
+	next := 'a'
+	for i, variant := range variants {
+		name := variant.Name
+		if name == "" {
+			for explicit[string(next)] {
+				next++
+			}
+			name = string(next)
+			next++
+		}
+		if !variantNamePattern.MatchString(name) {
+			return nil, &model.ErrInvalidVariants{Reason: fmt.Sprintf("name '%s' may only contain letters, digits, '-' and '_'", name)}
+		}
+		if seen[name] {
+			return nil, &model.ErrInvalidVariants{Reason: fmt.Sprintf("name '%s' is used more than once", name)}
+		}
+		seen[name] = true
+
+		weight := variant.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if weight < 0 || weight > maxVariantWeight {
+			return nil, &model.ErrInvalidVariants{Reason: fmt.Sprintf("weight of '%s' must be between 1 and %d", name, maxVariantWeight)}
+		}
+
+		longURL, err := s.normalizeDestination(ctx, variant.LongURL, stripTracking, utm)
+		if err != nil {
+			return nil, err
+		}
+
+		normalized[i] = model.Variant{Name: name, LongURL: longURL, Weight: weight}
+	}
+
+	return normalized, nil
+}
+
+// ChooseVariant picks the variant of a URL to serve a visit, or nil for URLs
+// without variants. With sticky variants, the variant named by previous is
+// served again as long as it exists. Otherwise a variant is picked at random
+// in proportion to its weight.
+func ChooseVariant(url *model.URL, previous string) *model.Variant {
+	if len(url.Variants) == 0 {
+		return nil
+	}
+
+	if url.StickyVariants && previous != "" {
+		if variant := url.Variant(previous); variant != nil {
+			return variant
+		}
+	}
+
+	total := 0
+	for _, variant := range url.Variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return &url.Variants[0]
+	}
+
+	n := rand.IntN(total)
+	for i := range url.Variants {
+		n -= url.Variants[i].Weight
+		if n < 0 {
+			return &url.Variants[i]
+		}
+	}
+	return &url.Variants[len(url.Variants)-1]
+}
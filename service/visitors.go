@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+)
+
+// visitorHashSize is the length of visitor hashes in bytes
+const visitorHashSize = 16
+
+// visitorSalts caches the visitor salt of the most recent day
+type visitorSalts struct {
+	mu   sync.Mutex
+	day  string
+	salt []byte
+}
+
+// salt returns the visitor salt of the UTC day of t, loading it from the
+// database when the day changes
+func (s *URLService) salt(ctx context.Context, t time.Time) ([]byte, error) {
+	s.salts.mu.Lock()
+	defer s.salts.mu.Unlock()
+
+	day := t.UTC().Format(time.DateOnly)
+	if s.salts.day == day {
+		return s.salts.salt, nil
+	}
+	salt, err := s.db.VisitorSalt(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	s.salts.day, s.salts.salt = day, salt
+	return salt, nil
+}
+
+// visitorHash identifies the visitor of a click among the visitors of the
+// URL on the same day. The hash covers the IP address, User-Agent and short
+// code with a salt changing daily, so it cannot be traced back to the visitor
+// nor linked across days or URLs. Clicks without an IP address and User-Agent
+// have no visitor.
+func (s *URLService) visitorHash(ctx context.Context, click model.Click) (string, error) {
+	if click.IP == "" && click.UserAgent == "" {
+		return "", nil
+	}
+
+	salt, err := s.salt(ctx, click.ClickedAt)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	for _, part := range [][]byte{salt, []byte(click.ShortCode), []byte(click.IP), []byte(click.UserAgent)} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:visitorHashSize]), nil
+}
@@ -0,0 +1,408 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mstgnz/self-hosted-url-shortener/model"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/metrics"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/tracing"
+	"github.com/mstgnz/self-hosted-url-shortener/pkg/urlnorm"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Webhook delivery defaults
+const (
+	// defaultWebhookAttempts is the number of attempts before a delivery fails
+	defaultWebhookAttempts = 8
+
+	// defaultWebhookBackoff is the delay before the first retry, doubling with every further retry
+	defaultWebhookBackoff = 30 * time.Second
+
+	// maxWebhookBackoff is the longest delay between two attempts
+	maxWebhookBackoff = 6 * time.Hour
+
+	// webhookTimeout bounds the time a webhook takes to respond
+	webhookTimeout = 10 * time.Second
+
+	// webhookLease is how long claimed deliveries are held before they are
+	// claimed again, which must exceed the time to attempt a whole batch
+	webhookLease = 5 * time.Minute
+
+	// webhookBatch is the number of deliveries claimed at once
+	webhookBatch = 20
+
+	// maxWebhookLog is the number of deliveries returned by the delivery log
+	maxWebhookLog = 100
+)
+
+// Webhook request headers
+const (
+	// WebhookEventHeader holds the event of the delivery
+	WebhookEventHeader = "X-Webhook-Event"
+
+	// WebhookDeliveryHeader holds the ID of the delivery, which stays the same across retries
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+
+	// WebhookTimestampHeader holds the Unix time the request was signed at
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+
+	// WebhookSignatureHeader holds the signature of the request computed by WebhookSignature
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookRetry sets how often and when failed webhook deliveries are retried
+type WebhookRetry struct {
+	// Attempts is the number of attempts before a delivery fails
+	Attempts int
+
+	// Backoff is the delay before the first retry, doubling with every
+	// further retry up to six hours
+	Backoff time.Duration
+}
+
+// WithWebhookRetry retries failed webhook deliveries as set by retry
+func WithWebhookRetry(retry WebhookRetry) Option {
+	return func(s *URLService) {
+		if retry.Attempts > 0 {
+			s.webhookRetry.Attempts = retry.Attempts
+		}
+		if retry.Backoff > 0 {
+			s.webhookRetry.Backoff = retry.Backoff
+		}
+	}
+}
+
+// WithWebhookClient delivers webhooks with the given HTTP client instead of
+// one connecting only to targets accepted by the URL normalizer
+func WithWebhookClient(client *http.Client) Option {
+	return func(s *URLService) {
+		s.webhookClient = client
+	}
+}
+
+// newWebhookClient returns a client connecting only to addresses accepted by
+// normalizer. It does not follow redirects, whose targets were not checked
+// when the webhook was created, nor use a proxy, which would be dialed
+// instead of the webhook.
+func newWebhookClient(normalizer *urlnorm.Normalizer) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   webhookTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   normalizer.DialControl(),
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// WebhookSignature returns the signature of a webhook request sent at
+// timestamp: the hex encoded HMAC-SHA256 of the timestamp, a dot and the body
+// keyed with the secret of the webhook, prefixed with "sha256="
+func WebhookSignature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateWebhook subscribes a URL to events. A secret is generated when none
+// is given, the returned webhook is the only place it is shown.
+func (s *URLService) CreateWebhook(ctx context.Context, rawURL string, events []model.WebhookEvent, secret string) (_ *model.Webhook, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.CreateWebhook")
+	defer end(&err)
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, &model.ErrInvalidWebhook{Reason: fmt.Sprintf("'%s' is not an absolute http or https URL", rawURL)}
+	}
+	if err := s.normalizer.CheckHost(ctx, u.Hostname()); err != nil {
+		return nil, &model.ErrInvalidWebhook{Reason: err.Error()}
+	}
+	if len(events) == 0 {
+		return nil, &model.ErrInvalidWebhook{Reason: "at least one event is required"}
+	}
+	var subscribed []model.WebhookEvent
+	for _, event := range events {
+		if !event.Valid() {
+			return nil, &model.ErrInvalidWebhook{Reason: fmt.Sprintf("unknown event '%s', expected link.created, link.deleted or link.clicked", event)}
+		}
+		if !slices.Contains(subscribed, event) {
+			subscribed = append(subscribed, event)
+		}
+	}
+
+	if secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+		}
+		secret = hex.EncodeToString(key)
+	}
+
+	webhook := &model.Webhook{
+		URL:       u.String(),
+		Events:    subscribed,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	if err := s.db.CreateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// ListWebhooks returns all webhooks without their secrets
+func (s *URLService) ListWebhooks(ctx context.Context) (_ []*model.Webhook, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.ListWebhooks")
+	defer end(&err)
+
+	webhooks, err := s.db.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook deletes a webhook and its pending and past deliveries
+func (s *URLService) DeleteWebhook(ctx context.Context, id int64) (err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.DeleteWebhook", attribute.Int64("webhook.id", id))
+	defer end(&err)
+
+	return s.db.DeleteWebhook(ctx, id)
+}
+
+// ListWebhookDeliveries returns the latest deliveries of a webhook with their
+// attempts, newest first. The number of deliveries is capped at 100.
+func (s *URLService) ListWebhookDeliveries(ctx context.Context, id int64, limit int) (_ []*model.WebhookDelivery, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.ListWebhookDeliveries", attribute.Int64("webhook.id", id))
+	defer end(&err)
+
+	if limit <= 0 || limit > maxWebhookLog {
+		limit = maxWebhookLog
+	}
+	return s.db.ListWebhookDeliveries(ctx, id, limit)
+}
+
+// emit queues the delivery of an event to the webhooks subscribing to it.
+// Failures are logged rather than returned, so they never fail the change
+// causing the event.
+func (s *URLService) emit(ctx context.Context, payload model.WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode webhook payload", "event", payload.Event, "error", err)
+		return
+	}
+
+	n, err := s.db.EnqueueWebhookEvent(ctx, payload.Event, body, payload.Time)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to queue webhook event", "event", payload.Event, "error", err)
+		return
+	}
+	if n > 0 {
+		select {
+		case s.webhooksQueued <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// clickPayload returns the webhook payload of a click
+func clickPayload(click model.Click) model.WebhookPayload {
+	return model.WebhookPayload{
+		Event: model.EventLinkClicked,
+		Time:  click.ClickedAt,
+		Click: &model.WebhookClick{
+			ShortCode: click.ShortCode,
+			Variant:   click.Variant,
+			Country:   click.Country,
+			Region:    click.Region,
+			Referrer:  click.Referrer,
+			Browser:   click.Browser,
+			OS:        click.OS,
+			Language:  click.Language,
+			ClickedAt: click.ClickedAt,
+		},
+	}
+}
+
+// DeliverWebhooks attempts the deliveries due at now and returns the number
+// attempted. Deliveries failing with a network error or a status other than
+// 2xx are retried with exponential backoff until their last attempt fails.
+func (s *URLService) DeliverWebhooks(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, end := tracing.Start(ctx, tracer, "URLService.DeliverWebhooks")
+	defer end(&err)
+
+	deliveries, err := s.db.ClaimWebhookDeliveries(ctx, now, webhookLease, webhookBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, delivery := range deliveries {
+		// Canceling ctx lets the running attempt finish, the remaining claimed
+		// deliveries are attempted once their lease ends
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+		if err := s.deliver(context.WithoutCancel(ctx), delivery); err != nil {
+			return i, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// deliver attempts a delivery and records the attempt
+func (s *URLService) deliver(ctx context.Context, delivery *model.WebhookDelivery) error {
+	attempt := s.postWebhook(ctx, delivery)
+
+	status, result := model.DeliveryDelivered, "delivered"
+	var next time.Time
+	switch {
+	case attempt.Error == "":
+	case len(delivery.Attempts)+1 >= s.webhookRetry.Attempts:
+		status, result = model.DeliveryFailed, "failed"
+	default:
+		status, result = model.DeliveryPending, "retry"
+		next = attempt.AttemptedAt.Add(s.webhookBackoff(len(delivery.Attempts) + 1))
+	}
+	metrics.WebhookAttempts.WithLabelValues(result).Inc()
+
+	log := slog.With("webhook", delivery.WebhookID, "delivery", delivery.ID, "event", delivery.Event)
+	switch status {
+	case model.DeliveryFailed:
+		log.WarnContext(ctx, "Webhook delivery failed", "attempts", len(delivery.Attempts)+1, "error", attempt.Error)
+	case model.DeliveryPending:
+		log.InfoContext(ctx, "Webhook delivery will be retried", "at", next.UTC().Format(time.RFC3339), "error", attempt.Error)
+	}
+
+	return s.db.RecordWebhookAttempt(ctx, delivery.ID, attempt, status, next)
+}
+
+// webhookBackoff returns the delay after the given number of failed attempts
+func (s *URLService) webhookBackoff(attempts int) time.Duration {
+	backoff := s.webhookRetry.Backoff
+	for i := 1; i < attempts && backoff < maxWebhookBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxWebhookBackoff)
+}
+
+// postWebhook POSTs the payload of a delivery to its webhook
+func (s *URLService) postWebhook(ctx context.Context, delivery *model.WebhookDelivery) (attempt model.WebhookAttempt) {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	attempt.AttemptedAt = time.Now()
+	defer func() {
+		attempt.DurationMS = time.Since(attempt.AttemptedAt).Milliseconds()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := attempt.AttemptedAt.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-shortener-webhooks")
+	req.Header.Set(WebhookEventHeader, string(delivery.Event))
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return attempt
+}
+
+// WebhookDispatcher delivers queued webhook events in the background
+type WebhookDispatcher struct {
+	urlService *URLService
+	interval   time.Duration
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewWebhookDispatcher creates a dispatcher delivering the events queued by
+// the service right away and looking for retries that are due every interval
+func NewWebhookDispatcher(urlService *URLService, interval time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{urlService: urlService, interval: interval}
+}
+
+// Start starts delivering webhook events in a goroutine
+func (d *WebhookDispatcher) Start(ctx context.Context) {
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.loop(ctx)
+	}()
+}
+
+// Stop stops delivering webhook events and waits for running attempts
+func (d *WebhookDispatcher) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+}
+
+// loop delivers due events until ctx is canceled
+func (d *WebhookDispatcher) loop(ctx context.Context) {
+	for {
+		// Keep going while full batches are due
+		for {
+			n, err := d.urlService.DeliverWebhooks(ctx, time.Now())
+			if err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "Failed to deliver webhooks", "error", err)
+			}
+			if err != nil || n < webhookBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.urlService.webhooksQueued:
+		case <-time.After(d.interval):
+		}
+	}
+}